@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Alert states
+const (
+	AlertFiring       = "firing"
+	AlertAcknowledged = "acknowledged"
+	AlertResolved     = "resolved"
+)
+
+// Rule operators. Threshold operators compare the metric with Value; the
+// change operators compare the difference from the previous evaluation.
+var alertOperators = map[string]bool{
+	">": true, ">=": true, "<": true, "<=": true,
+	"rise": true, "drop": true,
+}
+
+// currentMetrics are read from CurrentCondition
+var currentMetrics = map[string]bool{
+	"temp_c": true, "feels_like_c": true, "humidity": true,
+	"windspeed_kmph": true, "visibility_km": true,
+}
+
+// dayMetrics are read from a forecast day in Weather
+var dayMetrics = map[string]bool{
+	"max_temp_c": true, "min_temp_c": true, "chance_of_rain": true,
+	"chance_of_snow": true, "max_wind_kmph": true,
+}
+
+// AlertRule is a condition evaluated against each of its locations.
+//
+// Location "*" applies the rule to every entry in favourites. Day selects a
+// forecast day (0 today, 1 tomorrow) for day metrics; it is ignored for
+// current-condition metrics.
+type AlertRule struct {
+	Name     string  `json:"name"`
+	Location string  `json:"location"`
+	Metric   string  `json:"metric"`
+	Day      int     `json:"day"`
+	Operator string  `json:"operator"`
+	Value    float64 `json:"value"`
+}
+
+// validate checks that the rule can be evaluated
+func (r AlertRule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule without a name")
+	}
+	if r.Location == "" {
+		return fmt.Errorf("rule %q: location is required", r.Name)
+	}
+	if !currentMetrics[r.Metric] && !dayMetrics[r.Metric] {
+		return fmt.Errorf("rule %q: unknown metric %q", r.Name, r.Metric)
+	}
+	if dayMetrics[r.Metric] && (r.Day < 0 || r.Day >= MaxForecastDays) {
+		return fmt.Errorf("rule %q: day must be between 0 and %d", r.Name, MaxForecastDays-1)
+	}
+	if !alertOperators[r.Operator] {
+		return fmt.Errorf("rule %q: unknown operator %q", r.Name, r.Operator)
+	}
+	return nil
+}
+
+// describe renders the rule for people, e.g. "feels_like_c < -10"
+func (r AlertRule) describe() string {
+	metric := r.Metric
+	if dayMetrics[r.Metric] {
+		metric = fmt.Sprintf("%s (day %d)", r.Metric, r.Day)
+	}
+	value := strconv.FormatFloat(r.Value, 'f', -1, 64)
+	switch r.Operator {
+	case "rise":
+		return fmt.Sprintf("%s rises by %s", metric, value)
+	case "drop":
+		return fmt.Sprintf("%s drops by %s", metric, value)
+	}
+	return fmt.Sprintf("%s %s %s", metric, r.Operator, value)
+}
+
+// Alert is the state of one rule at one location
+type Alert struct {
+	ID             string     `json:"id"`
+	Rule           string     `json:"rule"`
+	Condition      string     `json:"condition"`
+	Location       string     `json:"location"`
+	State          string     `json:"state"`
+	Value          float64    `json:"value"`
+	FiredAt        time.Time  `json:"fired_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	LastEvaluated  time.Time  `json:"last_evaluated"`
+}
+
+var (
+	errAlertNotFound  = errors.New("not found")
+	errAlertNotFiring = errors.New("only firing alerts can be acknowledged")
+)
+
+// alertID derives a stable, URL-safe id from the rule and location
+func alertID(rule, location string) string {
+	sum := sha1.Sum([]byte(rule + "\x00" + locationKey(location)))
+	return hex.EncodeToString(sum[:6])
+}
+
+// metricValue extracts a rule metric from fetched data
+func metricValue(data *WeatherData, metric string, day int) (float64, bool) {
+	if currentMetrics[metric] {
+		if len(data.CurrentCondition) == 0 {
+			return 0, false
+		}
+		c := data.CurrentCondition[0]
+		raw := map[string]string{
+			"temp_c":         c.TempC,
+			"feels_like_c":   c.FeelsLikeC,
+			"humidity":       c.Humidity,
+			"windspeed_kmph": c.WindspeedKmph,
+			"visibility_km":  c.Visibility,
+		}[metric]
+		v, err := strconv.ParseFloat(raw, 64)
+		return v, err == nil
+	}
+
+	if day >= len(data.Weather) {
+		return 0, false
+	}
+	w := data.Weather[day]
+	switch metric {
+	case "max_temp_c":
+		v, err := strconv.ParseFloat(w.MaxtempC, 64)
+		return v, err == nil
+	case "min_temp_c":
+		v, err := strconv.ParseFloat(w.MintempC, 64)
+		return v, err == nil
+	case "chance_of_rain":
+		return maxHourly(w.Hourly, func(h Hourly) string { return h.ChanceOfRain })
+	case "chance_of_snow":
+		return maxHourly(w.Hourly, func(h Hourly) string { return h.ChanceOfSnow })
+	case "max_wind_kmph":
+		return maxHourly(w.Hourly, func(h Hourly) string { return h.WindspeedKmph })
+	}
+	return 0, false
+}
+
+// maxHourly returns the largest parseable value of field across the hours
+func maxHourly(hours []Hourly, field func(Hourly) string) (float64, bool) {
+	best, found := math.Inf(-1), false
+	for _, h := range hours {
+		v, err := strconv.ParseFloat(field(h), 64)
+		if err != nil {
+			continue
+		}
+		best, found = math.Max(best, v), true
+	}
+	return best, found
+}
+
+// alertEngine evaluates rules on a schedule and tracks alert state.
+// Alerts are deduplicated by rule and location: a firing alert does not
+// fire again until it has resolved.
+type alertEngine struct {
+	app        *App
+	rules      []AlertRule
+	favourites []string
+	stateFile  string
+
+	mu        sync.Mutex
+	alerts    map[string]*Alert
+	previous  map[string]float64
+	listeners []func(AlertEvent)
+
+	// saveMu serialises writes of the state file
+	saveMu sync.Mutex
+}
+
+// AlertEvent is emitted when an alert fires or resolves
+type AlertEvent struct {
+	Type      string    `json:"event"`
+	Alert     Alert     `json:"alert"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Alert event types
+const (
+	AlertEventFired    = "alert.fired"
+	AlertEventResolved = "alert.resolved"
+)
+
+// subscribe registers fn to receive alert transitions. fn runs on the
+// evaluation goroutine and must not block.
+func (e *alertEngine) subscribe(fn func(AlertEvent)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.listeners = append(e.listeners, fn)
+}
+
+func newAlertEngine(app *App, cfg AlertsConfig, favourites []string) *alertEngine {
+	return &alertEngine{
+		app:        app,
+		rules:      cfg.Rules,
+		favourites: favourites,
+		stateFile:  cfg.StateFile,
+		alerts:     make(map[string]*Alert),
+		previous:   make(map[string]float64),
+	}
+}
+
+// locations expands a rule's location
+func (e *alertEngine) locations(rule AlertRule) []string {
+	if rule.Location == "*" {
+		return e.favourites
+	}
+	return []string{rule.Location}
+}
+
+// run evaluates all rules immediately and then once per interval
+func (e *alertEngine) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		e.evaluate(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// evaluate fetches each location once and applies every rule to it
+func (e *alertEngine) evaluate(ctx context.Context) {
+	fetched := make(map[string]*WeatherData)
+
+	for _, rule := range e.rules {
+		for _, location := range e.locations(rule) {
+			if ctx.Err() != nil {
+				return
+			}
+
+			key := locationKey(location)
+			data, ok := fetched[key]
+			if !ok {
+				var err error
+				data, err = e.app.fetchWeatherData(ctx, location)
+				if err != nil {
+					log.Printf("Alerts: error fetching %q: %v", location, err)
+					continue
+				}
+				fetched[key] = data
+			}
+
+			value, ok := metricValue(data, rule.Metric, rule.Day)
+			if !ok {
+				continue
+			}
+			e.apply(rule, location, value, time.Now())
+		}
+	}
+}
+
+// apply updates the alert for rule at location with a new reading, saves
+// the state and notifies listeners of any transition
+func (e *alertEngine) apply(rule AlertRule, location string, value float64, now time.Time) {
+	event, listeners := e.update(rule, location, value, now)
+	if event == nil {
+		return
+	}
+	e.persist()
+	for _, fn := range listeners {
+		fn(*event)
+	}
+}
+
+// update records the reading and returns the resulting transition, if any
+func (e *alertEngine) update(rule AlertRule, location string, value float64, now time.Time) (*AlertEvent, []func(AlertEvent)) {
+	id := alertID(rule.Name, location)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	prev, hasPrev := e.previous[id]
+	e.previous[id] = value
+
+	triggered := false
+	switch rule.Operator {
+	case ">":
+		triggered = value > rule.Value
+	case ">=":
+		triggered = value >= rule.Value
+	case "<":
+		triggered = value < rule.Value
+	case "<=":
+		triggered = value <= rule.Value
+	case "rise":
+		triggered = hasPrev && value-prev >= rule.Value
+	case "drop":
+		triggered = hasPrev && prev-value >= rule.Value
+	}
+
+	alert, exists := e.alerts[id]
+	active := exists && alert.State != AlertResolved
+
+	eventType := ""
+	switch {
+	case triggered && !active:
+		alert = &Alert{
+			ID:        id,
+			Rule:      rule.Name,
+			Condition: rule.describe(),
+			Location:  location,
+			State:     AlertFiring,
+			FiredAt:   now,
+		}
+		e.alerts[id] = alert
+		eventType = AlertEventFired
+		log.Printf("Alert fired: %s at %s (%g)", rule.Name, location, value)
+	case !triggered && active:
+		alert.State = AlertResolved
+		alert.ResolvedAt = &now
+		eventType = AlertEventResolved
+		log.Printf("Alert resolved: %s at %s (%g)", rule.Name, location, value)
+	}
+
+	if alert != nil {
+		alert.Value = value
+		alert.LastEvaluated = now
+	}
+	if eventType == "" {
+		return nil, nil
+	}
+	return &AlertEvent{Type: eventType, Alert: *alert, Timestamp: now}, e.listeners
+}
+
+// acknowledge marks a firing alert as seen; it still resolves normally
+func (e *alertEngine) acknowledge(id string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	alert, ok := e.alerts[id]
+	if !ok {
+		return fmt.Errorf("alert %s: %w", id, errAlertNotFound)
+	}
+	if alert.State != AlertFiring {
+		return fmt.Errorf("alert %s is %s: %w", id, alert.State, errAlertNotFiring)
+	}
+	now := time.Now()
+	alert.State = AlertAcknowledged
+	alert.AcknowledgedAt = &now
+	return nil
+}
+
+// persist saves the state after a transition so a crash does not lose it
+func (e *alertEngine) persist() {
+	if err := e.save(context.Background()); err != nil {
+		log.Printf("Error saving alert state: %v", err)
+	}
+}
+
+// list returns copies of all alerts, active ones first, newest first
+func (e *alertEngine) list() []Alert {
+	e.mu.Lock()
+	out := make([]Alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		out = append(out, *a)
+	}
+	e.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		ai, aj := out[i].State != AlertResolved, out[j].State != AlertResolved
+		if ai != aj {
+			return ai
+		}
+		return out[i].FiredAt.After(out[j].FiredAt)
+	})
+	return out
+}
+
+// countByState returns how many alerts are in each state
+func (e *alertEngine) countByState() map[string]int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	counts := map[string]int{AlertFiring: 0, AlertAcknowledged: 0, AlertResolved: 0}
+	for _, a := range e.alerts {
+		counts[a.State]++
+	}
+	return counts
+}
+
+// load restores alert state saved by a previous run
+func (e *alertEngine) load() error {
+	if e.stateFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(e.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read alert state: %w", err)
+	}
+
+	var alerts []Alert
+	if err := json.Unmarshal(b, &alerts); err != nil {
+		return fmt.Errorf("failed to parse alert state %s: %w", e.stateFile, err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range alerts {
+		e.alerts[alerts[i].ID] = &alerts[i]
+	}
+	return nil
+}
+
+// save writes alert state so it survives restarts
+func (e *alertEngine) save(ctx context.Context) error {
+	if e.stateFile == "" {
+		return nil
+	}
+	e.saveMu.Lock()
+	defer e.saveMu.Unlock()
+
+	b, err := json.MarshalIndent(e.list(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode alert state: %w", err)
+	}
+
+	tmp := e.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write alert state: %w", err)
+	}
+	return os.Rename(tmp, e.stateFile)
+}
+
+// AlertsPageData is the data for the alerts page
+type AlertsPageData struct {
+	Alerts    []Alert
+	Rules     []AlertRule
+	Nonce     string
+	CSRFToken string
+}
+
+// alertsHandler renders the alert list, or JSON for API clients
+func (app *App) alertsHandler(w http.ResponseWriter, r *http.Request) {
+	alerts := app.alerts.list()
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusOK, alerts)
+		return
+	}
+
+	data := AlertsPageData{
+		Alerts:    alerts,
+		Rules:     app.alerts.rules,
+		Nonce:     cspNonce(r),
+		CSRFToken: csrfToken(w, r),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := app.tmpl.ExecuteTemplate(w, "alerts", data); err != nil {
+		log.Printf("Error executing alerts template: %v", err)
+		http.Error(w, ErrTemplateExecution, http.StatusInternalServerError)
+	}
+}
+
+// acknowledgeAlertHandler acknowledges an alert from the alerts page
+func (app *App) acknowledgeAlertHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.alerts.acknowledge(mux.Vars(r)["id"])
+	switch {
+	case errors.Is(err, errAlertNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errAlertNotFiring):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	app.alerts.persist()
+	http.Redirect(w, r, "/alerts", http.StatusSeeOther)
+}
+
+// setupAlerts starts the rule scheduler when rules are configured
+func (app *App) setupAlerts() error {
+	cfg := app.config.Alerts
+	app.alerts = newAlertEngine(app, cfg, app.config.Favourites)
+	if err := app.alerts.load(); err != nil {
+		return err
+	}
+	if len(cfg.Rules) == 0 {
+		return nil
+	}
+
+	app.goBackground(func(ctx context.Context) {
+		app.alerts.run(ctx, cfg.Interval.Duration)
+	})
+	app.onShutdown(app.alerts.save)
+
+	app.metrics.register("wttr_alerts", "gauge",
+		"Alerts by state.", func() []metricSample {
+			counts := app.alerts.countByState()
+			samples := make([]metricSample, 0, len(counts))
+			for _, state := range []string{AlertFiring, AlertAcknowledged, AlertResolved} {
+				samples = append(samples, metricSample{
+					Labels: map[string]string{"state": state},
+					Value:  float64(counts[state]),
+				})
+			}
+			return samples
+		})
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlertStateSavedOnTransitions(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "alerts.json")
+	cfg := AlertsConfig{StateFile: stateFile}
+	rule := AlertRule{Name: "Cold", Location: "Oslo", Metric: "temp_c", Operator: "<", Value: 0}
+
+	// restored reads the state file as a new engine would after a crash
+	restored := func() map[string]*Alert {
+		t.Helper()
+		e := newAlertEngine(nil, cfg, nil)
+		if err := e.load(); err != nil {
+			t.Fatal(err)
+		}
+		return e.alerts
+	}
+
+	e := newAlertEngine(nil, cfg, nil)
+	now := time.Date(2026, 1, 10, 6, 0, 0, 0, time.UTC)
+	e.apply(rule, "Oslo", -4, now)
+
+	id := alertID(rule.Name, "Oslo")
+	if a := restored()[id]; a == nil || a.State != AlertFiring || a.Value != -4 {
+		t.Fatalf("after firing, saved alert = %+v", a)
+	}
+
+	if err := e.acknowledge(id); err != nil {
+		t.Fatal(err)
+	}
+	e.persist()
+	if a := restored()[id]; a == nil || a.State != AlertAcknowledged {
+		t.Fatalf("after acknowledging, saved alert = %+v", a)
+	}
+
+	e.apply(rule, "Oslo", 2, now.Add(time.Hour))
+	if a := restored()[id]; a == nil || a.State != AlertResolved || a.ResolvedAt == nil {
+		t.Fatalf("after resolving, saved alert = %+v", a)
+	}
+}
+
+func TestAlertIDsDifferByRuleAndLocation(t *testing.T) {
+	ids := map[string]bool{}
+	for _, rule := range []string{"Cold", "Wind"} {
+		for _, location := range []string{"Oslo", "Bergen"} {
+			ids[alertID(rule, location)] = true
+		}
+	}
+	if len(ids) != 4 {
+		t.Errorf("got %d distinct ids for 4 rule and location pairs", len(ids))
+	}
+	if alertID("Cold", "Oslo") != alertID("Cold", " oslo") {
+		t.Error("alert id depends on location spelling")
+	}
+}
+
+func TestAcknowledgeAlertHandler(t *testing.T) {
+	app := newTestApp(t, DefaultConfig())
+	handler := app.routes()
+	rule := AlertRule{Name: "Cold", Location: "Oslo", Metric: "temp_c", Operator: "<", Value: 0}
+	app.alerts.apply(rule, "Oslo", -4, time.Now())
+	id := alertID(rule.Name, "Oslo")
+
+	ack := func(id string) *httptest.ResponseRecorder {
+		form := url.Values{CSRFFieldName: {"token"}}
+		req := httptest.NewRequest(http.MethodPost, "/alerts/"+id+"/ack", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: "token"})
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := ack(id); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/alerts" {
+		t.Errorf("firing alert: status %d, location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if a := app.alerts.list()[0]; a.State != AlertAcknowledged || a.AcknowledgedAt == nil {
+		t.Errorf("alert after acknowledging = %+v", a)
+	}
+	if rec := ack(id); rec.Code != http.StatusConflict {
+		t.Errorf("acknowledged alert: status %d, want 409", rec.Code)
+	}
+
+	app.alerts.apply(rule, "Oslo", 2, time.Now())
+	if rec := ack(id); rec.Code != http.StatusConflict {
+		t.Errorf("resolved alert: status %d, want 409", rec.Code)
+	}
+	if rec := ack("0123456789ab"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown alert: status %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// APICurrent is the current conditions block of the JSON API
+type APICurrent struct {
+	TempC         int    `json:"temp_c"`
+	TempF         int    `json:"temp_f"`
+	FeelsLikeC    int    `json:"feels_like_c"`
+	FeelsLikeF    int    `json:"feels_like_f"`
+	Humidity      int    `json:"humidity"`
+	WindspeedKmph int    `json:"windspeed_kmph"`
+	WindDirection string `json:"wind_direction"`
+	VisibilityKm  int    `json:"visibility_km"`
+	Description   string `json:"description"`
+}
+
+// APIForecastDay is one day of the JSON API forecast
+type APIForecastDay struct {
+	Date        string `json:"date"`
+	MaxTempC    int    `json:"max_temp_c"`
+	MinTempC    int    `json:"min_temp_c"`
+	Description string `json:"description"`
+}
+
+// APIWeather is the response body of GET /api/v1/weather/{location}
+type APIWeather struct {
+	Location string           `json:"location"`
+	Current  APICurrent       `json:"current"`
+	Forecast []APIForecastDay `json:"forecast"`
+}
+
+// writeJSON encodes v with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding JSON response: %v", err)
+	}
+}
+
+// writeJSONError sends {"error": msg}
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// atoi parses a wttr.in numeric string, treating bad input as zero
+func atoi(s string) int {
+	n, _ := strconv.Atoi(strings.TrimSpace(s))
+	return n
+}
+
+// atof parses a wttr.in decimal string, treating bad input as zero
+func atof(s string) float64 {
+	f, _ := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return f
+}
+
+// buildAPIWeather converts validated wttr.in data to the API representation
+func (app *App) buildAPIWeather(data *WeatherData) APIWeather {
+	current := data.CurrentCondition[0]
+	area := data.NearestArea[0]
+
+	description := ""
+	if len(current.WeatherDesc) > 0 {
+		description = current.WeatherDesc[0].Value
+	}
+
+	forecast := make([]APIForecastDay, 0, MaxForecastDays)
+	for i, day := range data.Weather {
+		if i >= MaxForecastDays {
+			break
+		}
+		forecast = append(forecast, APIForecastDay{
+			Date:        day.Date,
+			MaxTempC:    atoi(day.MaxtempC),
+			MinTempC:    atoi(day.MintempC),
+			Description: middayDescription(day),
+		})
+	}
+
+	return APIWeather{
+		Location: area.AreaName[0].Value + ", " + area.Country[0].Value,
+		Current: APICurrent{
+			TempC:         atoi(current.TempC),
+			TempF:         atoi(current.TempF),
+			FeelsLikeC:    atoi(current.FeelsLikeC),
+			FeelsLikeF:    atoi(current.FeelsLikeF),
+			Humidity:      atoi(current.Humidity),
+			WindspeedKmph: atoi(current.WindspeedKmph),
+			WindDirection: current.Winddir16Point,
+			VisibilityKm:  atoi(current.Visibility),
+			Description:   description,
+		},
+		Forecast: forecast,
+	}
+}
+
+// apiWeatherHandler serves current conditions and forecast as JSON
+func (app *App) apiWeatherHandler(w http.ResponseWriter, r *http.Request) {
+	location := strings.TrimSpace(mux.Vars(r)["location"])
+	if location == "" {
+		writeJSONError(w, http.StatusBadRequest, ErrEmptyLocation)
+		return
+	}
+
+	weatherData, err := app.fetchWeatherData(r.Context(), location)
+	if errors.Is(err, errUpstreamRateLimited) {
+		app.writeRateLimited(w, r, upstreamRetryAfter(err))
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching weather data for %q: %v", location, err)
+		writeJSONError(w, http.StatusBadGateway, ErrFetchWeatherData)
+		return
+	}
+
+	if err := app.validateWeatherData(weatherData); err != nil {
+		log.Printf("Invalid weather data: %v", err)
+		writeJSONError(w, http.StatusBadGateway, ErrInvalidWeatherData)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, app.buildAPIWeather(weatherData))
+}
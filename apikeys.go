@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKey defines a client of the JSON API and its limits
+type APIKey struct {
+	Key        string `json:"key"`
+	Name       string `json:"name"`
+	PerMinute  int    `json:"per_minute"`
+	Burst      int    `json:"burst"`
+	DailyQuota int    `json:"daily_quota"`
+}
+
+// keyUsage tracks the requests made with one key
+type keyUsage struct {
+	Total    uint64 `json:"total"`
+	Today    int    `json:"today"`
+	Day      string `json:"day"`
+	Rejected uint64 `json:"rejected"`
+}
+
+// keyState is a configured key with its limiter
+type keyState struct {
+	APIKey
+	bucket *tokenBucket
+}
+
+// apiKeyStore holds the active keys from config and the keys file. Usage is
+// kept by key name so it survives reloads, and in the usage file, when set,
+// so it survives restarts.
+type apiKeyStore struct {
+	configKeys []APIKey
+	keysFile   string
+	usageFile  string
+
+	mu      sync.Mutex
+	keys    map[string]*keyState
+	usage   map[string]*keyUsage
+	modTime time.Time
+
+	// saveMu serialises writes of the usage file
+	saveMu sync.Mutex
+}
+
+// newAPIKeyStore builds the store, loads the keys file and restores the
+// usage counters if configured
+func newAPIKeyStore(cfg APIConfig) (*apiKeyStore, error) {
+	ks := &apiKeyStore{
+		configKeys: cfg.Keys,
+		keysFile:   cfg.KeysFile,
+		usageFile:  cfg.UsageFile,
+		keys:       make(map[string]*keyState),
+		usage:      make(map[string]*keyUsage),
+	}
+	if err := ks.reload(); err != nil {
+		return nil, err
+	}
+	if err := ks.loadUsage(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// loadUsage restores the usage counters, if a usage file is configured and
+// exists
+func (ks *apiKeyStore) loadUsage() error {
+	if ks.usageFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(ks.usageFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read API usage: %w", err)
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if err := json.Unmarshal(b, &ks.usage); err != nil {
+		return fmt.Errorf("failed to parse API usage: %w", err)
+	}
+	return nil
+}
+
+// saveUsage writes the usage counters so quotas survive restarts
+func (ks *apiKeyStore) saveUsage(ctx context.Context) error {
+	if ks.usageFile == "" {
+		return nil
+	}
+	ks.saveMu.Lock()
+	defer ks.saveMu.Unlock()
+
+	b, err := json.MarshalIndent(ks.snapshot(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode API usage: %w", err)
+	}
+	tmp := ks.usageFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write API usage: %w", err)
+	}
+	return os.Rename(tmp, ks.usageFile)
+}
+
+// persistUsage saves the usage counters every interval until ctx is done
+func (ks *apiKeyStore) persistUsage(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ks.saveUsage(ctx); err != nil {
+				log.Printf("Error saving API usage: %v", err)
+			}
+		}
+	}
+}
+
+// keyName is the name usage is reported and counted under
+func keyName(k APIKey) string {
+	if k.Name == "" {
+		return defaultKeyName(k.Key)
+	}
+	return k.Name
+}
+
+// checkKeyNames rejects key sets where two keys share a name, since they
+// would share one daily quota and one line of usage
+func checkKeyNames(keys []APIKey) error {
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if k.Key == "" {
+			continue
+		}
+		name := keyName(k)
+		if seen[name] {
+			return fmt.Errorf("duplicate API key name %q", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+// readKeysFile returns the keys listed in the keys file
+func (ks *apiKeyStore) readKeysFile() ([]APIKey, time.Time, error) {
+	if ks.keysFile == "" {
+		return nil, time.Time{}, nil
+	}
+
+	info, err := os.Stat(ks.keysFile)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to stat keys file: %w", err)
+	}
+
+	b, err := os.ReadFile(ks.keysFile)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to read keys file: %w", err)
+	}
+
+	var keys []APIKey
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to parse keys file %s: %w", ks.keysFile, err)
+	}
+	return keys, info.ModTime(), nil
+}
+
+// reload replaces the active key set. Keys missing from the new set are
+// revoked immediately; unchanged keys keep their rate-limit state.
+func (ks *apiKeyStore) reload() error {
+	fileKeys, modTime, err := ks.readKeysFile()
+	if err != nil {
+		return err
+	}
+
+	all := append(append([]APIKey{}, ks.configKeys...), fileKeys...)
+	if err := checkKeyNames(all); err != nil {
+		return err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	keys := make(map[string]*keyState, len(all))
+	for _, k := range all {
+		if k.Key == "" {
+			continue
+		}
+		k.Name = keyName(k)
+		if k.Burst < 1 {
+			k.Burst = max(1, k.PerMinute)
+		}
+
+		if old, ok := ks.keys[k.Key]; ok && old.APIKey == k {
+			keys[k.Key] = old
+			continue
+		}
+		state := &keyState{APIKey: k}
+		if k.PerMinute > 0 {
+			state.bucket = newTokenBucket(k.PerMinute, k.Burst)
+		}
+		keys[k.Key] = state
+	}
+	ks.keys = keys
+	ks.modTime = modTime
+	return nil
+}
+
+// defaultKeyName names an unnamed key by a truncated hash, which is stable
+// across reloads but reveals nothing of the key on /metrics or
+// /admin/usage
+func defaultKeyName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "key-" + hex.EncodeToString(sum[:4])
+}
+
+// watch reloads the keys file when it changes on disk
+func (ks *apiKeyStore) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(ks.keysFile)
+			if err != nil {
+				continue
+			}
+			ks.mu.Lock()
+			changed := info.ModTime().After(ks.modTime)
+			ks.mu.Unlock()
+			if !changed {
+				continue
+			}
+			if err := ks.reload(); err != nil {
+				log.Printf("Error reloading API keys, keeping previous set: %v", err)
+				continue
+			}
+			log.Printf("Reloaded API keys from %s", ks.keysFile)
+		}
+	}
+}
+
+// lookup finds the key without leaking timing on the comparison
+func (ks *apiKeyStore) lookup(key string) (*keyState, bool) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	for k, state := range ks.keys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return state, true
+		}
+	}
+	return nil, false
+}
+
+// usageFor returns the usage record for name, rolling over the daily count
+// at midnight UTC; caller holds mu
+func (ks *apiKeyStore) usageFor(name string, now time.Time) *keyUsage {
+	u, ok := ks.usage[name]
+	if !ok {
+		u = &keyUsage{}
+		ks.usage[name] = u
+	}
+	if day := now.UTC().Format("2006-01-02"); u.Day != day {
+		u.Day = day
+		u.Today = 0
+	}
+	return u
+}
+
+// consume records a request for state, returning false and the wait when the
+// rate limit or daily quota is exhausted. The quota check and the count
+// happen under one lock so concurrent requests cannot overshoot the quota.
+func (ks *apiKeyStore) consume(state *keyState) (bool, time.Duration) {
+	now := time.Now()
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	u := ks.usageFor(state.Name, now)
+	if state.DailyQuota > 0 && u.Today >= state.DailyQuota {
+		u.Rejected++
+		midnight := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		return false, midnight.Sub(now)
+	}
+	if state.bucket != nil {
+		if ok, wait := state.bucket.allow(); !ok {
+			u.Rejected++
+			return false, wait
+		}
+	}
+
+	u.Total++
+	u.Today++
+	return true, 0
+}
+
+// snapshot returns a copy of all usage counters keyed by key name
+func (ks *apiKeyStore) snapshot() map[string]keyUsage {
+	now := time.Now()
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	out := make(map[string]keyUsage, len(ks.usage))
+	for _, state := range ks.keys {
+		ks.usageFor(state.Name, now)
+	}
+	for name := range ks.usage {
+		out[name] = *ks.usageFor(name, now)
+	}
+	return out
+}
+
+// apiKeyFromRequest reads the key from the header or the query string
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	return r.URL.Query().Get(APIKeyQueryParam)
+}
+
+// apiAuth authenticates JSON API requests. Requests with a key are limited by
+// that key's settings; anonymous requests fall back to the per-client limit
+// unless keys are required.
+func (app *App) apiAuth(next http.Handler) http.Handler {
+	anonymous := app.rateLimit(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := apiKeyFromRequest(r)
+		if key == "" {
+			if app.config.API.RequireKey {
+				writeJSONError(w, http.StatusUnauthorized, ErrAPIKeyRequired)
+				return
+			}
+			anonymous.ServeHTTP(w, r)
+			return
+		}
+
+		state, ok := app.apiKeys.lookup(key)
+		if !ok {
+			writeJSONError(w, http.StatusUnauthorized, ErrAPIKeyInvalid)
+			return
+		}
+
+		if ok, wait := app.apiKeys.consume(state); !ok {
+			app.writeRateLimited(w, r, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminAuth requires the configured admin token as a bearer token
+func (app *App) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := app.config.API.AdminToken
+		got, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || !found || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, ErrAdminUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// keyReport is one entry of the admin usage endpoint
+type keyReport struct {
+	keyUsage
+	Active     bool   `json:"active"`
+	PerMinute  int    `json:"per_minute,omitempty"`
+	DailyQuota int    `json:"daily_quota,omitempty"`
+	Remaining  string `json:"remaining_today"`
+}
+
+// report combines usage counters with the limits of the active keys.
+// Revoked keys are listed as inactive so their history stays visible.
+func (ks *apiKeyStore) report() map[string]keyReport {
+	usage := ks.snapshot()
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	out := make(map[string]keyReport, len(usage))
+	for name, u := range usage {
+		out[name] = keyReport{keyUsage: u, Remaining: "0"}
+	}
+	for _, state := range ks.keys {
+		out[state.Name] = keyReport{
+			keyUsage:   usage[state.Name],
+			Active:     true,
+			PerMinute:  state.PerMinute,
+			DailyQuota: state.DailyQuota,
+			Remaining:  quotaRemaining(state.DailyQuota, usage[state.Name].Today),
+		}
+	}
+	return out
+}
+
+// quotaRemaining formats the requests left today for a key
+func quotaRemaining(quota, used int) string {
+	if quota <= 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(max(0, quota-used))
+}
+
+// apiUsageHandler reports per-key usage counters and limits
+func (app *App) apiUsageHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, app.apiKeys.report())
+}
+
+// setupAPIKeys loads the key store and starts the keys file watcher
+func (app *App) setupAPIKeys() error {
+	ks, err := newAPIKeyStore(app.config.API)
+	if err != nil {
+		return err
+	}
+	app.apiKeys = ks
+
+	if ks.keysFile != "" {
+		app.goBackground(func(ctx context.Context) {
+			ks.watch(ctx, app.config.API.ReloadInterval.Duration)
+		})
+	}
+	if ks.usageFile != "" {
+		app.goBackground(func(ctx context.Context) {
+			ks.persistUsage(ctx, APIUsageSaveInterval)
+		})
+		app.onShutdown(ks.saveUsage)
+	}
+
+	app.metrics.register("wttr_api_key_requests_total", "counter",
+		"JSON API requests per API key.", func() []metricSample {
+			usage := ks.snapshot()
+			names := make([]string, 0, len(usage))
+			for name := range usage {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			samples := make([]metricSample, 0, len(names))
+			for _, name := range names {
+				samples = append(samples, metricSample{
+					Labels: map[string]string{"key": name},
+					Value:  float64(usage[name].Total),
+				})
+			}
+			return samples
+		})
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDefaultKeyNameHidesKey(t *testing.T) {
+	ks, err := newAPIKeyStore(APIConfig{Keys: []APIKey{{Key: "s3cret-key-value"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, ok := ks.lookup("s3cret-key-value")
+	if !ok {
+		t.Fatal("key not found")
+	}
+	if strings.Contains(state.Name, "s3cr") || !strings.HasPrefix(state.Name, "key-") {
+		t.Errorf("default name %q reveals the key", state.Name)
+	}
+	if state.Name != defaultKeyName("s3cret-key-value") {
+		t.Errorf("default name is not stable")
+	}
+}
+
+func TestConsumeEnforcesQuotaUnderConcurrency(t *testing.T) {
+	const quota = 50
+	ks, err := newAPIKeyStore(APIConfig{Keys: []APIKey{{Key: "k", Name: "k", DailyQuota: quota}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, _ := ks.lookup("k")
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if ok, _ := ks.consume(state); ok {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := allowed.Load(); got != quota {
+		t.Errorf("allowed %d requests, want exactly %d", got, quota)
+	}
+	if u := ks.snapshot()["k"]; u.Today != quota || u.Rejected != 800-quota {
+		t.Errorf("usage = %+v, want %d today and %d rejected", u, quota, 800-quota)
+	}
+}
+
+func TestDuplicateKeyNamesRejected(t *testing.T) {
+	_, err := newAPIKeyStore(APIConfig{Keys: []APIKey{
+		{Key: "one", Name: "dashboard"},
+		{Key: "two", Name: "dashboard"},
+	}})
+	if err == nil || !strings.Contains(err.Error(), `duplicate API key name "dashboard"`) {
+		t.Errorf("duplicate names: %v", err)
+	}
+
+	// A keys file that reuses a name is rejected and the previous set kept
+	keysFile := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(keysFile, []byte(`[{"key": "file-key", "name": "reports"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := newAPIKeyStore(APIConfig{Keys: []APIKey{{Key: "config-key", Name: "dashboard"}}, KeysFile: keysFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keysFile, []byte(`[{"key": "file-key", "name": "dashboard"}]`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.reload(); err == nil {
+		t.Error("reload accepted a duplicate name")
+	}
+	if _, ok := ks.lookup("file-key"); !ok {
+		t.Error("failed reload dropped the previous keys")
+	}
+}
+
+func TestUsageSurvivesRestart(t *testing.T) {
+	cfg := APIConfig{
+		Keys:      []APIKey{{Key: "k", Name: "k", DailyQuota: 3}},
+		UsageFile: filepath.Join(t.TempDir(), "usage.json"),
+	}
+	ks, err := newAPIKeyStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, _ := ks.lookup("k")
+	for i := 0; i < 2; i++ {
+		if ok, _ := ks.consume(state); !ok {
+			t.Fatal("request under quota rejected")
+		}
+	}
+	if err := ks.saveUsage(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := newAPIKeyStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, _ = restarted.lookup("k")
+	if ok, _ := restarted.consume(state); !ok {
+		t.Fatal("third request rejected")
+	}
+	if ok, _ := restarted.consume(state); ok {
+		t.Error("quota reset by the restart")
+	}
+	if u := restarted.snapshot()["k"]; u.Total != 3 || u.Rejected != 1 {
+		t.Errorf("usage after restart = %+v", u)
+	}
+}
+
+func TestMetricsRequireAdminToken(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.API.AdminToken = "admin-secret"
+	cfg.API.Keys = []APIKey{{Key: "k", Name: "customer-a"}}
+	handler := newTestApp(t, cfg).routes()
+
+	for _, auth := range []string{"", "Bearer wrong", "admin-secret"} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized || strings.Contains(rec.Body.String(), "customer-a") {
+			t.Errorf("Authorization %q: status %d", auth, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `wttr_api_key_requests_total{key="customer-a"}`) {
+		t.Errorf("with the admin token: status %d\n%s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chatMessenger sends text to a chat on one bot platform. The Telegram and
+// Discord clients implement it; tests substitute a local fake.
+type chatMessenger interface {
+	SendMessage(ctx context.Context, chatID, text string) error
+}
+
+// chatSettings is what a chat has saved with the bot
+type chatSettings struct {
+	Platform        string `json:"platform"`
+	ChatID          string `json:"chat_id"`
+	DefaultLocation string `json:"default_location,omitempty"`
+	DailyAt         string `json:"daily_at,omitempty"`
+}
+
+// chatStore keeps per-chat settings, persisted to a JSON file on change
+type chatStore struct {
+	path string
+
+	mu    sync.Mutex
+	chats map[string]*chatSettings
+}
+
+func chatKey(platform, chatID string) string {
+	return platform + ":" + chatID
+}
+
+// newChatStore loads saved settings from path, if it exists
+func newChatStore(path string) (*chatStore, error) {
+	cs := &chatStore{path: path, chats: make(map[string]*chatSettings)}
+	if path == "" {
+		return cs, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bot state: %w", err)
+	}
+
+	var chats []*chatSettings
+	if err := json.Unmarshal(b, &chats); err != nil {
+		return nil, fmt.Errorf("failed to parse bot state %s: %w", path, err)
+	}
+	for _, c := range chats {
+		cs.chats[chatKey(c.Platform, c.ChatID)] = c
+	}
+	return cs, nil
+}
+
+// get returns a copy of the chat's settings
+func (cs *chatStore) get(platform, chatID string) chatSettings {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if c, ok := cs.chats[chatKey(platform, chatID)]; ok {
+		return *c
+	}
+	return chatSettings{Platform: platform, ChatID: chatID}
+}
+
+// update changes a chat's settings and saves the store
+func (cs *chatStore) update(platform, chatID string, fn func(*chatSettings)) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	key := chatKey(platform, chatID)
+	c, ok := cs.chats[key]
+	if !ok {
+		c = &chatSettings{Platform: platform, ChatID: chatID}
+		cs.chats[key] = c
+	}
+	fn(c)
+	if c.DefaultLocation == "" && c.DailyAt == "" {
+		delete(cs.chats, key)
+	}
+	return cs.saveLocked()
+}
+
+// due returns the chats subscribed to a daily forecast at clock (HH:MM)
+func (cs *chatStore) due(clock string) []chatSettings {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	var out []chatSettings
+	for _, c := range cs.chats {
+		if c.DailyAt == clock {
+			out = append(out, *c)
+		}
+	}
+	return out
+}
+
+// saveLocked writes the store atomically; caller holds mu
+func (cs *chatStore) saveLocked() error {
+	if cs.path == "" {
+		return nil
+	}
+	chats := make([]*chatSettings, 0, len(cs.chats))
+	for _, c := range cs.chats {
+		chats = append(chats, c)
+	}
+	b, err := json.MarshalIndent(chats, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bot state: %w", err)
+	}
+	tmp := cs.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write bot state: %w", err)
+	}
+	return os.Rename(tmp, cs.path)
+}
+
+// chatBots holds the bot platforms and their shared state
+type chatBots struct {
+	app        *App
+	store      *chatStore
+	loc        *time.Location
+	messengers map[string]chatMessenger
+
+	discord          discordAPI
+	discordPublicKey ed25519.PublicKey
+}
+
+// weatherText renders a page as a short plain-text message
+func weatherText(page PageData) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%s, %s\n", page.Location, page.Temperature, page.Description)
+	fmt.Fprintf(&b, "Feels like %s · Humidity %s · Wind %s\n", page.FeelsLike, page.Humidity, page.Wind)
+	for _, d := range page.Forecast {
+		fmt.Fprintf(&b, "\n%s: %s, %s", d.Day, d.Temperature, d.Description)
+	}
+	return b.String()
+}
+
+// forecastFor fetches and renders the weather for location
+func (bots *chatBots) forecastFor(ctx context.Context, location string) string {
+	data, err := bots.app.fetchWeatherData(ctx, location)
+	if err != nil {
+		log.Printf("Bots: error fetching %q: %v", location, err)
+		return ErrFetchWeatherData
+	}
+	page := bots.app.processWeatherData(data)
+	if !page.HasData {
+		return page.Error
+	}
+	return weatherText(page)
+}
+
+// handleCommand runs a bot command for a chat and returns the reply.
+//
+//	weather [location]     forecast for location or the chat's default
+//	setdefault <location>  save the chat's default location
+//	subscribe [HH:MM]      daily forecast for the default location
+//	unsubscribe            stop the daily forecast
+func (bots *chatBots) handleCommand(ctx context.Context, platform, chatID, command, args string) string {
+	args = strings.TrimSpace(args)
+	settings := bots.store.get(platform, chatID)
+
+	switch command {
+	case "weather":
+		location := args
+		if location == "" {
+			location = settings.DefaultLocation
+		}
+		if location == "" {
+			return "Which location? Try: weather Berlin, or save one with setdefault Berlin"
+		}
+		return bots.forecastFor(ctx, location)
+
+	case "setdefault":
+		if args == "" {
+			return "Usage: setdefault <location>"
+		}
+		if err := bots.store.update(platform, chatID, func(c *chatSettings) { c.DefaultLocation = args }); err != nil {
+			log.Printf("Bots: %v", err)
+		}
+		return fmt.Sprintf("Default location set to %s.", args)
+
+	case "subscribe":
+		if settings.DefaultLocation == "" {
+			return "Set a default location first with setdefault <location>."
+		}
+		clock := args
+		if clock == "" {
+			clock = bots.app.config.Bots.DailyAt
+		}
+		t, err := time.Parse("15:04", clock)
+		if err != nil {
+			return "Usage: subscribe [HH:MM]"
+		}
+		clock = t.Format("15:04")
+		if err := bots.store.update(platform, chatID, func(c *chatSettings) { c.DailyAt = clock }); err != nil {
+			log.Printf("Bots: %v", err)
+		}
+		return fmt.Sprintf("Daily forecast for %s at %s (%s).", settings.DefaultLocation, clock, bots.loc)
+
+	case "unsubscribe":
+		if err := bots.store.update(platform, chatID, func(c *chatSettings) { c.DailyAt = "" }); err != nil {
+			log.Printf("Bots: %v", err)
+		}
+		return "Daily forecast stopped."
+	}
+
+	return "Commands: weather [location], setdefault <location>, subscribe [HH:MM], unsubscribe"
+}
+
+// runDaily sends subscribed chats their forecast once their chosen time
+// has passed. Each tick covers every minute since the last one, so slow
+// sends or a stalled process do not skip anyone.
+func (bots *chatBots) runDaily(ctx context.Context) {
+	last := wallClock(time.Now(), bots.loc)
+	for {
+		now := time.Now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		last = bots.sendDaily(ctx, last, time.Now())
+	}
+}
+
+// wallClock is t's date and time of day in loc, to the minute, written as
+// a UTC time so minutes can be stepped through without DST changes
+func wallClock(t time.Time, loc *time.Location) time.Time {
+	local := t.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), 0, 0, time.UTC)
+}
+
+// sendDaily sends the forecast to chats subscribed at each wall-clock minute
+// after last up to now, and returns now's wall-clock minute as the next
+// last. Minutes skipped when clocks go forward are still covered, and the
+// hour repeated when they go back is not sent twice. At most a day is
+// caught up, so no chat is sent the same forecast twice.
+func (bots *chatBots) sendDaily(ctx context.Context, last, now time.Time) time.Time {
+	wall := wallClock(now, bots.loc)
+	if !wall.After(last) {
+		return last
+	}
+	from := last
+	if wall.Sub(from) > 24*time.Hour {
+		from = wall.Add(-24 * time.Hour)
+	}
+
+	forecasts := make(map[string]string)
+	for minute := from.Add(time.Minute); !minute.After(wall); minute = minute.Add(time.Minute) {
+		for _, c := range bots.store.due(minute.Format("15:04")) {
+			messenger, ok := bots.messengers[c.Platform]
+			if !ok {
+				continue
+			}
+			key := locationKey(c.DefaultLocation)
+			text, ok := forecasts[key]
+			if !ok {
+				text = bots.forecastFor(ctx, c.DefaultLocation)
+				forecasts[key] = text
+			}
+			if err := messenger.SendMessage(ctx, c.ChatID, text); err != nil {
+				log.Printf("Bots: error sending daily forecast to %s: %v", chatKey(c.Platform, c.ChatID), err)
+			}
+		}
+	}
+	return wall
+}
+
+// setupBots configures the enabled bot platforms
+func (app *App) setupBots() error {
+	cfg := app.config.Bots
+	if cfg.Telegram.Token == "" && cfg.Discord.PublicKey == "" {
+		return nil
+	}
+
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return fmt.Errorf("bots timezone: %w", err)
+	}
+	store, err := newChatStore(cfg.StateFile)
+	if err != nil {
+		return err
+	}
+
+	app.bots = &chatBots{
+		app:        app,
+		store:      store,
+		loc:        loc,
+		messengers: make(map[string]chatMessenger),
+	}
+	if cfg.Telegram.Token != "" {
+		app.bots.messengers[PlatformTelegram] = newTelegramClient(cfg.Telegram, app.client)
+	}
+	if cfg.Discord.PublicKey != "" {
+		key, err := hex.DecodeString(cfg.Discord.PublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("discord public_key must be %d hex-encoded bytes", ed25519.PublicKeySize)
+		}
+		discord := newDiscordClient(cfg.Discord, app.client)
+		app.bots.discord = discord
+		app.bots.discordPublicKey = key
+		app.bots.messengers[PlatformDiscord] = discord
+	}
+
+	app.goBackground(app.bots.runDaily)
+	return nil
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sentMessage is a message recorded by fakeMessenger
+type sentMessage struct {
+	to, text string
+}
+
+// fakeMessenger records messages instead of calling a chat platform. It
+// implements discordAPI, so it also stands in for the Discord client.
+type fakeMessenger struct {
+	sent chan sentMessage
+}
+
+func newFakeMessenger() *fakeMessenger {
+	return &fakeMessenger{sent: make(chan sentMessage, 16)}
+}
+
+func (f *fakeMessenger) SendMessage(ctx context.Context, chatID, text string) error {
+	f.sent <- sentMessage{to: chatID, text: text}
+	return nil
+}
+
+func (f *fakeMessenger) EditOriginalResponse(ctx context.Context, interactionToken, text string) error {
+	f.sent <- sentMessage{to: "interaction:" + interactionToken, text: text}
+	return nil
+}
+
+// next waits for the next recorded message
+func (f *fakeMessenger) next(t *testing.T) sentMessage {
+	t.Helper()
+	select {
+	case m := <-f.sent:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message sent")
+		return sentMessage{}
+	}
+}
+
+// newBotTestApp returns an app with both bot platforms enabled, weather
+// served locally and replies captured by a fake messenger
+func newBotTestApp(t *testing.T, publicKey ed25519.PublicKey) (*App, *fakeMessenger) {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.Bots.StateFile = filepath.Join(t.TempDir(), "bots.json")
+	cfg.Bots.Timezone = "UTC"
+	cfg.Bots.Telegram = TelegramConfig{Token: "123:abc", SecretToken: "hush", APIURL: "http://telegram.invalid"}
+	cfg.Bots.Discord = DiscordConfig{PublicKey: hex.EncodeToString(publicKey), BotToken: "bot-token", ApplicationID: "42", APIURL: "http://discord.invalid"}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newTestApp(t, cfg)
+	fakeUpstream(t, app, serveWeather(testWeatherData("Oslo", 12)))
+
+	fake := newFakeMessenger()
+	app.bots.messengers[PlatformTelegram] = fake
+	app.bots.messengers[PlatformDiscord] = fake
+	app.bots.discord = fake
+	return app, fake
+}
+
+func TestParseTelegramCommand(t *testing.T) {
+	tests := []struct {
+		text, command, args string
+	}{
+		{"/weather Oslo", "weather", "Oslo"},
+		{"/weather@WttrBot New York", "weather", "New York"},
+		{"/SetDefault Bergen", "setdefault", "Bergen"},
+		{"/subscribe", "subscribe", ""},
+		{"/start", "help", ""},
+		{"/help@WttrBot", "help", ""},
+		{"  Tromsø ", "weather", "Tromsø"},
+	}
+	for _, tt := range tests {
+		command, args := parseTelegramCommand(tt.text)
+		if command != tt.command || args != tt.args {
+			t.Errorf("parseTelegramCommand(%q) = %q, %q, want %q, %q", tt.text, command, args, tt.command, tt.args)
+		}
+	}
+}
+
+func TestHandleCommand(t *testing.T) {
+	app, _ := newBotTestApp(t, make(ed25519.PublicKey, ed25519.PublicKeySize))
+	bots := app.bots
+	ctx := context.Background()
+
+	steps := []struct {
+		command, args string
+		want          string
+	}{
+		{"weather", "", "Which location?"},
+		{"subscribe", "", "Set a default location first"},
+		{"setdefault", "", "Usage: setdefault"},
+		{"setdefault", " Oslo ", "Default location set to Oslo."},
+		{"weather", "", "Oslo, Norway\n12°C"},
+		{"subscribe", "7:05", "Daily forecast for Oslo at 07:05 (UTC)."},
+		{"subscribe", "noon", "Usage: subscribe [HH:MM]"},
+		{"unsubscribe", "", "Daily forecast stopped."},
+		{"help", "", "Commands:"},
+	}
+	for _, step := range steps {
+		reply := bots.handleCommand(ctx, PlatformTelegram, "1001", step.command, step.args)
+		if !strings.Contains(reply, step.want) {
+			t.Errorf("%s %q: reply %q, want it to contain %q", step.command, step.args, reply, step.want)
+		}
+	}
+
+	// Settings are per chat and survive a restart
+	bots.handleCommand(ctx, PlatformTelegram, "1001", "subscribe", "")
+	store, err := newChatStore(app.config.Bots.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := store.get(PlatformTelegram, "1001"); got.DefaultLocation != "Oslo" || got.DailyAt != DefaultBotDailyAt {
+		t.Errorf("saved settings = %+v", got)
+	}
+	if got := store.get(PlatformDiscord, "1001"); got.DefaultLocation != "" {
+		t.Errorf("settings leaked across platforms: %+v", got)
+	}
+	if due := store.due(DefaultBotDailyAt); len(due) != 1 || due[0].ChatID != "1001" {
+		t.Errorf("due = %+v", due)
+	}
+}
+
+func TestTelegramWebhook(t *testing.T) {
+	app, fake := newBotTestApp(t, make(ed25519.PublicKey, ed25519.PublicKeySize))
+	handler := app.routes()
+
+	post := func(secret, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/integrations/telegram/webhook", strings.NewReader(body))
+		req.Header.Set("X-Telegram-Bot-Api-Secret-Token", secret)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	update := `{"update_id":1,"message":{"text":"/weather@WttrBot Oslo","chat":{"id":-100}}}`
+	if code := post("wrong", update); code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: status %d, want 401", code)
+	}
+	if code := post("hush", `{"update_id":`); code != http.StatusBadRequest {
+		t.Errorf("bad update: status %d, want 400", code)
+	}
+	if code := post("hush", update); code != http.StatusOK {
+		t.Fatalf("status %d, want 200", code)
+	}
+
+	reply := fake.next(t)
+	if reply.to != "-100" || !strings.HasPrefix(reply.text, "Oslo, Norway\n12°C (53°F), Partly cloudy") {
+		t.Errorf("reply = %+v", reply)
+	}
+}
+
+func TestDiscordInteraction(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, fake := newBotTestApp(t, public)
+	handler := app.routes()
+
+	post := func(body string, key ed25519.PrivateKey) *httptest.ResponseRecorder {
+		ts := "1700000000"
+		req := httptest.NewRequest(http.MethodPost, "/integrations/discord/interactions", strings.NewReader(body))
+		req.Header.Set("X-Signature-Timestamp", ts)
+		req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(key, []byte(ts+body))))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	responseType := func(rec *httptest.ResponseRecorder) int {
+		var resp struct {
+			Type int `json:"type"`
+		}
+		json.NewDecoder(bytes.NewReader(rec.Body.Bytes())).Decode(&resp)
+		return resp.Type
+	}
+
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	if rec := post(`{"type":1}`, otherKey); rec.Code != http.StatusUnauthorized {
+		t.Errorf("forged signature: status %d, want 401", rec.Code)
+	}
+	if rec := post(`{"type":1}`, private); responseType(rec) != discordResponsePong {
+		t.Errorf("ping: %d %s", rec.Code, rec.Body)
+	}
+
+	rec := post(`{"type":2,"token":"tok","channel_id":"77","data":{"name":"weather","options":[{"name":"location","value":"Oslo"}]}}`, private)
+	if responseType(rec) != discordResponseDeferred {
+		t.Fatalf("command: %d %s", rec.Code, rec.Body)
+	}
+	reply := fake.next(t)
+	if reply.to != "interaction:tok" || !strings.Contains(reply.text, "Oslo, Norway") {
+		t.Errorf("reply = %+v", reply)
+	}
+}
+
+func TestSendDailyCatchesUpMissedMinutes(t *testing.T) {
+	oslo, err := time.LoadLocation("Europe/Oslo")
+	if err != nil {
+		t.Skipf("no tzdata: %v", err)
+	}
+	// Bots of their own, so the app's daily loop does not send on the real
+	// clock meanwhile
+	app := newTestApp(t, DefaultConfig())
+	fakeUpstream(t, app, serveWeather(testWeatherData("Oslo", 12)))
+	store, err := newChatStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeMessenger()
+	bots := &chatBots{app: app, store: store, loc: oslo, messengers: map[string]chatMessenger{PlatformTelegram: fake}}
+	ctx := context.Background()
+
+	for chat, at := range map[string]string{
+		"early": "07:00", "first": "07:01", "third": "07:03", "late": "07:04",
+		"spring": "02:30", "autumn": "02:15",
+	} {
+		if err := bots.store.update(PlatformTelegram, chat, func(c *chatSettings) {
+			c.DefaultLocation = "Oslo"
+			c.DailyAt = at
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// sent drains the messages sent so far, by chat
+	sent := func() map[string]int {
+		counts := make(map[string]int)
+		for {
+			select {
+			case m := <-fake.sent:
+				counts[m.to]++
+			default:
+				return counts
+			}
+		}
+	}
+	at := func(s string) time.Time {
+		t.Helper()
+		ts, err := time.ParseInLocation("2006-01-02 15:04:05", s, oslo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	// The sends for 07:00 ran past 07:03, so the next tick covers three
+	// minutes at once
+	last := bots.sendDaily(ctx, wallClock(at("2024-05-02 06:59:00"), oslo), at("2024-05-02 07:00:00"))
+	last = bots.sendDaily(ctx, last, at("2024-05-02 07:03:40"))
+	if got := sent(); len(got) != 3 || got["early"] != 1 || got["first"] != 1 || got["third"] != 1 {
+		t.Errorf("after a slow tick, sent %v", got)
+	}
+	if got := sent(); len(got) != 0 {
+		t.Errorf("sent %v twice", got)
+	}
+	// A tick that lands in the same minute again sends nothing
+	if again := bots.sendDaily(ctx, last, at("2024-05-02 07:03:59")); !again.Equal(last) || len(sent()) != 0 {
+		t.Error("the same minute was sent twice")
+	}
+
+	// Clocks went from 02:00 to 03:00 on 31 March 2024, so neither 02:15 nor
+	// 02:30 occurred
+	spring := at("2024-03-31 01:58:00")
+	last = wallClock(spring, oslo)
+	for now := spring; now.Before(spring.Add(5 * time.Minute)); now = now.Add(time.Minute) {
+		last = bots.sendDaily(ctx, last, now)
+	}
+	if got := sent(); got["spring"] != 1 || got["autumn"] != 1 || len(got) != 2 {
+		t.Errorf("across the spring DST change, sent %v", got)
+	}
+
+	// Clocks went from 03:00 back to 02:00 on 27 October 2024, so 02:15 and
+	// 02:30 happened twice
+	autumn := time.Date(2024, 10, 27, 0, 0, 0, 0, time.UTC)
+	last = wallClock(autumn, oslo)
+	for now := autumn; now.Before(autumn.Add(2 * time.Hour)); now = now.Add(time.Minute) {
+		last = bots.sendDaily(ctx, last, now)
+	}
+	if got := sent(); got["spring"] != 1 || got["autumn"] != 1 || len(got) != 2 {
+		t.Errorf("across the autumn DST change, sent %v", got)
+	}
+
+	// After a stall of more than a day everyone gets one forecast
+	last = bots.sendDaily(ctx, wallClock(at("2024-06-01 12:00:00"), oslo), at("2024-06-03 12:00:00"))
+	if got := sent(); len(got) != 6 {
+		t.Errorf("after a long stall, sent %v", got)
+	}
+	for chat, n := range sent() {
+		t.Errorf("%s sent %d more times", chat, n)
+	}
+	if want := wallClock(at("2024-06-03 12:00:00"), oslo); !last.Equal(want) {
+		t.Errorf("last = %s, want %s", last, want)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// iCalendar (RFC 5545) feed of the daily forecast for calendar
+// subscriptions. Each forecast day is an all-day event whose UID depends
+// only on the location and date, so clients update the event on refresh
+// instead of adding a duplicate.
+
+// calendarOptions are the query parameters of a calendar feed
+type calendarOptions struct {
+	Imperial bool
+	Alarms   bool
+}
+
+// parseCalendarOptions reads ?units= and ?alarms=
+func parseCalendarOptions(r *http.Request) (calendarOptions, error) {
+	q := r.URL.Query()
+	var opts calendarOptions
+	switch q.Get("units") {
+	case "", "metric":
+	case "imperial":
+		opts.Imperial = true
+	default:
+		return opts, fmt.Errorf("units must be metric or imperial")
+	}
+	if v := q.Get("alarms"); v != "" {
+		alarms, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("alarms must be true or false")
+		}
+		opts.Alarms = alarms
+	}
+	return opts, nil
+}
+
+// calendarHandler serves GET /calendar/{location}.ics
+func (app *App) calendarHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseCalendarOptions(r)
+	if err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	location := strings.TrimSpace(mux.Vars(r)["location"])
+	weatherData, ok := app.fetchForText(w, r, location)
+	if !ok {
+		return
+	}
+
+	body := renderCalendar(location, app.processWeatherData(weatherData).Location,
+		forecastDays(weatherData.Weather), opts, time.Now())
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", calendarUIDPart(location)+".ics"))
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write([]byte(body))
+}
+
+// calendarWriter builds iCalendar content lines
+type calendarWriter struct {
+	strings.Builder
+}
+
+// line writes a content line, folding it at 75 octets without splitting a
+// UTF-8 sequence. Continuation lines start with a space, which counts
+// towards their length.
+func (c *calendarWriter) line(s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		c.WriteString(s[:cut] + "\r\n ")
+		s = s[cut:]
+		limit = 74
+	}
+	c.WriteString(s + "\r\n")
+}
+
+// text writes a property with a TEXT value
+func (c *calendarWriter) text(name, value string) {
+	c.line(name + ":" + calendarEscape(value))
+}
+
+// calendarEscape escapes a TEXT value
+func calendarEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+var calendarUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// calendarUIDPart reduces a location to characters safe in a UID and file
+// name. A short hash of the location key is appended, so names that reduce
+// to the same characters, such as "東京" and "大阪" or "New York" and
+// "new-york", keep distinct UIDs.
+func calendarUIDPart(location string) string {
+	key := locationKey(location)
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:4])
+	part := strings.Trim(calendarUnsafe.ReplaceAllString(key, "-"), "-")
+	if part == "" {
+		return hash
+	}
+	return part + "-" + hash
+}
+
+// renderCalendar writes the VCALENDAR for the forecast days of location
+func renderCalendar(location, name string, days []Weather, opts calendarOptions, now time.Time) string {
+	var c calendarWriter
+	stamp := now.UTC().Format("20060102T150405Z")
+	uidPart := calendarUIDPart(location)
+
+	c.line("BEGIN:VCALENDAR")
+	c.line("VERSION:2.0")
+	c.line("PRODID:-//wttr-app//Weather forecast//EN")
+	c.line("CALSCALE:GREGORIAN")
+	c.line("METHOD:PUBLISH")
+	c.text("X-WR-CALNAME", "Weather: "+name)
+	c.line("REFRESH-INTERVAL;VALUE=DURATION:" + calendarDuration(CalendarRefreshInterval))
+	c.line("X-PUBLISHED-TTL:" + calendarDuration(CalendarRefreshInterval))
+
+	for _, day := range days {
+		date, err := time.Parse("2006-01-02", day.Date)
+		if err != nil {
+			continue
+		}
+		condition := middayDescription(day)
+		maxTemp, minTemp, unit := day.MaxtempC, day.MintempC, "°C"
+		if opts.Imperial {
+			maxTemp, minTemp, unit = day.MaxtempF, day.MintempF, "°F"
+		}
+
+		c.line("BEGIN:VEVENT")
+		c.line("UID:" + day.Date + "-" + uidPart + "@wttr-app")
+		c.line("DTSTAMP:" + stamp)
+		c.line("LAST-MODIFIED:" + stamp)
+		c.line("DTSTART;VALUE=DATE:" + date.Format("20060102"))
+		c.line("DTEND;VALUE=DATE:" + date.AddDate(0, 0, 1).Format("20060102"))
+		c.text("SUMMARY", fmt.Sprintf("%s %s %d%s / %d%s", getConditionEmoji(condition), condition,
+			atoi(maxTemp), unit, atoi(minTemp), unit))
+		c.text("DESCRIPTION", calendarDescription(name, day, opts.Imperial))
+		c.text("LOCATION", name)
+		c.line("TRANSP:TRANSPARENT")
+		c.line("CATEGORIES:Weather")
+		if opts.Alarms {
+			if reason := calendarAlarmReason(day, opts.Imperial); reason != "" {
+				c.line("BEGIN:VALARM")
+				c.line("ACTION:DISPLAY")
+				c.line("TRIGGER:-" + calendarDuration(CalendarAlarmLead))
+				c.text("DESCRIPTION", reason+" forecast in "+name)
+				c.line("END:VALARM")
+			}
+		}
+		c.line("END:VEVENT")
+	}
+
+	c.line("END:VCALENDAR")
+	return c.String()
+}
+
+// calendarDescription lists the hourly forecast, one step per line
+func calendarDescription(name string, day Weather, imperial bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Forecast for %s\n", name)
+	for _, h := range day.Hourly {
+		hhmm := atoi(h.Time)
+		temp, unit := h.TempC, "°C"
+		if imperial {
+			temp, unit = h.TempF, "°F"
+		}
+		description := ""
+		if len(h.WeatherDesc) > 0 {
+			description = h.WeatherDesc[0].Value
+		}
+		fmt.Fprintf(&b, "\n%02d:%02d  %d%s  %s, %d%% rain", hhmm/100, hhmm%100, atoi(temp), unit,
+			description, atoi(h.ChanceOfRain))
+	}
+	return b.String()
+}
+
+// calendarAlarmReason explains why day deserves an alarm, or returns "".
+// Thresholds are in Celsius whatever the display units.
+func calendarAlarmReason(day Weather, imperial bool) string {
+	maxTemp, minTemp, unit := day.MaxtempC, day.MintempC, "°C"
+	if imperial {
+		maxTemp, minTemp, unit = day.MaxtempF, day.MintempF, "°F"
+	}
+
+	var reasons []string
+	if rain, ok := maxHourly(day.Hourly, func(h Hourly) string { return h.ChanceOfRain }); ok && rain >= CalendarRainAlarm {
+		reasons = append(reasons, fmt.Sprintf("Rain (%.0f%% chance)", rain))
+	}
+	if atoi(day.MaxtempC) >= CalendarHotAlarmC {
+		reasons = append(reasons, fmt.Sprintf("Heat (%d%s)", atoi(maxTemp), unit))
+	}
+	if atoi(day.MintempC) <= CalendarColdAlarmC {
+		reasons = append(reasons, fmt.Sprintf("Cold (%d%s)", atoi(minTemp), unit))
+	}
+	return strings.Join(reasons, " and ")
+}
+
+// calendarDuration formats a whole number of minutes as an RFC 5545
+// duration
+func calendarDuration(d time.Duration) string {
+	minutes := int(d.Minutes())
+	if minutes%60 == 0 {
+		return fmt.Sprintf("PT%dH", minutes/60)
+	}
+	return fmt.Sprintf("PT%dM", minutes)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+var calendarUIDLine = regexp.MustCompile(`(?m)^UID:(.*)\r$`)
+
+// calendarUIDs lists the event UIDs of a rendered calendar
+func calendarUIDs(body string) []string {
+	var uids []string
+	for _, m := range calendarUIDLine.FindAllStringSubmatch(body, -1) {
+		uids = append(uids, m[1])
+	}
+	return uids
+}
+
+// unfoldCalendar joins folded content lines, checking each physical line
+// stays within 75 octets and is valid UTF-8 on its own
+func unfoldCalendar(t *testing.T, body string) []string {
+	t.Helper()
+	if !strings.HasSuffix(body, "\r\n") {
+		t.Fatal("calendar does not end with CRLF")
+	}
+	var lines []string
+	for _, physical := range strings.Split(strings.TrimSuffix(body, "\r\n"), "\r\n") {
+		if len(physical) > 75 {
+			t.Errorf("line of %d octets: %q", len(physical), physical)
+		}
+		if !utf8.ValidString(physical) {
+			t.Errorf("line splits a UTF-8 sequence: %q", physical)
+		}
+		if strings.HasPrefix(physical, " ") && len(lines) > 0 {
+			lines[len(lines)-1] += physical[1:]
+			continue
+		}
+		lines = append(lines, physical)
+	}
+	return lines
+}
+
+func TestCalendarUIDs(t *testing.T) {
+	days := testWeatherData("Oslo", 12).Weather
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Stable across refreshes and spellings of the same location
+	first := calendarUIDs(renderCalendar("Oslo", "Oslo, Norway", days, calendarOptions{}, now))
+	if len(first) != len(days) {
+		t.Fatalf("got %d UIDs for %d days", len(first), len(days))
+	}
+	for _, location := range []string{"Oslo", " oslo ", "OSLO"} {
+		later := calendarUIDs(renderCalendar(location, "Oslo, Norway", days, calendarOptions{Imperial: true}, now.Add(time.Hour)))
+		if strings.Join(later, ",") != strings.Join(first, ",") {
+			t.Errorf("%q: UIDs %v, want %v", location, later, first)
+		}
+	}
+	for i, uid := range first {
+		if !strings.HasPrefix(uid, days[i].Date+"-oslo-") || !strings.HasSuffix(uid, "@wttr-app") {
+			t.Errorf("UID %q", uid)
+		}
+	}
+
+	// Distinct for names that reduce to the same characters or none
+	seen := map[string]string{}
+	for _, location := range []string{"東京", "大阪", "Москва", "Киев", "New York", "new-york", "New_York", "!!!"} {
+		part := calendarUIDPart(location)
+		if part == "" || strings.Trim(part, "abcdefghijklmnopqrstuvwxyz0123456789-") != "" {
+			t.Errorf("calendarUIDPart(%q) = %q", location, part)
+		}
+		if other, ok := seen[part]; ok {
+			t.Errorf("%q and %q share the UID part %q", location, other, part)
+		}
+		seen[part] = location
+	}
+}
+
+func TestCalendarLineFolding(t *testing.T) {
+	days := testWeatherData("東京", 12).Weather[:1]
+	name := strings.Repeat("東京都千代田区, ", 8) + "Japan"
+	body := renderCalendar("東京", name, days, calendarOptions{}, time.Now())
+
+	lines := unfoldCalendar(t, body)
+	want := "LOCATION:" + calendarEscape(name)
+	found := false
+	for _, line := range lines {
+		found = found || line == want
+	}
+	if !found {
+		t.Errorf("unfolded calendar has no %q", want)
+	}
+	if lines[0] != "BEGIN:VCALENDAR" || lines[len(lines)-1] != "END:VCALENDAR" {
+		t.Errorf("calendar runs from %q to %q", lines[0], lines[len(lines)-1])
+	}
+}
+
+func TestCalendarAlarms(t *testing.T) {
+	days := testWeatherData("Oslo", 12).Weather
+	// Only the second day is wet enough to warn about
+	for h := range days[1].Hourly {
+		days[1].Hourly[h].ChanceOfRain = "75"
+	}
+
+	count := func(lines []string, line string) int {
+		n := 0
+		for _, l := range lines {
+			if l == line {
+				n++
+			}
+		}
+		return n
+	}
+
+	lines := unfoldCalendar(t, renderCalendar("Oslo", "Oslo, Norway", days, calendarOptions{}, time.Now()))
+	if n := count(lines, "BEGIN:VALARM"); n != 0 {
+		t.Errorf("%d alarms without ?alarms=true", n)
+	}
+
+	lines = unfoldCalendar(t, renderCalendar("Oslo", "Oslo, Norway", days, calendarOptions{Alarms: true}, time.Now()))
+	if n := count(lines, "BEGIN:VALARM"); n != 1 {
+		t.Fatalf("%d alarms, want 1", n)
+	}
+	// The alarm sits in the second day's event, in order
+	var event []string
+	for i, line := range lines {
+		if line == "DTSTART;VALUE=DATE:"+strings.ReplaceAll(days[1].Date, "-", "") {
+			for _, l := range lines[i:] {
+				event = append(event, l)
+				if l == "END:VEVENT" {
+					break
+				}
+			}
+		}
+	}
+	want := []string{
+		"BEGIN:VALARM",
+		"ACTION:DISPLAY",
+		"TRIGGER:-PT3H",
+		`DESCRIPTION:Rain (75% chance) forecast in Oslo\, Norway`,
+		"END:VALARM",
+		"END:VEVENT",
+	}
+	if len(event) < len(want) || strings.Join(event[len(event)-len(want):], "\n") != strings.Join(want, "\n") {
+		t.Errorf("second day's event ends\n%s\nwant\n%s", strings.Join(event, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCalendarHandler(t *testing.T) {
+	app := newTestApp(t, DefaultConfig())
+	fakeUpstream(t, app, serveWeather(testWeatherData("東京", 12)))
+	handler := app.routes()
+
+	rec := getRoute(handler, "/calendar/東京.ics?alarms=true", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/calendar; charset=utf-8" {
+		t.Errorf("content type = %q", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != `inline; filename="`+calendarUIDPart("東京")+`.ics"` {
+		t.Errorf("content disposition = %q", cd)
+	}
+	unfoldCalendar(t, rec.Body.String())
+
+	if rec := getRoute(handler, "/calendar/東京.ics?alarms=maybe", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad alarms: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// PNG weather cards for chat unfurls and wiki pages. The layout is designed
+// on a CardDefaultWidth-wide grid and scaled to the requested width and
+// pixel density.
+
+// cardTheme is the palette of a card
+type cardTheme struct {
+	Background color.RGBA
+	Panel      color.RGBA
+	Text       color.RGBA
+	Muted      color.RGBA
+}
+
+var cardThemes = map[string]cardTheme{
+	"light": {
+		Background: color.RGBA{0xff, 0xff, 0xff, 0xff},
+		Panel:      color.RGBA{0xf1, 0xf2, 0xf6, 0xff},
+		Text:       color.RGBA{0x2d, 0x34, 0x36, 0xff},
+		Muted:      color.RGBA{0x63, 0x6e, 0x72, 0xff},
+	},
+	"dark": {
+		Background: color.RGBA{0x1e, 0x27, 0x2e, 0xff},
+		Panel:      color.RGBA{0x2f, 0x36, 0x40, 0xff},
+		Text:       color.RGBA{0xf5, 0xf6, 0xfa, 0xff},
+		Muted:      color.RGBA{0xb2, 0xbe, 0xc3, 0xff},
+	},
+}
+
+// cardFonts are the embedded Go fonts, parsed once
+var cardFonts = sync.OnceValues(func() ([2]*opentype.Font, error) {
+	regular, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return [2]*opentype.Font{}, fmt.Errorf("regular font: %w", err)
+	}
+	bold, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return [2]*opentype.Font{}, fmt.Errorf("bold font: %w", err)
+	}
+	return [2]*opentype.Font{regular, bold}, nil
+})
+
+// cardOptions are the query parameters of a card
+type cardOptions struct {
+	Width int
+	Scale int
+	Theme cardTheme
+}
+
+// parseCardOptions reads ?width=, ?scale= and ?theme=
+func parseCardOptions(r *http.Request) (cardOptions, error) {
+	q := r.URL.Query()
+	opts := cardOptions{Width: CardDefaultWidth, Scale: 1, Theme: cardThemes["light"]}
+
+	if v := q.Get("width"); v != "" {
+		width, err := strconv.Atoi(v)
+		if err != nil || width < CardMinWidth || width > CardMaxWidth {
+			return opts, fmt.Errorf("width must be between %d and %d", CardMinWidth, CardMaxWidth)
+		}
+		opts.Width = width
+	}
+	if v := q.Get("scale"); v != "" {
+		scale, err := strconv.Atoi(v)
+		if err != nil || scale < 1 || scale > CardMaxScale {
+			return opts, fmt.Errorf("scale must be between 1 and %d", CardMaxScale)
+		}
+		opts.Scale = scale
+	}
+	if v := q.Get("theme"); v != "" {
+		theme, ok := cardThemes[v]
+		if !ok {
+			return opts, fmt.Errorf("theme must be light or dark")
+		}
+		opts.Theme = theme
+	}
+	return opts, nil
+}
+
+// cardHandler serves GET /card/{location}.png
+func (app *App) cardHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseCardOptions(r)
+	if err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	location := strings.TrimSpace(mux.Vars(r)["location"])
+	weatherData, ok := app.fetchForText(w, r, location)
+	if !ok {
+		return
+	}
+
+	img, err := renderCard(app.processWeatherData(weatherData), weatherData.CurrentCondition[0], opts)
+	if err != nil {
+		log.Printf("Error rendering card for %q: %v", location, err)
+		writeText(w, http.StatusInternalServerError, ErrCardRender)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		log.Printf("Error encoding card for %q: %v", location, err)
+		writeText(w, http.StatusInternalServerError, ErrCardRender)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	app.setImageCacheHeaders(w)
+	w.Write(buf.Bytes())
+}
+
+// setImageCacheHeaders applies images.cache_ttl to a card or widget response
+func (app *App) setImageCacheHeaders(w http.ResponseWriter) {
+	ttl := app.config.Images.CacheTTL.Duration
+	if ttl <= 0 {
+		w.Header().Set("Cache-Control", "no-cache")
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(ttl.Seconds())))
+}
+
+// cardCanvas draws in layout units, which are scaled to pixels
+type cardCanvas struct {
+	img   *image.RGBA
+	unit  float64
+	fonts [2]*opentype.Font
+	err   error
+}
+
+func (c *cardCanvas) px(v float64) int {
+	return int(math.Round(v * c.unit))
+}
+
+// text draws s with its baseline at y. align is -1 for left, 0 for centre
+// and 1 for right aligned at x. Text wider than maxWidth is shortened.
+func (c *cardCanvas) text(s string, x, y, size float64, bold bool, col color.Color, align int, maxWidth float64) {
+	if c.err != nil {
+		return
+	}
+	f := c.fonts[0]
+	if bold {
+		f = c.fonts[1]
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size * c.unit,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		c.err = err
+		return
+	}
+	defer face.Close()
+
+	d := &font.Drawer{Dst: c.img, Src: image.NewUniform(col), Face: face}
+	limit := fixed.I(c.px(maxWidth))
+	if maxWidth > 0 && d.MeasureString(s) > limit {
+		runes := []rune(s)
+		for len(runes) > 0 && d.MeasureString(string(runes)+"…") > limit {
+			runes = runes[:len(runes)-1]
+		}
+		s = strings.TrimSpace(string(runes)) + "…"
+	}
+
+	width := d.MeasureString(s)
+	dot := fixed.I(c.px(x))
+	switch align {
+	case 0:
+		dot -= width / 2
+	case 1:
+		dot -= width
+	}
+	d.Dot = fixed.Point26_6{X: dot, Y: fixed.I(c.px(y))}
+	d.DrawString(s)
+}
+
+// icon draws the named weather icon in a size x size box at x, y
+func (c *cardCanvas) icon(name string, x, y, size float64) {
+	if c.err != nil {
+		return
+	}
+	rect := image.Rect(c.px(x), c.px(y), c.px(x)+c.px(size), c.px(y)+c.px(size))
+	c.err = drawIcon(c.img, rect, name, func(s string, at point, size float64, col color.Color) {
+		c.text(s, at.X/c.unit, at.Y/c.unit, size/c.unit, false, col, 0, 0)
+	})
+}
+
+// iconPNG rasterizes the named weather icon into a size x size PNG with a
+// transparent background, for mail clients that do not display SVG
+func iconPNG(name string, size int) ([]byte, error) {
+	fonts, err := cardFonts()
+	if err != nil {
+		return nil, err
+	}
+	c := &cardCanvas{img: image.NewRGBA(image.Rect(0, 0, size, size)), unit: 1, fonts: fonts}
+	c.icon(name, 0, 0, float64(size))
+	if c.err != nil {
+		return nil, c.err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, c.img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// panel fills a rounded rectangle
+func (c *cardCanvas) panel(x, y, w, h, radius float64, col color.Color) {
+	rect := image.Rect(c.px(x), c.px(y), c.px(x+w), c.px(y+h))
+	pw, ph, r := float32(rect.Dx()), float32(rect.Dy()), float32(radius*c.unit)
+	// Control points approximating quarter circles
+	k := r * 0.448
+
+	z := vector.NewRasterizer(rect.Dx(), rect.Dy())
+	z.MoveTo(r, 0)
+	z.LineTo(pw-r, 0)
+	z.CubeTo(pw-k, 0, pw, k, pw, r)
+	z.LineTo(pw, ph-r)
+	z.CubeTo(pw, ph-k, pw-k, ph, pw-r, ph)
+	z.LineTo(r, ph)
+	z.CubeTo(k, ph, 0, ph-k, 0, ph-r)
+	z.LineTo(0, r)
+	z.CubeTo(0, k, k, 0, r, 0)
+	z.ClosePath()
+	z.Draw(c.img, rect, image.NewUniform(col), image.Point{})
+}
+
+// renderCard lays out the current conditions and the forecast strip
+func renderCard(data PageData, current CurrentCondition, opts cardOptions) (*image.RGBA, error) {
+	fonts, err := cardFonts()
+	if err != nil {
+		return nil, err
+	}
+
+	unit := float64(opts.Width*opts.Scale) / CardDefaultWidth
+	const layoutWidth, layoutHeight, pad = CardDefaultWidth, 330.0, 24.0
+	img := image.NewRGBA(image.Rect(0, 0, opts.Width*opts.Scale, int(math.Round(layoutHeight*unit))))
+	draw.Draw(img, img.Bounds(), image.NewUniform(opts.Theme.Background), image.Point{}, draw.Src)
+
+	c := &cardCanvas{img: img, unit: unit, fonts: fonts}
+	theme := opts.Theme
+	textWidth := layoutWidth - 150 - pad
+
+	// Current conditions
+	c.icon(weatherIconName(data.Description), pad, pad-6, 110)
+	c.text(data.Location, 150, 46, 20, true, theme.Text, -1, textWidth)
+	c.text(current.TempC+"°C", 150, 100, 48, true, theme.Text, -1, textWidth)
+	c.text(data.Description, 150, 130, 16, false, theme.Muted, -1, textWidth)
+	details := fmt.Sprintf("Feels like %s°C  ·  Humidity %s  ·  Wind %s", current.FeelsLikeC, data.Humidity, data.Wind)
+	c.text(details, pad, 168, 14, false, theme.Muted, -1, layoutWidth-2*pad)
+
+	// Forecast strip
+	if n := len(data.Forecast); n > 0 {
+		const top, height, gap = 186.0, 120.0, 12.0
+		width := (layoutWidth - 2*pad - float64(n-1)*gap) / float64(n)
+		for i, day := range data.Forecast {
+			x := pad + float64(i)*(width+gap)
+			mid := x + width/2
+			c.panel(x, top, width, height, 10, theme.Panel)
+			c.text(day.Day, mid, top+24, 14, true, theme.Text, 0, width-16)
+			c.icon(weatherIconName(day.Description), mid-24, top+30, 48)
+			c.text(day.Temperature, mid, top+100, 14, false, theme.Text, 0, width-16)
+		}
+	}
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	return img, nil
+}
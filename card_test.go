@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"testing"
+)
+
+func TestCardHandler(t *testing.T) {
+	app := newTestApp(t, DefaultConfig())
+	fakeUpstream(t, app, serveWeather(testWeatherData("Tromsø", 12)))
+	handler := app.routes()
+
+	for _, tt := range []struct {
+		target string
+		width  int
+	}{
+		{"/card/Tromsø.png", CardDefaultWidth},
+		{"/card/Tromsø.png?theme=dark&width=400", 400},
+		{"/card/Tromsø.png?scale=2", 2 * CardDefaultWidth},
+	} {
+		rec := getRoute(handler, tt.target, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d: %s", tt.target, rec.Code, rec.Body)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+			t.Errorf("GET %s: content type %q", tt.target, ct)
+		}
+		img, err := png.Decode(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("GET %s: PNG does not decode: %v", tt.target, err)
+		}
+		if w := img.Bounds().Dx(); w != tt.width {
+			t.Errorf("GET %s: width %d, want %d", tt.target, w, tt.width)
+		}
+	}
+
+	for _, target := range []string{
+		"/card/Tromsø.png?width=1",
+		"/card/Tromsø.png?scale=9",
+		"/card/Tromsø.png?theme=neon",
+	} {
+		if rec := getRoute(handler, target, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: status %d, want 400", target, rec.Code)
+		}
+	}
+}
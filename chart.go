@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Server-rendered SVG charts of the hourly forecast: temperature and
+// feels-like lines over precipitation probability bars. Styling uses
+// presentation attributes only, so the markup can be inlined under the
+// page's CSP.
+
+const (
+	chartWidth  = 720
+	chartHeight = 260
+
+	chartLeft   = 44
+	chartRight  = 44
+	chartTop    = 28
+	chartBottom = 40
+
+	chartTempColor   = "#e17055"
+	chartFeelsColor  = "#6c5ce7"
+	chartPrecipColor = "#74b9ff"
+	chartGridColor   = "#dfe6e9"
+	chartTextColor   = "#636e72"
+)
+
+// chartPoint is one hourly sample
+type chartPoint struct {
+	Day       int
+	Hour      int
+	Temp      float64
+	FeelsLike float64
+	Precip    float64
+}
+
+// chartPoints flattens the hourly data of days
+func chartPoints(days []Weather, imperial bool) []chartPoint {
+	var points []chartPoint
+	for d, day := range days {
+		for _, h := range day.Hourly {
+			temp, feels := h.TempC, h.FeelsLikeC
+			if imperial {
+				temp, feels = h.TempF, h.FeelsLikeF
+			}
+			points = append(points, chartPoint{
+				Day:       d,
+				Hour:      atoi(h.Time) / 100,
+				Temp:      float64(atoi(temp)),
+				FeelsLike: float64(atoi(feels)),
+				Precip:    float64(max(atoi(h.ChanceOfRain), atoi(h.ChanceOfSnow))),
+			})
+		}
+	}
+	return points
+}
+
+// niceStep picks a 1, 2 or 5 × 10^n tick step giving about target ticks
+func niceStep(span float64, target int) float64 {
+	if span <= 0 {
+		return 1
+	}
+	raw := span / float64(target)
+	mag := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if raw <= m*mag {
+			return m * mag
+		}
+	}
+	return 10 * mag
+}
+
+// renderForecastChart returns the chart for days as SVG markup, or "" when
+// there is no hourly data
+func renderForecastChart(days []Weather, imperial bool) string {
+	points := chartPoints(days, imperial)
+	if len(points) < 2 {
+		return ""
+	}
+	unit := "°C"
+	if imperial {
+		unit = "°F"
+	}
+
+	// Temperature axis, padded to whole ticks
+	lo, hi := points[0].Temp, points[0].Temp
+	for _, p := range points {
+		lo = math.Min(lo, math.Min(p.Temp, p.FeelsLike))
+		hi = math.Max(hi, math.Max(p.Temp, p.FeelsLike))
+	}
+	step := niceStep(hi-lo, 4)
+	lo = math.Floor(lo/step) * step
+	hi = math.Ceil(hi/step) * step
+	if hi == lo {
+		hi = lo + step
+	}
+
+	plotW := float64(chartWidth - chartLeft - chartRight)
+	plotH := float64(chartHeight - chartTop - chartBottom)
+	// Each hour gets a slot so bars stay inside the plot
+	slot := plotW / float64(len(points))
+	x := func(i int) float64 { return chartLeft + (float64(i)+0.5)*slot }
+	yTemp := func(t float64) float64 { return chartTop + (hi-t)/(hi-lo)*plotH }
+	yPrecip := func(p float64) float64 { return chartTop + (100-p)/100*plotH }
+
+	var b svgBuilder
+	title := fmt.Sprintf("Hourly temperature, feels-like (%s) and precipitation probability", unit)
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="100%%" font-family="%s" role="img" aria-label="%s">`,
+		chartWidth, chartHeight, template.HTMLEscapeString(widgetFontFamily), title)
+	fmt.Fprintf(&b, `<title>%s</title>`, title)
+
+	// Grid and temperature axis
+	for t := lo; t <= hi+step/2; t += step {
+		y := yTemp(t)
+		fmt.Fprintf(&b, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="%s" stroke-width="1"/>`,
+			chartLeft, y, chartWidth-chartRight, y, chartGridColor)
+		b.text(strconv.FormatFloat(t, 'f', -1, 64)+"°", chartLeft-6, y+4, 11, false, chartTextColor, "end")
+	}
+	// Precipitation axis
+	for p := 0.0; p <= 100; p += 50 {
+		b.text(fmt.Sprintf("%.0f%%", p), chartWidth-chartRight+6, yPrecip(p)+4, 11, false, chartPrecipColor, "start")
+	}
+
+	// Precipitation bars behind the lines
+	barW := slot * 0.6
+	for i, p := range points {
+		if p.Precip <= 0 {
+			continue
+		}
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s" opacity="0.45"/>`,
+			x(i)-barW/2, yPrecip(p.Precip), barW, yPrecip(0)-yPrecip(p.Precip), chartPrecipColor)
+	}
+
+	// Day separators and hour labels
+	for i, p := range points {
+		if i > 0 && p.Day != points[i-1].Day {
+			sep := (x(i-1) + x(i)) / 2
+			fmt.Fprintf(&b, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="%s" stroke-width="1" stroke-dasharray="3 3"/>`,
+				sep, chartTop, sep, chartHeight-chartBottom, chartTextColor)
+		}
+		if p.Hour%6 == 0 {
+			b.text(fmt.Sprintf("%02d", p.Hour), x(i), float64(chartHeight-chartBottom+14), 10, false, chartTextColor, "middle")
+		}
+	}
+	for d := range days {
+		first, last := -1, -1
+		for i, p := range points {
+			if p.Day == d {
+				if first < 0 {
+					first = i
+				}
+				last = i
+			}
+		}
+		if first < 0 {
+			continue
+		}
+		b.text(forecastDayName(days[d].Date, d), (x(first)+x(last))/2, float64(chartHeight-8), 12, true, chartTextColor, "middle")
+	}
+
+	// Lines
+	polyline := func(value func(chartPoint) float64) string {
+		coords := make([]string, len(points))
+		for i, p := range points {
+			coords[i] = fmt.Sprintf("%.1f,%.1f", x(i), yTemp(value(p)))
+		}
+		return strings.Join(coords, " ")
+	}
+	fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2" stroke-dasharray="5 4"/>`,
+		polyline(func(p chartPoint) float64 { return p.FeelsLike }), chartFeelsColor)
+	fmt.Fprintf(&b, `<polyline points="%s" fill="none" stroke="%s" stroke-width="2.5" stroke-linejoin="round"/>`,
+		polyline(func(p chartPoint) float64 { return p.Temp }), chartTempColor)
+
+	// Legend
+	legend := []struct{ label, color string }{
+		{"Temperature (" + unit + ")", chartTempColor},
+		{"Feels like (" + unit + ")", chartFeelsColor},
+		{"Precipitation (%)", chartPrecipColor},
+	}
+	lx := float64(chartLeft)
+	for _, item := range legend {
+		fmt.Fprintf(&b, `<rect x="%.1f" y="8" width="12" height="12" rx="2" fill="%s"/>`, lx, item.color)
+		b.text(item.label, lx+17, 18, 12, false, chartTextColor, "start")
+		lx += 17 + float64(len(item.label))*6.5 + 18
+	}
+
+	return b.close()
+}
+
+// chartUnits reads the chart's ?units=, which is metric unless given as
+// imperial
+func chartUnits(r *http.Request) (imperial bool, ok bool) {
+	switch r.FormValue("units") {
+	case "", "metric":
+		return false, true
+	case "imperial":
+		return true, true
+	}
+	return false, false
+}
+
+// chartHandler serves GET /chart/{location}.svg
+func (app *App) chartHandler(w http.ResponseWriter, r *http.Request) {
+	imperial, ok := chartUnits(r)
+	if !ok {
+		writeText(w, http.StatusBadRequest, ErrInvalidUnits)
+		return
+	}
+
+	location := strings.TrimSpace(mux.Vars(r)["location"])
+	weatherData, ok := app.fetchForText(w, r, location)
+	if !ok {
+		return
+	}
+
+	svg := renderForecastChart(forecastDays(weatherData.Weather), imperial)
+	if svg == "" {
+		writeText(w, http.StatusBadGateway, ErrInvalidWeatherData)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(svg)))
+	app.setImageCacheHeaders(w)
+	w.Write([]byte(svg))
+}
+
+// forecastDays limits days to MaxForecastDays
+func forecastDays(days []Weather) []Weather {
+	if len(days) > MaxForecastDays {
+		return days[:MaxForecastDays]
+	}
+	return days
+}
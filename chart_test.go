@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const browserAccept = "text/html,application/xhtml+xml,*/*;q=0.8"
+
+// getRoute serves GET target through the app's routes
+func getRoute(handler http.Handler, target, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// parseSVG checks that body is well-formed XML with an <svg> root
+func parseSVG(t *testing.T, body string) {
+	t.Helper()
+	d := xml.NewDecoder(strings.NewReader(body))
+	root := ""
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("SVG does not parse: %v\n%s", err, body)
+		}
+		if start, ok := tok.(xml.StartElement); ok && root == "" {
+			root = start.Name.Local
+		}
+	}
+	if root != "svg" {
+		t.Errorf("root element = %q, want svg", root)
+	}
+}
+
+func TestChartHandler(t *testing.T) {
+	app := newTestApp(t, DefaultConfig())
+	fakeUpstream(t, app, serveWeather(testWeatherData("Oslo", 12)))
+	handler := app.routes()
+
+	for _, tt := range []struct {
+		target, unit string
+	}{
+		{"/chart/Oslo.svg", "°C"},
+		{"/chart/Oslo.svg?units=metric", "°C"},
+		{"/chart/Oslo.svg?units=imperial", "°F"},
+	} {
+		rec := getRoute(handler, tt.target, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d: %s", tt.target, rec.Code, rec.Body)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "image/svg+xml; charset=utf-8" {
+			t.Errorf("GET %s: content type %q", tt.target, ct)
+		}
+		parseSVG(t, rec.Body.String())
+		if !strings.Contains(rec.Body.String(), "Temperature ("+tt.unit+")") {
+			t.Errorf("GET %s: legend not in %s", tt.target, tt.unit)
+		}
+	}
+
+	if rec := getRoute(handler, "/chart/Oslo.svg?units=kelvin", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown units: status %d", rec.Code)
+	}
+}
+
+func TestPageChartUnits(t *testing.T) {
+	app := newTestApp(t, DefaultConfig())
+	fakeUpstream(t, app, serveWeather(testWeatherData("Oslo", 12)))
+	handler := app.routes()
+
+	for target, unit := range map[string]string{
+		"/Oslo":                "°C",
+		"/Oslo?units=imperial": "°F",
+	} {
+		rec := getRoute(handler, target, browserAccept)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d", target, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), "Temperature ("+unit+")") {
+			t.Errorf("GET %s: page chart not in %s", target, unit)
+		}
+	}
+}
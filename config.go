@@ -0,0 +1,470 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Duration wraps time.Duration so it can be written as "15s" in JSON config
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON accepts either a Go duration string or a number of seconds
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		parsed, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q: %w", s, err)
+		}
+		d.Duration = parsed
+		return nil
+	}
+
+	var secs float64
+	if err := json.Unmarshal(b, &secs); err != nil {
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	d.Duration = time.Duration(secs * float64(time.Second))
+	return nil
+}
+
+// MarshalJSON writes the duration in its string form
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// ServerConfig holds the HTTP server settings
+type ServerConfig struct {
+	Addr              string    `json:"addr"`
+	ReadTimeout       Duration  `json:"read_timeout"`
+	ReadHeaderTimeout Duration  `json:"read_header_timeout"`
+	WriteTimeout      Duration  `json:"write_timeout"`
+	IdleTimeout       Duration  `json:"idle_timeout"`
+	MaxHeaderBytes    int       `json:"max_header_bytes"`
+	ShutdownTimeout   Duration  `json:"shutdown_timeout"`
+	TLS               TLSConfig `json:"tls"`
+}
+
+// TLSConfig enables native HTTPS serving
+type TLSConfig struct {
+	Enabled        bool     `json:"enabled"`
+	CertFile       string   `json:"cert_file"`
+	KeyFile        string   `json:"key_file"`
+	RedirectAddr   string   `json:"redirect_addr"`
+	HSTSMaxAge     Duration `json:"hsts_max_age"`
+	ReloadInterval Duration `json:"reload_interval"`
+}
+
+// Config is the application configuration, loaded from an optional JSON file
+type Config struct {
+	Server    ServerConfig    `json:"server"`
+	RateLimit RateLimitConfig `json:"rate_limit"`
+	API       APIConfig       `json:"api"`
+	Live      LiveConfig      `json:"live"`
+	WebSocket WebSocketConfig `json:"websocket"`
+
+	// Favourites are the locations that scheduled jobs work on
+	Favourites []string       `json:"favourites"`
+	Alerts     AlertsConfig   `json:"alerts"`
+	Webhooks   WebhooksConfig `json:"webhooks"`
+	Digest     DigestConfig   `json:"digest"`
+	Slack      SlackConfig    `json:"slack"`
+	Bots       BotsConfig     `json:"bots"`
+	MQTT       MQTTConfig     `json:"mqtt"`
+	Images     ImagesConfig   `json:"images"`
+	Embed      EmbedConfig    `json:"embed"`
+	History    HistoryConfig  `json:"history"`
+	Feeds      FeedsConfig    `json:"feeds"`
+}
+
+// FeedsConfig keeps Atom feed entries across restarts when StateFile is set.
+// At most MaxLocations feeds are kept, and a feed not requested within
+// Expiry is dropped.
+type FeedsConfig struct {
+	StateFile    string   `json:"state_file"`
+	MaxLocations int      `json:"max_locations"`
+	Expiry       Duration `json:"expiry"`
+}
+
+// HistoryConfig enables recording current conditions to a SQLite database.
+// Locations default to favourites; a zero Retention keeps everything.
+type HistoryConfig struct {
+	Database  string   `json:"database"`
+	Locations []string `json:"locations"`
+	Interval  Duration `json:"interval"`
+	Retention Duration `json:"retention"`
+}
+
+// EmbedConfig lists the origins allowed to frame /embed pages, e.g.
+// https://intranet.example.com. The app's own origin is always allowed.
+type EmbedConfig struct {
+	FrameAncestors []string `json:"frame_ancestors"`
+}
+
+// ImagesConfig controls the PNG cards and SVG widgets. CacheTTL is sent as
+// the Cache-Control max-age; zero disables caching.
+type ImagesConfig struct {
+	CacheTTL Duration `json:"cache_ttl"`
+}
+
+// MQTTConfig enables publishing current conditions to an MQTT broker.
+// Broker is a URL such as tcp://host:1883 or ssl://host:8883.
+type MQTTConfig struct {
+	Broker      string        `json:"broker"`
+	ClientID    string        `json:"client_id"`
+	Username    string        `json:"username"`
+	Password    string        `json:"password"`
+	TLS         MQTTTLSConfig `json:"tls"`
+	TopicPrefix string        `json:"topic_prefix"`
+	Locations   []string      `json:"locations"`
+	Interval    Duration      `json:"interval"`
+	QoS         byte          `json:"qos"`
+	Retain      bool          `json:"retain"`
+	Discovery   MQTTDiscovery `json:"discovery"`
+}
+
+// MQTTTLSConfig holds the broker TLS settings
+type MQTTTLSConfig struct {
+	Enabled            bool   `json:"enabled"`
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// MQTTDiscovery controls Home Assistant MQTT discovery messages
+type MQTTDiscovery struct {
+	Enabled bool   `json:"enabled"`
+	Prefix  string `json:"prefix"`
+}
+
+// BotsConfig enables the Telegram and Discord bots. DailyAt is the default
+// time for subscribe without an argument, in Timezone.
+type BotsConfig struct {
+	StateFile string         `json:"state_file"`
+	Timezone  string         `json:"timezone"`
+	DailyAt   string         `json:"daily_at"`
+	Telegram  TelegramConfig `json:"telegram"`
+	Discord   DiscordConfig  `json:"discord"`
+}
+
+// TelegramConfig holds the bot token and the webhook secret token
+type TelegramConfig struct {
+	Token       string `json:"token"`
+	SecretToken string `json:"secret_token"`
+	APIURL      string `json:"api_url"`
+}
+
+// DiscordConfig holds the application's keys
+type DiscordConfig struct {
+	PublicKey     string `json:"public_key"`
+	BotToken      string `json:"bot_token"`
+	ApplicationID string `json:"application_id"`
+	APIURL        string `json:"api_url"`
+}
+
+// SlackConfig enables the Slack slash command. ResponseType is
+// "in_channel" or "ephemeral".
+type SlackConfig struct {
+	SigningSecret string `json:"signing_secret"`
+	ResponseType  string `json:"response_type"`
+}
+
+// DigestConfig schedules the morning forecast email
+type DigestConfig struct {
+	SendAt     string            `json:"send_at"`
+	Timezone   string            `json:"timezone"`
+	From       string            `json:"from"`
+	SMTP       SMTPConfig        `json:"smtp"`
+	Recipients []DigestRecipient `json:"recipients"`
+}
+
+// SMTPConfig is the outgoing mail server. TLS is "starttls", "implicit" or
+// "none".
+type SMTPConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	TLS      string `json:"tls"`
+}
+
+// sendClock returns the hour and minute of SendAt, which validate has
+// already checked
+func (d DigestConfig) sendClock() (int, int) {
+	t, _ := time.Parse("15:04", d.SendAt)
+	return t.Hour(), t.Minute()
+}
+
+// WebhooksConfig lists the endpoints notified of alert events
+type WebhooksConfig struct {
+	Endpoints      []WebhookEndpoint `json:"endpoints"`
+	Timeout        Duration          `json:"timeout"`
+	MaxAttempts    int               `json:"max_attempts"`
+	InitialBackoff Duration          `json:"initial_backoff"`
+	MaxBackoff     Duration          `json:"max_backoff"`
+	DeadLetterFile string            `json:"dead_letter_file"`
+}
+
+// AlertsConfig holds the alert rules and how often they are evaluated
+type AlertsConfig struct {
+	Interval  Duration    `json:"interval"`
+	StateFile string      `json:"state_file"`
+	Rules     []AlertRule `json:"rules"`
+}
+
+// WebSocketConfig controls the multi-location subscription endpoint
+type WebSocketConfig struct {
+	MaxSubscriptions int      `json:"max_subscriptions"`
+	AllowedOrigins   []string `json:"allowed_origins"`
+}
+
+// LiveConfig controls the background poller behind live updates
+type LiveConfig struct {
+	PollInterval Duration `json:"poll_interval"`
+}
+
+// APIConfig controls API-key authentication for the JSON API. Usage
+// counters, and so daily quotas, survive restarts only when UsageFile is
+// set.
+type APIConfig struct {
+	RequireKey     bool     `json:"require_key"`
+	Keys           []APIKey `json:"keys"`
+	KeysFile       string   `json:"keys_file"`
+	ReloadInterval Duration `json:"reload_interval"`
+	AdminToken     string   `json:"admin_token"`
+	UsageFile      string   `json:"usage_file"`
+}
+
+// RateLimitConfig limits requests per client IP and calls to wttr.in
+type RateLimitConfig struct {
+	Enabled           bool `json:"enabled"`
+	ClientPerMinute   int  `json:"client_per_minute"`
+	ClientBurst       int  `json:"client_burst"`
+	UpstreamPerMinute int  `json:"upstream_per_minute"`
+	TrustProxyHeaders bool `json:"trust_proxy_headers"`
+	// TrustedProxyHops is the number of proxies in front of the server,
+	// each of which appends the address it saw to X-Forwarded-For
+	TrustedProxyHops int `json:"trusted_proxy_hops"`
+}
+
+// DefaultConfig returns the configuration used when no file is given
+func DefaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:              ServerPort,
+			ReadTimeout:       Duration{DefaultReadTimeout},
+			ReadHeaderTimeout: Duration{DefaultReadHeaderTimeout},
+			WriteTimeout:      Duration{DefaultWriteTimeout},
+			IdleTimeout:       Duration{DefaultIdleTimeout},
+			MaxHeaderBytes:    DefaultMaxHeaderBytes,
+			ShutdownTimeout:   Duration{DefaultShutdownTimeout},
+			TLS: TLSConfig{
+				HSTSMaxAge:     Duration{DefaultHSTSMaxAge},
+				ReloadInterval: Duration{DefaultCertReloadInterval},
+			},
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:           true,
+			ClientPerMinute:   DefaultClientPerMinute,
+			ClientBurst:       DefaultClientBurst,
+			UpstreamPerMinute: DefaultUpstreamPerMinute,
+			TrustedProxyHops:  1,
+		},
+		API: APIConfig{
+			ReloadInterval: Duration{DefaultKeysReloadInterval},
+		},
+		Live: LiveConfig{
+			PollInterval: Duration{DefaultPollInterval},
+		},
+		WebSocket: WebSocketConfig{
+			MaxSubscriptions: DefaultWSMaxSubscriptions,
+		},
+		Alerts: AlertsConfig{
+			Interval: Duration{DefaultAlertInterval},
+		},
+		Webhooks: WebhooksConfig{
+			Timeout:        Duration{DefaultWebhookTimeout},
+			MaxAttempts:    DefaultWebhookMaxAttempts,
+			InitialBackoff: Duration{DefaultWebhookInitialBackoff},
+			MaxBackoff:     Duration{DefaultWebhookMaxBackoff},
+		},
+		Digest: DigestConfig{
+			SendAt:   DefaultDigestSendAt,
+			Timezone: "Local",
+			SMTP: SMTPConfig{
+				Port: DefaultSMTPPort,
+				TLS:  SMTPStartTLS,
+			},
+		},
+		Slack: SlackConfig{
+			ResponseType: "in_channel",
+		},
+		Bots: BotsConfig{
+			Timezone: "Local",
+			DailyAt:  DefaultBotDailyAt,
+			Telegram: TelegramConfig{APIURL: DefaultTelegramAPIURL},
+			Discord:  DiscordConfig{APIURL: DefaultDiscordAPIURL},
+		},
+		MQTT: MQTTConfig{
+			ClientID:    DefaultMQTTClientID,
+			TopicPrefix: DefaultMQTTTopicPrefix,
+			Interval:    Duration{DefaultMQTTInterval},
+			Retain:      true,
+			Discovery: MQTTDiscovery{
+				Enabled: true,
+				Prefix:  DefaultMQTTDiscoveryPrefix,
+			},
+		},
+		Images: ImagesConfig{
+			CacheTTL: Duration{DefaultImageCacheTTL},
+		},
+		History: HistoryConfig{
+			Interval:  Duration{DefaultHistoryInterval},
+			Retention: Duration{DefaultHistoryRetention},
+		},
+		Feeds: FeedsConfig{
+			MaxLocations: DefaultFeedMaxLocations,
+			Expiry:       Duration{DefaultFeedExpiry},
+		},
+	}
+}
+
+// LoadConfig reads the JSON config at path on top of the defaults.
+// An empty path returns the defaults unchanged.
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config: %w", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// validate checks settings that depend on each other
+func (cfg *Config) validate() error {
+	if cfg.Server.TLS.Enabled && (cfg.Server.TLS.CertFile == "" || cfg.Server.TLS.KeyFile == "") {
+		return fmt.Errorf("server.tls requires cert_file and key_file")
+	}
+	if cfg.Server.TLS.Enabled && cfg.Server.TLS.ReloadInterval.Duration <= 0 {
+		return fmt.Errorf("server.tls.reload_interval must be positive")
+	}
+	if rl := cfg.RateLimit; rl.Enabled && rl.ClientPerMinute > 0 && rl.ClientBurst < 1 {
+		return fmt.Errorf("rate_limit.client_burst must be at least 1")
+	}
+	if rl := cfg.RateLimit; rl.TrustProxyHeaders && rl.TrustedProxyHops < 1 {
+		return fmt.Errorf("rate_limit.trusted_proxy_hops must be at least 1")
+	}
+	if cfg.API.KeysFile != "" && cfg.API.ReloadInterval.Duration <= 0 {
+		return fmt.Errorf("api.reload_interval must be positive")
+	}
+	if err := checkKeyNames(cfg.API.Keys); err != nil {
+		return fmt.Errorf("api.keys: %w", err)
+	}
+	for _, origin := range cfg.Embed.FrameAncestors {
+		if err := validateFrameAncestor(origin); err != nil {
+			return fmt.Errorf("embed.frame_ancestors: %w", err)
+		}
+	}
+	if cfg.History.Database != "" && cfg.History.Interval.Duration < MinPollInterval {
+		return fmt.Errorf("history.interval must be at least %s", MinPollInterval)
+	}
+	if cfg.History.Retention.Duration < 0 {
+		return fmt.Errorf("history.retention must not be negative")
+	}
+	if cfg.Feeds.MaxLocations <= 0 {
+		return fmt.Errorf("feeds.max_locations must be positive")
+	}
+	if cfg.Feeds.Expiry.Duration <= 0 {
+		return fmt.Errorf("feeds.expiry must be positive")
+	}
+	if cfg.Images.CacheTTL.Duration < 0 {
+		return fmt.Errorf("images.cache_ttl must not be negative")
+	}
+	if cfg.Live.PollInterval.Duration < MinPollInterval {
+		return fmt.Errorf("live.poll_interval must be at least %s", MinPollInterval)
+	}
+	ruleNames := make(map[string]bool, len(cfg.Alerts.Rules))
+	for _, rule := range cfg.Alerts.Rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("alerts: %w", err)
+		}
+		// Alert ids derive from the rule name, so names must be unique
+		if ruleNames[rule.Name] {
+			return fmt.Errorf("alerts: duplicate rule name %q", rule.Name)
+		}
+		ruleNames[rule.Name] = true
+	}
+	if len(cfg.Alerts.Rules) > 0 && cfg.Alerts.Interval.Duration < MinPollInterval {
+		return fmt.Errorf("alerts.interval must be at least %s", MinPollInterval)
+	}
+	if cfg.Webhooks.MaxAttempts < 1 {
+		return fmt.Errorf("webhooks.max_attempts must be at least 1")
+	}
+	if cfg.Webhooks.InitialBackoff.Duration <= 0 {
+		return fmt.Errorf("webhooks.initial_backoff must be positive")
+	}
+	if cfg.Webhooks.MaxBackoff.Duration <= 0 {
+		return fmt.Errorf("webhooks.max_backoff must be positive")
+	}
+	if rt := cfg.Slack.ResponseType; rt != "in_channel" && rt != "ephemeral" {
+		return fmt.Errorf("slack.response_type must be \"in_channel\" or \"ephemeral\"")
+	}
+	if cfg.Bots.Telegram.Token != "" && cfg.Bots.Telegram.SecretToken == "" {
+		return fmt.Errorf("bots.telegram requires secret_token")
+	}
+	if d := cfg.Bots.Discord; d.PublicKey != "" || d.BotToken != "" || d.ApplicationID != "" {
+		if d.PublicKey == "" || d.BotToken == "" || d.ApplicationID == "" {
+			return fmt.Errorf("bots.discord requires public_key, bot_token and application_id")
+		}
+		if _, err := strconv.ParseUint(d.ApplicationID, 10, 64); err != nil {
+			return fmt.Errorf("bots.discord.application_id must be numeric")
+		}
+	}
+	if _, err := time.Parse("15:04", cfg.Bots.DailyAt); err != nil {
+		return fmt.Errorf("bots.daily_at must be HH:MM: %w", err)
+	}
+	if m := cfg.MQTT; m.Broker != "" {
+		if m.QoS > 2 {
+			return fmt.Errorf("mqtt.qos must be 0, 1 or 2")
+		}
+		if m.Interval.Duration < MinPollInterval {
+			return fmt.Errorf("mqtt.interval must be at least %s", MinPollInterval)
+		}
+	}
+	if d := cfg.Digest; len(d.Recipients) > 0 {
+		if _, err := time.Parse("15:04", d.SendAt); err != nil {
+			return fmt.Errorf("digest.send_at must be HH:MM: %w", err)
+		}
+		if d.From == "" || d.SMTP.Host == "" {
+			return fmt.Errorf("digest requires from and smtp.host")
+		}
+		switch d.SMTP.TLS {
+		case SMTPStartTLS, SMTPImplicitTLS, SMTPNoTLS:
+		default:
+			return fmt.Errorf("digest.smtp.tls must be %q, %q or %q", SMTPStartTLS, SMTPImplicitTLS, SMTPNoTLS)
+		}
+	}
+	return nil
+}
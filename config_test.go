@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateRejectsBadSettings(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string
+	}{
+		{
+			name: "zero TLS reload interval",
+			modify: func(c *Config) {
+				c.Server.TLS = TLSConfig{Enabled: true, CertFile: "cert.pem", KeyFile: "key.pem"}
+			},
+			want: "server.tls.reload_interval",
+		},
+		{
+			name: "negative TLS reload interval",
+			modify: func(c *Config) {
+				c.Server.TLS = TLSConfig{Enabled: true, CertFile: "cert.pem", KeyFile: "key.pem",
+					ReloadInterval: Duration{-time.Second}}
+			},
+			want: "server.tls.reload_interval",
+		},
+		{
+			name: "trusted proxy without hops",
+			modify: func(c *Config) {
+				c.RateLimit.TrustProxyHeaders = true
+				c.RateLimit.TrustedProxyHops = 0
+			},
+			want: "rate_limit.trusted_proxy_hops",
+		},
+		{
+			name: "zero keys file reload interval",
+			modify: func(c *Config) {
+				c.API.KeysFile = "keys.json"
+				c.API.ReloadInterval = Duration{}
+			},
+			want: "api.reload_interval",
+		},
+		{
+			name: "duplicate alert rule names",
+			modify: func(c *Config) {
+				rule := AlertRule{Name: "Cold", Location: "Oslo", Metric: "temp_c", Operator: "<", Value: 0}
+				c.Alerts.Rules = []AlertRule{rule, rule}
+			},
+			want: "duplicate rule name",
+		},
+		{
+			name: "duplicate API key names",
+			modify: func(c *Config) {
+				c.API.Keys = []APIKey{{Key: "a", Name: "ops"}, {Key: "b", Name: "ops"}}
+			},
+			want: "duplicate API key name",
+		},
+		{
+			name:   "discord without bot token",
+			modify: func(c *Config) { c.Bots.Discord = DiscordConfig{PublicKey: "ab", ApplicationID: "1234"} },
+			want:   "bots.discord requires",
+		},
+		{
+			name:   "discord without public key",
+			modify: func(c *Config) { c.Bots.Discord = DiscordConfig{BotToken: "t", ApplicationID: "1234"} },
+			want:   "bots.discord requires",
+		},
+		{
+			name: "non-numeric discord application id",
+			modify: func(c *Config) {
+				c.Bots.Discord = DiscordConfig{PublicKey: "ab", BotToken: "t", ApplicationID: "my-app"}
+			},
+			want: "bots.discord.application_id",
+		},
+		{
+			name:   "zero webhook max backoff",
+			modify: func(c *Config) { c.Webhooks.MaxBackoff = Duration{} },
+			want:   "webhooks.max_backoff",
+		},
+		{
+			name:   "negative webhook initial backoff",
+			modify: func(c *Config) { c.Webhooks.InitialBackoff = Duration{-time.Second} },
+			want:   "webhooks.initial_backoff",
+		},
+		{
+			name:   "zero feed locations",
+			modify: func(c *Config) { c.Feeds.MaxLocations = 0 },
+			want:   "feeds.max_locations",
+		},
+		{
+			name:   "zero feed expiry",
+			modify: func(c *Config) { c.Feeds.Expiry = Duration{} },
+			want:   "feeds.expiry",
+		},
+		{
+			name:   "frame ancestor with a CSP keyword",
+			modify: func(c *Config) { c.Embed.FrameAncestors = []string{"https://example.com 'unsafe-inline'"} },
+			want:   "embed.frame_ancestors",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.modify(cfg)
+			err := cfg.validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("validate() = %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAcceptsDefaults(t *testing.T) {
+	if err := DefaultConfig().validate(); err != nil {
+		t.Fatalf("default config is invalid: %v", err)
+	}
+}
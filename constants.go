@@ -7,6 +7,149 @@ const (
 	ServerPort    = ":8080"
 	StaticPath    = "./static/"
 	
+	// HTTP server limits
+	DefaultReadTimeout       = 15 * time.Second
+	DefaultReadHeaderTimeout = 5 * time.Second
+	DefaultWriteTimeout      = 30 * time.Second
+	DefaultIdleTimeout       = 120 * time.Second
+	DefaultMaxHeaderBytes    = 1 << 20
+	DefaultShutdownTimeout   = 20 * time.Second
+	
+	// TLS defaults
+	DefaultHSTSMaxAge         = 365 * 24 * time.Hour
+	DefaultCertReloadInterval = 30 * time.Second
+	
+	// Security
+	CSPNonceBytes  = 16
+	CSRFTokenBytes = 32
+	CSRFCookieName = "csrf_token"
+	CSRFFieldName  = "csrf_token"
+	CSRFHeaderName = "X-CSRF-Token"
+	
+	// Rate limiting
+	DefaultClientPerMinute   = 30
+	DefaultClientBurst       = 10
+	DefaultUpstreamPerMinute = 60
+	RateLimitIdleTimeout     = 10 * time.Minute
+	
+	// JSON API authentication
+	APIKeyHeader              = "X-API-Key"
+	APIKeyQueryParam          = "api_key"
+	DefaultKeysReloadInterval = 15 * time.Second
+	APIUsageSaveInterval      = time.Minute
+	
+	// Live updates
+	DefaultPollInterval  = 10 * time.Minute
+	MinPollInterval      = 30 * time.Second
+	PollerWakeBuffer     = 64
+	SubscriberBuffer     = 16
+	SSEHeartbeatInterval = 25 * time.Second
+	SSERetry             = 10 * time.Second
+	
+	// WebSocket subscriptions
+	DefaultWSMaxSubscriptions = 100
+	WSSendBuffer              = 64
+	WSMaxMessageBytes         = 4096
+	WSWriteWait               = 10 * time.Second
+	WSPongWait                = 60 * time.Second
+	WSPingPeriod              = 50 * time.Second
+	
+	// Alerts
+	DefaultAlertInterval = 15 * time.Minute
+	
+	// Webhooks
+	DefaultWebhookTimeout        = 10 * time.Second
+	DefaultWebhookMaxAttempts    = 5
+	DefaultWebhookInitialBackoff = 2 * time.Second
+	DefaultWebhookMaxBackoff     = 2 * time.Minute
+	WebhookQueueSize             = 256
+	WebhookUserAgent             = "wttr-app-webhooks/1.0"
+	
+	// Email digest
+	DefaultDigestSendAt = "07:00"
+	DefaultSMTPPort     = 587
+	DigestForecastDays  = 2
+	DigestIconSize      = 96
+	SMTPTimeout         = 30 * time.Second
+	SMTPStartTLS        = "starttls"
+	SMTPImplicitTLS     = "implicit"
+	SMTPNoTLS           = "none"
+	
+	// Slack integration
+	SlackResponseURLPrefix = "https://hooks.slack.com/"
+	SlackMaxRequestAge     = 5 * time.Minute
+	SlackMaxBodyBytes      = 64 << 10
+	SlackCompareAction     = "compare"
+	SlackMaxCompareButtons = 5
+	
+	// Telegram and Discord bots
+	PlatformTelegram      = "telegram"
+	PlatformDiscord       = "discord"
+	DefaultTelegramAPIURL = "https://api.telegram.org"
+	DefaultDiscordAPIURL  = "https://discord.com/api/v10"
+	DefaultBotDailyAt     = "07:00"
+	BotMaxBodyBytes       = 64 << 10
+	
+	// MQTT publisher
+	DefaultMQTTClientID        = "wttr-app"
+	DefaultMQTTTopicPrefix     = "weather"
+	DefaultMQTTDiscoveryPrefix = "homeassistant"
+	DefaultMQTTInterval        = 10 * time.Minute
+	MQTTTimeout                = 10 * time.Second
+	MQTTDisconnectQuiesce      = 250 * time.Millisecond
+	
+	// Terminal output
+	TerminalIconWidth = 13
+	TerminalInfoWidth = 16
+	MaxFormatLength   = 256
+	
+	// PNG cards
+	CardDefaultWidth = 600
+	CardMinWidth     = 300
+	CardMaxWidth     = 1200
+	CardMaxScale     = 3
+	
+	// SVG widgets
+	WidgetFullWidth     = 360
+	WidgetFullHeight    = 200
+	WidgetCompactWidth  = 200
+	WidgetCompactHeight = 88
+	
+	DefaultImageCacheTTL = 10 * time.Minute
+	
+	// Embeddable page
+	EmbedMinRefresh = time.Minute
+	EmbedMaxRefresh = 24 * time.Hour
+	
+	// History recorder
+	DefaultHistoryInterval  = 30 * time.Minute
+	DefaultHistoryRetention = 365 * 24 * time.Hour
+	HistoryMaxRows          = 10000
+	HistoryPageDays         = 30
+	HistoryPageMaxDays      = 366
+	
+	// Exports
+	ExportBatchRows    = 1000
+	ExportRowGroupRows = 50000
+	
+	// Calendar feeds
+	CalendarRefreshInterval = time.Hour
+	CalendarAlarmLead       = 3 * time.Hour
+	CalendarRainAlarm       = 60
+	CalendarHotAlarmC       = 30
+	CalendarColdAlarmC      = -10
+	
+	// Atom feeds
+	DefaultFeedMaxLocations = 1000
+	DefaultFeedExpiry       = 30 * 24 * time.Hour
+	FeedMaxEntries          = 20
+	FeedTempChange          = 3
+	FeedRainChange          = 30
+	
+	// Forecast verification
+	ForecastProvider     = "wttr.in"
+	VerificationMinHours = 18
+	
 	// API configuration
 	WeatherAPIURL = "https://wttr.in/%s?format=j1"
 	APITimeout    = 10 * time.Second
@@ -20,4 +163,19 @@ const (
 	ErrFetchWeatherData  = "Unable to fetch weather data. Please check the location name and try again."
 	ErrInvalidWeatherData = "Invalid weather data received"
 	ErrTemplateExecution = "Error rendering template"
+	ErrRateLimited       = "Too many requests. Please wait a moment and try again."
+	ErrAPIKeyRequired    = "API key required"
+	ErrAPIKeyInvalid     = "Invalid or revoked API key"
+	ErrAdminUnauthorized = "Admin token required"
+	ErrWSUnknownMessage       = "unknown message type"
+	ErrWSInvalidMessage       = "message must be a JSON object"
+	ErrWSTooManySubscriptions = "too many subscriptions on this connection"
+	ErrWSNotSubscribed        = "not subscribed to this location"
+	ErrFormatTooLong     = "Format string is too long"
+	ErrCardRender        = "Unable to render weather card"
+	ErrHistoryDisabled   = "History recording is not enabled"
+	ErrHistoryQuery      = "Unable to query history"
+	ErrVerificationQuery = "Unable to compute forecast verification"
+	ErrCSRFToken         = "Invalid or missing form token. Please reload the page and try again."
+	ErrInvalidUnits      = "units must be metric or imperial"
 )
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// DigestRecipient is a person and the locations in their digest
+type DigestRecipient struct {
+	Email     string   `json:"email"`
+	Locations []string `json:"locations"`
+}
+
+// digestLocation is the forecast for one location in a digest
+type digestLocation struct {
+	Location string
+	Current  string
+	Days     []digestDay
+}
+
+// digestDay is a forecast day with its icon referenced by Content-ID
+type digestDay struct {
+	ForecastDay
+	IconCID string
+}
+
+// digestData is the data for the digest templates
+type digestData struct {
+	Date      string
+	Locations []digestLocation
+	Failed    []string
+}
+
+// digestMailer renders and sends the morning digest
+type digestMailer struct {
+	app  *App
+	cfg  DigestConfig
+	html *template.Template
+	text *texttemplate.Template
+	loc  *time.Location
+}
+
+func newDigestMailer(app *App, cfg DigestConfig) (*digestMailer, error) {
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("digest timezone: %w", err)
+	}
+	html, err := template.New("digest-html").Parse(digestHTMLTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("digest html template: %w", err)
+	}
+	text, err := texttemplate.New("digest-text").Parse(digestTextTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("digest text template: %w", err)
+	}
+	return &digestMailer{app: app, cfg: cfg, html: html, text: text, loc: loc}, nil
+}
+
+// nextRun returns the next send time at or after now
+func (m *digestMailer) nextRun(now time.Time) time.Time {
+	hour, minute := m.cfg.sendClock()
+	local := now.In(m.loc)
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, m.loc)
+	if !next.After(local) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// run sends the digest at the configured time every day
+func (m *digestMailer) run(ctx context.Context) {
+	for {
+		next := m.nextRun(time.Now())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		m.sendAll(ctx)
+	}
+}
+
+// sendAll builds and sends every recipient's digest. Each location is
+// fetched once even when several recipients follow it.
+func (m *digestMailer) sendAll(ctx context.Context) {
+	fetched := make(map[string]*WeatherData)
+	for _, r := range m.cfg.Recipients {
+		if ctx.Err() != nil {
+			return
+		}
+		msg, err := m.build(ctx, r, fetched)
+		if err != nil {
+			log.Printf("Digest: error building email for %s: %v", r.Email, err)
+			continue
+		}
+		if err := m.send(r.Email, msg); err != nil {
+			log.Printf("Digest: error sending to %s: %v", r.Email, err)
+			continue
+		}
+		log.Printf("Digest: sent to %s", r.Email)
+	}
+}
+
+// collect fetches the recipient's locations and keeps today and tomorrow
+func (m *digestMailer) collect(ctx context.Context, r DigestRecipient, fetched map[string]*WeatherData) digestData {
+	data := digestData{Date: time.Now().In(m.loc).Format("Monday 2 January")}
+
+	for _, location := range r.Locations {
+		key := locationKey(location)
+		weather, ok := fetched[key]
+		if !ok {
+			var err error
+			weather, err = m.app.fetchWeatherData(ctx, location)
+			if err != nil {
+				log.Printf("Digest: error fetching %q: %v", location, err)
+				data.Failed = append(data.Failed, location)
+				continue
+			}
+			fetched[key] = weather
+		}
+
+		page := m.app.processWeatherData(weather)
+		if !page.HasData {
+			data.Failed = append(data.Failed, location)
+			continue
+		}
+
+		dl := digestLocation{
+			Location: page.Location,
+			Current:  fmt.Sprintf("%s, %s", page.Temperature, page.Description),
+		}
+		for i, day := range page.Forecast {
+			if i >= DigestForecastDays {
+				break
+			}
+			dl.Days = append(dl.Days, digestDay{
+				ForecastDay: day,
+				IconCID:     weatherIconName(day.Description) + "@wttr-app",
+			})
+		}
+		data.Locations = append(data.Locations, dl)
+	}
+	return data
+}
+
+// build renders the digest as a multipart/related message: the HTML and
+// plain-text parts as alternatives, followed by the icons as inline
+// attachments referenced by Content-ID
+func (m *digestMailer) build(ctx context.Context, r DigestRecipient, fetched map[string]*WeatherData) ([]byte, error) {
+	data := m.collect(ctx, r, fetched)
+	if len(data.Locations) == 0 {
+		return nil, fmt.Errorf("no forecast available for %v", r.Locations)
+	}
+
+	var htmlBody, textBody bytes.Buffer
+	if err := m.html.Execute(&htmlBody, data); err != nil {
+		return nil, fmt.Errorf("render html: %w", err)
+	}
+	if err := m.text.Execute(&textBody, data); err != nil {
+		return nil, fmt.Errorf("render text: %w", err)
+	}
+
+	icons := make(map[string]bool)
+	for _, l := range data.Locations {
+		for _, d := range l.Days {
+			icons[strings.TrimSuffix(d.IconCID, "@wttr-app")] = true
+		}
+	}
+	names := make([]string, 0, len(icons))
+	for name := range icons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var body bytes.Buffer
+	related := multipart.NewWriter(&body)
+
+	var alt bytes.Buffer
+	altWriter := multipart.NewWriter(&alt)
+	if err := writeQuotedPart(altWriter, "text/plain; charset=utf-8", textBody.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := writeQuotedPart(altWriter, "text/html; charset=utf-8", htmlBody.Bytes()); err != nil {
+		return nil, err
+	}
+	altWriter.Close()
+
+	part, err := related.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"multipart/alternative; boundary=" + altWriter.Boundary()},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(alt.Bytes()); err != nil {
+		return nil, err
+	}
+
+	// Icons are attached as PNG since Gmail and Outlook do not show SVG
+	for _, name := range names {
+		img, err := iconPNG(name, DigestIconSize)
+		if err != nil {
+			return nil, fmt.Errorf("render icon %s: %w", name, err)
+		}
+		part, err := related.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"image/png"},
+			"Content-ID":                {"<" + name + "@wttr-app>"},
+			"Content-Disposition":       {"inline; filename=\"" + name + ".png\""},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64Lines(part, img); err != nil {
+			return nil, err
+		}
+	}
+	related.Close()
+
+	subject := fmt.Sprintf("Weather for %s", data.Date)
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", r.Email)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/related; boundary=%s; type=\"multipart/alternative\"\r\n", related.Boundary())
+	fmt.Fprintf(&msg, "\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// writeQuotedPart adds a quoted-printable part to w
+func writeQuotedPart(w *multipart.Writer, contentType string, content []byte) error {
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+	qp := quotedprintable.NewWriter(part)
+	if _, err := qp.Write(content); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// writeBase64Lines writes content base64 encoded in lines of 76 characters,
+// the limit for MIME bodies
+func writeBase64Lines(w io.Writer, content []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(content)
+	for len(encoded) > 0 {
+		n := min(len(encoded), 76)
+		if _, err := io.WriteString(w, encoded[:n]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[n:]
+	}
+	return nil
+}
+
+// send delivers msg through the configured SMTP server
+func (m *digestMailer) send(to string, msg []byte) error {
+	smtpCfg := m.cfg.SMTP
+	addr := net.JoinHostPort(smtpCfg.Host, fmt.Sprint(smtpCfg.Port))
+	tlsConfig := &tls.Config{ServerName: smtpCfg.Host}
+
+	var conn net.Conn
+	var err error
+	if smtpCfg.TLS == SMTPImplicitTLS {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: SMTPTimeout}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, SMTPTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(SMTPTimeout))
+
+	c, err := smtp.NewClient(conn, smtpCfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp handshake: %w", err)
+	}
+	defer c.Close()
+
+	if smtpCfg.TLS == SMTPStartTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("starttls: %w", err)
+		}
+	}
+	if smtpCfg.Username != "" {
+		auth := smtp.PlainAuth("", smtpCfg.Username, smtpCfg.Password, smtpCfg.Host)
+		if err := c.Auth(auth); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+
+	if err := c.Mail(m.cfg.From); err != nil {
+		return fmt.Errorf("smtp MAIL FROM: %w", err)
+	}
+	if err := c.Rcpt(to); err != nil {
+		return fmt.Errorf("smtp RCPT TO: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("smtp write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp end of data: %w", err)
+	}
+	return c.Quit()
+}
+
+// setupDigest schedules the morning digest when recipients are configured
+func (app *App) setupDigest() error {
+	cfg := app.config.Digest
+	if len(cfg.Recipients) == 0 {
+		return nil
+	}
+
+	// Recipients without their own list get the shared favourites
+	recipients := make([]DigestRecipient, len(cfg.Recipients))
+	for i, r := range cfg.Recipients {
+		if len(r.Locations) == 0 {
+			r.Locations = app.config.Favourites
+		}
+		recipients[i] = r
+	}
+	cfg.Recipients = recipients
+
+	m, err := newDigestMailer(app, cfg)
+	if err != nil {
+		return err
+	}
+	app.goBackground(m.run)
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpMessage is a message accepted by fakeSMTP
+type smtpMessage struct {
+	from, to string
+	data     []byte
+}
+
+// fakeSMTP accepts one plain SMTP session on a local port and reports the
+// message it receives
+func fakeSMTP(t *testing.T) (host string, port int, received <-chan smtpMessage) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	ch := make(chan smtpMessage, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+		var msg smtpMessage
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.TrimRight(line, "\r\n")
+			switch verb := strings.ToUpper(strings.SplitN(cmd, " ", 2)[0]); verb {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "MAIL":
+				msg.from = cmd
+				reply("250 OK")
+			case "RCPT":
+				msg.to = cmd
+				reply("250 OK")
+			case "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data bytes.Buffer
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(strings.TrimPrefix(l, "."))
+				}
+				msg.data = data.Bytes()
+				reply("250 OK")
+			case "QUIT":
+				reply("221 Bye")
+				ch <- msg
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, ch
+}
+
+func TestDigestSendsPNGIcons(t *testing.T) {
+	host, port, received := fakeSMTP(t)
+
+	cfg := DefaultConfig()
+	cfg.Digest.From = "weather@example.com"
+	cfg.Digest.SMTP = SMTPConfig{Host: host, Port: port, TLS: SMTPNoTLS}
+	cfg.Digest.Recipients = []DigestRecipient{{Email: "ola@example.com", Locations: []string{"Oslo"}}}
+	app := newTestApp(t, cfg)
+	fakeUpstream(t, app, serveWeather(testWeatherData("Oslo", 12)))
+
+	m, err := newDigestMailer(app, cfg.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.sendAll(app.ctx)
+
+	var got smtpMessage
+	select {
+	case got = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	if got.from != "MAIL FROM:<weather@example.com>" || !strings.HasPrefix(got.to, "RCPT TO:<ola@example.com>") {
+		t.Errorf("envelope = %q, %q", got.from, got.to)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(got.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/related" {
+		t.Fatalf("content type = %q, %v", msg.Header.Get("Content-Type"), err)
+	}
+
+	var html string
+	images := make(map[string]int)
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ct, ctParams, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch ct {
+		case "multipart/alternative":
+			alt := multipart.NewReader(part, ctParams["boundary"])
+			for {
+				p, err := alt.NextPart()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				// NextPart decodes quoted-printable bodies
+				body, _ := io.ReadAll(p)
+				if strings.HasPrefix(p.Header.Get("Content-Type"), "text/html") {
+					html = string(body)
+				}
+			}
+		case "image/png":
+			if enc := part.Header.Get("Content-Transfer-Encoding"); enc != "base64" {
+				t.Errorf("icon encoding = %q", enc)
+			}
+			raw, _ := io.ReadAll(part)
+			for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\r\n") {
+				if len(line) > 76 {
+					t.Errorf("base64 line of %d characters", len(line))
+				}
+			}
+			decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(raw), "\r\n", ""))
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := png.Decode(bytes.NewReader(decoded))
+			if err != nil {
+				t.Fatalf("icon is not a PNG: %v", err)
+			}
+			cid := strings.Trim(part.Header.Get("Content-ID"), "<>")
+			images[cid] = img.Bounds().Dx()
+		default:
+			t.Errorf("unexpected part %q", ct)
+		}
+	}
+
+	if !strings.Contains(html, "Oslo, Norway") {
+		t.Errorf("html part does not mention the location:\n%s", html)
+	}
+	if len(images) == 0 {
+		t.Fatal("no PNG icons attached")
+	}
+	for cid, width := range images {
+		if !strings.Contains(html, `src="cid:`+cid+`"`) {
+			t.Errorf("html does not reference %s", cid)
+		}
+		if width != DigestIconSize {
+			t.Errorf("%s is %d pixels wide, want %d", cid, width, DigestIconSize)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Discord interaction and response types
+const (
+	discordInteractionPing    = 1
+	discordInteractionCommand = 2
+
+	discordResponsePong     = 1
+	discordResponseDeferred = 5
+)
+
+// discordInteraction is the subset of an interaction that is used
+type discordInteraction struct {
+	Type      int    `json:"type"`
+	Token     string `json:"token"`
+	ChannelID string `json:"channel_id"`
+	Data      struct {
+		Name    string `json:"name"`
+		Options []struct {
+			Name  string      `json:"name"`
+			Value interface{} `json:"value"`
+		} `json:"options"`
+	} `json:"data"`
+}
+
+// discordAPI is the part of the Discord HTTP API the bot uses
+type discordAPI interface {
+	chatMessenger
+	EditOriginalResponse(ctx context.Context, interactionToken, text string) error
+}
+
+// discordClient calls the Discord HTTP API
+type discordClient struct {
+	baseURL  string
+	botToken string
+	appID    string
+	client   *http.Client
+}
+
+func newDiscordClient(cfg DiscordConfig, client *http.Client) *discordClient {
+	return &discordClient{
+		baseURL:  strings.TrimSuffix(cfg.APIURL, "/"),
+		botToken: cfg.BotToken,
+		appID:    cfg.ApplicationID,
+		client:   client,
+	}
+}
+
+// do sends a JSON request to the Discord API
+func (d *discordClient) do(ctx context.Context, method, path string, payload interface{}, auth bool) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, d.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if auth {
+		req.Header.Set("Authorization", "Bot "+d.botToken)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned status %d", path, resp.StatusCode)
+	}
+	return nil
+}
+
+// SendMessage implements chatMessenger by posting to a channel
+func (d *discordClient) SendMessage(ctx context.Context, channelID, text string) error {
+	return d.do(ctx, http.MethodPost, "/channels/"+channelID+"/messages",
+		map[string]string{"content": text}, true)
+}
+
+// EditOriginalResponse fills in a deferred interaction response
+func (d *discordClient) EditOriginalResponse(ctx context.Context, interactionToken, text string) error {
+	return d.do(ctx, http.MethodPatch, "/webhooks/"+d.appID+"/"+interactionToken+"/messages/@original",
+		map[string]string{"content": text}, false)
+}
+
+// verifyDiscordSignature checks Discord's Ed25519 signature over
+// timestamp + body
+func verifyDiscordSignature(publicKey ed25519.PublicKey, r *http.Request, body []byte) bool {
+	sig, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	msg := append([]byte(r.Header.Get("X-Signature-Timestamp")), body...)
+	return ed25519.Verify(publicKey, msg, sig)
+}
+
+// discordInteractionHandler answers Discord slash-command interactions.
+// Commands are deferred and the reply filled in once the forecast arrives.
+func (app *App) discordInteractionHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, BotMaxBodyBytes))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	if !verifyDiscordSignature(app.bots.discordPublicKey, r, body) {
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return
+	}
+
+	var interaction discordInteraction
+	if err := json.Unmarshal(body, &interaction); err != nil {
+		http.Error(w, "invalid interaction", http.StatusBadRequest)
+		return
+	}
+
+	switch interaction.Type {
+	case discordInteractionPing:
+		writeJSON(w, http.StatusOK, map[string]int{"type": discordResponsePong})
+		return
+	case discordInteractionCommand:
+	default:
+		http.Error(w, "unsupported interaction type", http.StatusBadRequest)
+		return
+	}
+
+	var args []string
+	for _, opt := range interaction.Data.Options {
+		args = append(args, fmt.Sprint(opt.Value))
+	}
+
+	api := app.bots.discord
+	app.goBackground(func(ctx context.Context) {
+		reply := app.bots.handleCommand(ctx, PlatformDiscord, interaction.ChannelID,
+			interaction.Data.Name, strings.Join(args, " "))
+		if err := api.EditOriginalResponse(ctx, interaction.Token, reply); err != nil {
+			log.Printf("Discord: error completing interaction: %v", err)
+		}
+	})
+	writeJSON(w, http.StatusOK, map[string]int{"type": discordResponseDeferred})
+}
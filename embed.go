@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// embedOptions are the query parameters of an embedded page
+type embedOptions struct {
+	Imperial bool
+	Days     int
+	Theme    string
+	Compact  bool
+	Refresh  time.Duration
+}
+
+// parseEmbedOptions reads ?units=, ?days=, ?theme=, ?compact= and ?refresh=
+func parseEmbedOptions(r *http.Request) (embedOptions, error) {
+	q := r.URL.Query()
+	opts := embedOptions{Days: MaxForecastDays, Theme: "light"}
+
+	switch q.Get("units") {
+	case "", "metric":
+	case "imperial":
+		opts.Imperial = true
+	default:
+		return opts, fmt.Errorf("units must be metric or imperial")
+	}
+	if v := q.Get("days"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 || days > MaxForecastDays {
+			return opts, fmt.Errorf("days must be between 0 and %d", MaxForecastDays)
+		}
+		opts.Days = days
+	}
+	switch v := q.Get("theme"); v {
+	case "":
+	case "light", "dark":
+		opts.Theme = v
+	default:
+		return opts, fmt.Errorf("theme must be light or dark")
+	}
+	if v := q.Get("compact"); v != "" {
+		compact, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("compact must be true or false")
+		}
+		opts.Compact = compact
+	}
+	if v := q.Get("refresh"); v != "" {
+		secs, err := strconv.Atoi(v)
+		refresh := time.Duration(secs) * time.Second
+		if err != nil || (secs != 0 && (refresh < EmbedMinRefresh || refresh > EmbedMaxRefresh)) {
+			return opts, fmt.Errorf("refresh must be 0 or between %d and %d seconds",
+				int(EmbedMinRefresh.Seconds()), int(EmbedMaxRefresh.Seconds()))
+		}
+		opts.Refresh = refresh
+	}
+	return opts, nil
+}
+
+// EmbedPageData is the data for the embeddable page
+type EmbedPageData struct {
+	PageData
+	Theme          string
+	Compact        bool
+	RefreshSeconds int
+}
+
+// embedHandler serves GET /embed/{location}, a page without the search form
+// for iframes on the sites in embed.frame_ancestors
+func (app *App) embedHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseEmbedOptions(r)
+	if err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	location := strings.TrimSpace(mux.Vars(r)["location"])
+	if location == "" {
+		writeText(w, http.StatusBadRequest, ErrEmptyLocation)
+		return
+	}
+
+	weatherData, err := app.fetchWeatherData(r.Context(), location)
+	if errors.Is(err, errUpstreamRateLimited) {
+		app.writeRateLimited(w, r, upstreamRetryAfter(err))
+		return
+	}
+
+	var page PageData
+	if err != nil {
+		log.Printf("Error fetching weather data for %q: %v", location, err)
+		page = PageData{Error: ErrFetchWeatherData}
+	} else {
+		page = app.processWeatherData(weatherData)
+		if page.HasData {
+			applyEmbedUnits(&page, weatherData, opts.Imperial)
+		}
+	}
+	if len(page.Forecast) > opts.Days {
+		page.Forecast = page.Forecast[:opts.Days]
+	}
+	page.Query = location
+	page.Nonce = cspNonce(r)
+
+	data := EmbedPageData{
+		PageData:       page,
+		Theme:          opts.Theme,
+		Compact:        opts.Compact,
+		RefreshSeconds: int(opts.Refresh.Seconds()),
+	}
+
+	// Replace the global framing ban with the allowlist. X-Frame-Options
+	// cannot name several origins, so it is dropped in favour of the CSP.
+	h := w.Header()
+	h.Set("Content-Security-Policy", contentSecurityPolicy(data.Nonce, frameAncestors(app.config.Embed.FrameAncestors)))
+	h.Del("X-Frame-Options")
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	if err := app.tmpl.ExecuteTemplate(w, "embed", data); err != nil {
+		log.Printf("Error executing embed template: %v", err)
+		http.Error(w, ErrTemplateExecution, http.StatusInternalServerError)
+	}
+}
+
+// applyEmbedUnits replaces the page's dual-unit values with single units
+func applyEmbedUnits(page *PageData, data *WeatherData, imperial bool) {
+	current := data.CurrentCondition[0]
+	if !imperial {
+		page.Temperature = current.TempC + "°C"
+		page.FeelsLike = current.FeelsLikeC + "°C"
+		return
+	}
+
+	page.Temperature = current.TempF + "°F"
+	page.FeelsLike = current.FeelsLikeF + "°F"
+	page.Wind = fmt.Sprintf("%d mph %s", kmToMiles(current.WindspeedKmph), current.Winddir16Point)
+	page.Visibility = fmt.Sprintf("%d mi", kmToMiles(current.Visibility))
+	for i := range page.Forecast {
+		day := data.Weather[i]
+		page.Forecast[i].Temperature = fmt.Sprintf("%d° / %d°", atoi(day.MaxtempF), atoi(day.MintempF))
+	}
+}
+
+// kmToMiles converts a whole number of kilometres (or km/h)
+func kmToMiles(km string) int {
+	return int(math.Round(float64(atoi(km)) * 0.621371))
+}
+
+// frameAncestors builds the CSP frame-ancestors source list. The page can
+// always frame itself.
+func frameAncestors(origins []string) string {
+	return strings.Join(append([]string{"'self'"}, origins...), " ")
+}
+
+// validateFrameAncestor accepts an origin such as https://intranet.example.com
+// or https://*.example.com
+func validateFrameAncestor(origin string) error {
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("%q is not an http(s) origin", origin)
+	}
+	if strings.ContainsAny(origin, " ;,'\"") {
+		return fmt.Errorf("%q contains characters not allowed in a CSP source", origin)
+	}
+	return nil
+}
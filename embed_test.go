@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestEmbedHandler(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Embed.FrameAncestors = []string{"https://intranet.example.com"}
+	app := newTestApp(t, cfg)
+	fakeUpstream(t, app, serveWeather(testWeatherData("Oslo", 12)))
+	handler := app.routes()
+
+	rec := getRoute(handler, "/embed/Oslo?units=imperial&days=1&theme=dark", browserAccept)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("content type = %q", ct)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "°F") || strings.Contains(body, "°C") {
+		t.Error("imperial embed shows metric values")
+	}
+	if strings.Contains(body, `action="/weather"`) {
+		t.Error("embed includes the search form")
+	}
+
+	// Only the configured origin and the app itself may frame the page
+	csp := rec.Header().Get("Content-Security-Policy")
+	if !strings.Contains(csp, "frame-ancestors 'self' https://intranet.example.com") {
+		t.Errorf("CSP = %q, want the allowed origin", csp)
+	}
+	if strings.Contains(csp, "https://attacker.example") || strings.Contains(csp, "frame-ancestors 'none'") {
+		t.Errorf("CSP = %q", csp)
+	}
+	if xfo := rec.Header().Values("X-Frame-Options"); len(xfo) != 0 {
+		t.Errorf("X-Frame-Options = %q, want none", xfo)
+	}
+
+	// Other pages still refuse to be framed
+	page := getRoute(handler, "/Oslo", browserAccept)
+	if xfo := page.Header().Get("X-Frame-Options"); xfo != "DENY" {
+		t.Errorf("page X-Frame-Options = %q", xfo)
+	}
+	if csp := page.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "frame-ancestors 'none'") {
+		t.Errorf("page CSP = %q", csp)
+	}
+
+	for _, target := range []string{
+		"/embed/Oslo?units=kelvin",
+		"/embed/Oslo?days=9",
+		"/embed/Oslo?refresh=5",
+	} {
+		if rec := getRoute(handler, target, browserAccept); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: status %d, want 400", target, rec.Code)
+		}
+	}
+}
+
+func TestValidateFrameAncestor(t *testing.T) {
+	for _, origin := range []string{"https://intranet.example.com", "https://*.example.com", "http://localhost:8080"} {
+		if err := validateFrameAncestor(origin); err != nil {
+			t.Errorf("%q: %v", origin, err)
+		}
+	}
+	for _, origin := range []string{
+		"intranet.example.com",
+		"ftp://example.com",
+		"https://example.com/path",
+		"https://example.com 'unsafe-inline'",
+		"https://example.com;script-src",
+		"*",
+	} {
+		if err := validateFrameAncestor(origin); err == nil {
+			t.Errorf("%q accepted", origin)
+		}
+	}
+}
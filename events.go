@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// eventsHandler streams Server-Sent Events for one location whenever the
+// poller sees changed conditions
+func (app *App) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	location := strings.TrimSpace(mux.Vars(r)["location"])
+	if location == "" {
+		http.Error(w, ErrEmptyLocation, http.StatusBadRequest)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	sub := app.poller.subscribe(location)
+	defer app.poller.unsubscribe(sub)
+
+	heartbeat := time.NewTicker(SSEHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	// write extends the server's write deadline for each event so the
+	// stream can outlive WriteTimeout while stuck clients still time out
+	write := func(format string, args ...interface{}) bool {
+		if app.config.Server.WriteTimeout.Duration > 0 {
+			rc.SetWriteDeadline(time.Now().Add(app.config.Server.WriteTimeout.Duration))
+		}
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	if !write("retry: %d\n\n", SSERetry.Milliseconds()) {
+		return
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if !write(": keep-alive\n\n") {
+				return
+			}
+		case update, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			payload, err := json.Marshal(update)
+			if err != nil {
+				log.Printf("Error encoding SSE update: %v", err)
+				continue
+			}
+			if !write("event: weather\ndata: %s\n\n", payload) {
+				return
+			}
+		}
+	}
+}
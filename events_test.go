@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventsStreamWithoutWriteTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Server.WriteTimeout = Duration{}
+	app := newTestApp(t, cfg)
+	fakeUpstream(t, app, serveWeather(testWeatherData("Oslo", 12)))
+
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Config = newHTTPServer(cfg.Server, app.routes())
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events/weather/Oslo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("stream ended before the first update")
+			}
+			if data, found := strings.CutPrefix(line, "data: "); found {
+				if !strings.Contains(data, `"location":"Oslo, Norway"`) {
+					t.Errorf("unexpected update %s", data)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("no update received")
+		}
+	}
+}
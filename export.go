@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Exports of the current forecast and recorded history as CSV, JSON Lines
+// or Parquet. Each dataset is a row struct whose json and parquet tags name
+// the columns and whose unit tags describe them, so the three formats and
+// /export/schema.json cannot drift apart.
+
+// ForecastExportDay is one day of the forecast
+type ForecastExportDay struct {
+	Date      string  `json:"date" parquet:"date" unit:"local date"`
+	LeadDays  int64   `json:"lead_days" parquet:"lead_days" unit:"days"`
+	MaxTempC  float64 `json:"max_temp_c" parquet:"max_temp_c" unit:"°C"`
+	MinTempC  float64 `json:"min_temp_c" parquet:"min_temp_c" unit:"°C"`
+	MaxTempF  float64 `json:"max_temp_f" parquet:"max_temp_f" unit:"°F"`
+	MinTempF  float64 `json:"min_temp_f" parquet:"min_temp_f" unit:"°F"`
+	Sunrise   string  `json:"sunrise" parquet:"sunrise" unit:"local time"`
+	Sunset    string  `json:"sunset" parquet:"sunset" unit:"local time"`
+	MoonPhase string  `json:"moon_phase" parquet:"moon_phase"`
+	Condition string  `json:"condition" parquet:"condition"`
+}
+
+// ForecastExportHour is one hourly step of the forecast
+type ForecastExportHour struct {
+	Date         string  `json:"date" parquet:"date" unit:"local date"`
+	LeadDays     int64   `json:"lead_days" parquet:"lead_days" unit:"days"`
+	Time         string  `json:"time" parquet:"time" unit:"local time"`
+	TempC        float64 `json:"temp_c" parquet:"temp_c" unit:"°C"`
+	TempF        float64 `json:"temp_f" parquet:"temp_f" unit:"°F"`
+	FeelsLikeC   float64 `json:"feels_like_c" parquet:"feels_like_c" unit:"°C"`
+	FeelsLikeF   float64 `json:"feels_like_f" parquet:"feels_like_f" unit:"°F"`
+	ChanceOfRain float64 `json:"chance_of_rain" parquet:"chance_of_rain" unit:"%"`
+	ChanceOfSnow float64 `json:"chance_of_snow" parquet:"chance_of_snow" unit:"%"`
+	WindKmph     float64 `json:"windspeed_kmph" parquet:"windspeed_kmph" unit:"km/h"`
+	PrecipMM     float64 `json:"precip_mm" parquet:"precip_mm" unit:"mm"`
+	Condition    string  `json:"condition" parquet:"condition"`
+}
+
+// exportDatasets are the row types by ?dataset= name
+var exportDatasets = map[string]reflect.Type{
+	"forecast": reflect.TypeOf(ForecastExportDay{}),
+	"hourly":   reflect.TypeOf(ForecastExportHour{}),
+	"history":  reflect.TypeOf(Observation{}),
+}
+
+// exportContentTypes are the media types by file extension
+var exportContentTypes = map[string]string{
+	"csv":     "text/csv; charset=utf-8",
+	"jsonl":   "application/jsonl; charset=utf-8",
+	"parquet": "application/vnd.apache.parquet",
+}
+
+// ExportColumn describes one column of a dataset
+type ExportColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Unit string `json:"unit,omitempty"`
+}
+
+// exportColumns lists the columns of a row type in order
+func exportColumns(t reflect.Type) []ExportColumn {
+	columns := make([]ExportColumn, t.NumField())
+	for i := range columns {
+		f := t.Field(i)
+		typ := "string"
+		switch f.Type.Kind() {
+		case reflect.Float64:
+			typ = "number"
+		case reflect.Int64:
+			typ = "integer"
+		case reflect.Struct:
+			typ = "timestamp"
+		}
+		columns[i] = ExportColumn{Name: f.Tag.Get("json"), Type: typ, Unit: f.Tag.Get("unit")}
+	}
+	return columns
+}
+
+// exportUnits maps column names to units, for Parquet file metadata
+func exportUnits(t reflect.Type) string {
+	units := map[string]string{}
+	for _, c := range exportColumns(t) {
+		if c.Unit != "" {
+			units[c.Name] = c.Unit
+		}
+	}
+	b, _ := json.Marshal(units)
+	return string(b)
+}
+
+// csvRecord formats the fields of row in column order
+func csvRecord(row reflect.Value) []string {
+	record := make([]string, row.NumField())
+	for i := range record {
+		switch v := row.Field(i).Interface().(type) {
+		case string:
+			record[i] = v
+		case float64:
+			record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case int64:
+			record[i] = strconv.FormatInt(v, 10)
+		case time.Time:
+			record[i] = v.UTC().Format(time.RFC3339)
+		}
+	}
+	return record
+}
+
+// exportSchemaHandler serves GET /export/schema.json
+func (app *App) exportSchemaHandler(w http.ResponseWriter, r *http.Request) {
+	schema := map[string][]ExportColumn{}
+	for name, t := range exportDatasets {
+		schema[name] = exportColumns(t)
+	}
+	writeJSON(w, http.StatusOK, schema)
+}
+
+// exportHandler serves GET /export/{location}.{format}
+func (app *App) exportHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	location := strings.TrimSpace(vars["location"])
+	format := vars["format"]
+	dataset := r.URL.Query().Get("dataset")
+	if dataset == "" {
+		dataset = "forecast"
+	}
+	if _, ok := exportDatasets[dataset]; !ok {
+		writeText(w, http.StatusBadRequest, "dataset must be forecast, hourly or history")
+		return
+	}
+	if _, ok := exportContentTypes[format]; !ok {
+		writeText(w, http.StatusBadRequest, "format must be csv, jsonl or parquet")
+		return
+	}
+
+	var err error
+	switch dataset {
+	case "forecast", "hourly":
+		weatherData, ok := app.fetchForText(w, r, location)
+		if !ok {
+			return
+		}
+		days := forecastDays(weatherData.Weather)
+		if dataset == "forecast" {
+			err = writeExport(app, w, format, location, dataset, forecastExportDays(days))
+		} else {
+			err = writeExport(app, w, format, location, dataset, forecastExportHours(days))
+		}
+
+	case "history":
+		if app.history == nil {
+			writeText(w, http.StatusNotFound, ErrHistoryDisabled)
+			return
+		}
+		if location == "" {
+			writeText(w, http.StatusBadRequest, ErrEmptyLocation)
+			return
+		}
+		from, to, rangeErr := historyRange(r, time.Now(), 24*time.Hour)
+		if rangeErr != nil {
+			writeText(w, http.StatusBadRequest, rangeErr.Error())
+			return
+		}
+		err = writeExport(app, w, format, location, dataset, func(emit func(Observation) error) error {
+			return app.history.eachObservation(r.Context(), location, from, to, emit)
+		})
+	}
+
+	// The status line has gone out, so a failure can only be logged
+	if err != nil {
+		log.Printf("Error exporting %s for %q: %v", dataset, location, err)
+	}
+}
+
+// forecastExportDays returns a producer of the daily forecast rows
+func forecastExportDays(days []Weather) func(func(ForecastExportDay) error) error {
+	return func(emit func(ForecastExportDay) error) error {
+		for i, day := range days {
+			row := ForecastExportDay{
+				Date:      day.Date,
+				LeadDays:  int64(i),
+				MaxTempC:  atof(day.MaxtempC),
+				MinTempC:  atof(day.MintempC),
+				MaxTempF:  atof(day.MaxtempF),
+				MinTempF:  atof(day.MintempF),
+				Condition: middayDescription(day),
+			}
+			if len(day.Astronomy) > 0 {
+				row.Sunrise = day.Astronomy[0].Sunrise
+				row.Sunset = day.Astronomy[0].Sunset
+				row.MoonPhase = day.Astronomy[0].MoonPhase
+			}
+			if err := emit(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// forecastExportHours returns a producer of the hourly forecast rows
+func forecastExportHours(days []Weather) func(func(ForecastExportHour) error) error {
+	return func(emit func(ForecastExportHour) error) error {
+		for i, day := range days {
+			for _, h := range day.Hourly {
+				hhmm := atoi(h.Time)
+				row := ForecastExportHour{
+					Date:         day.Date,
+					LeadDays:     int64(i),
+					Time:         fmt.Sprintf("%02d:%02d", hhmm/100, hhmm%100),
+					TempC:        atof(h.TempC),
+					TempF:        atof(h.TempF),
+					FeelsLikeC:   atof(h.FeelsLikeC),
+					FeelsLikeF:   atof(h.FeelsLikeF),
+					ChanceOfRain: atof(h.ChanceOfRain),
+					ChanceOfSnow: atof(h.ChanceOfSnow),
+					WindKmph:     atof(h.WindspeedKmph),
+					PrecipMM:     atof(h.PrecipMM),
+				}
+				if len(h.WeatherDesc) > 0 {
+					row.Condition = h.WeatherDesc[0].Value
+				}
+				if err := emit(row); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// writeExport streams the rows produced by each in format. Every
+// ExportBatchRows rows it extends the server's write deadline, so long
+// exports outlive WriteTimeout while stuck clients still time out.
+func writeExport[T any](app *App, w http.ResponseWriter, format, location, dataset string, each func(emit func(T) error) error) error {
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		return fmt.Errorf("unknown export format %q", format)
+	}
+
+	rc := http.NewResponseController(w)
+	n := 0
+	extend := func() {
+		if n%ExportBatchRows == 0 && app.config.Server.WriteTimeout.Duration > 0 {
+			rc.SetWriteDeadline(time.Now().Add(app.config.Server.WriteTimeout.Duration))
+		}
+		n++
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", locationKey(location), dataset, format)
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", strings.ReplaceAll(filename, " ", "-")))
+	h.Set("Link", `</export/schema.json>; rel="describedby"`)
+	h.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	rowType := reflect.TypeOf((*T)(nil)).Elem()
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		header := make([]string, 0, rowType.NumField())
+		for _, c := range exportColumns(rowType) {
+			header = append(header, c.Name)
+		}
+		cw.Write(header)
+		err := each(func(row T) error {
+			extend()
+			return cw.Write(csvRecord(reflect.ValueOf(row)))
+		})
+		cw.Flush()
+		if err != nil {
+			return err
+		}
+		return cw.Error()
+
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		return each(func(row T) error {
+			extend()
+			return enc.Encode(row)
+		})
+
+	case "parquet":
+		pw := parquet.NewGenericWriter[T](w,
+			parquet.KeyValueMetadata("units", exportUnits(rowType)),
+			parquet.Compression(&parquet.Snappy),
+			parquet.MaxRowsPerRowGroup(ExportRowGroupRows))
+		if err := each(func(row T) error {
+			extend()
+			_, err := pw.Write([]T{row})
+			return err
+		}); err != nil {
+			return err
+		}
+		return pw.Close()
+	}
+	return nil
+}
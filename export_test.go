@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// collectRows gathers the rows a producer emits
+func collectRows[T any](t *testing.T, each func(func(T) error) error) []T {
+	t.Helper()
+	var rows []T
+	if err := each(func(row T) error {
+		rows = append(rows, row)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
+
+// decodeCSV reads an export's CSV back into rows, checking the header
+// against the schema
+func decodeCSV[T any](t *testing.T, body []byte) []T {
+	t.Helper()
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatalf("CSV does not parse: %v", err)
+	}
+	rowType := reflect.TypeOf((*T)(nil)).Elem()
+	columns := exportColumns(rowType)
+	for i, c := range columns {
+		if records[0][i] != c.Name {
+			t.Fatalf("CSV header %v does not match the schema", records[0])
+		}
+	}
+
+	// Parse each record as JSON so the row type's own decoding applies
+	rows := make([]T, 0, len(records)-1)
+	for _, record := range records[1:] {
+		fields := map[string]interface{}{}
+		for i, c := range columns {
+			switch c.Type {
+			case "number", "integer":
+				fields[c.Name] = json.Number(record[i])
+			default:
+				fields[c.Name] = record[i]
+			}
+		}
+		b, _ := json.Marshal(fields)
+		var row T
+		if err := json.Unmarshal(b, &row); err != nil {
+			t.Fatalf("CSV record %v: %v", record, err)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// decodeJSONL reads an export's JSON Lines back into rows
+func decodeJSONL[T any](t *testing.T, body []byte) []T {
+	t.Helper()
+	var rows []T
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		var row T
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// decodeParquet reads an export's Parquet file back into rows and checks
+// its units metadata
+func decodeParquet[T any](t *testing.T, body []byte) []T {
+	t.Helper()
+	r := bytes.NewReader(body)
+	f, err := parquet.OpenFile(r, int64(len(body)))
+	if err != nil {
+		t.Fatalf("Parquet does not open: %v", err)
+	}
+	if units, _ := f.Lookup("units"); units != exportUnits(reflect.TypeOf((*T)(nil)).Elem()) {
+		t.Errorf("units metadata = %q", units)
+	}
+	rows, err := parquet.Read[T](r, int64(len(body)))
+	if err != nil {
+		t.Fatalf("Parquet rows do not read: %v", err)
+	}
+	return rows
+}
+
+// roundTrip exports dataset for location in every format and checks each
+// decodes back to want
+func roundTrip[T any](t *testing.T, handler http.Handler, location, query string, want []T) {
+	t.Helper()
+	for format, decode := range map[string]func(*testing.T, []byte) []T{
+		"csv":     decodeCSV[T],
+		"jsonl":   decodeJSONL[T],
+		"parquet": decodeParquet[T],
+	} {
+		target := "/export/" + location + "." + format + query
+		rec := getRoute(handler, target, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d: %s", target, rec.Code, rec.Body)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != exportContentTypes[format] {
+			t.Errorf("GET %s: content type %q", target, ct)
+		}
+		if link := rec.Header().Get("Link"); link != `</export/schema.json>; rel="describedby"` {
+			t.Errorf("GET %s: link %q", target, link)
+		}
+		got := decode(t, rec.Body.Bytes())
+		if len(got) != len(want) {
+			t.Fatalf("GET %s: %d rows, want %d", target, len(got), len(want))
+		}
+		for i := range want {
+			if !reflect.DeepEqual(got[i], want[i]) {
+				t.Errorf("GET %s: row %d = %+v, want %+v", target, i, got[i], want[i])
+				break
+			}
+		}
+	}
+}
+
+func TestExportForecastRoundTrip(t *testing.T) {
+	data := testWeatherData("Oslo", 12)
+	app := newTestApp(t, DefaultConfig())
+	fakeUpstream(t, app, serveWeather(data))
+	handler := app.routes()
+
+	days := forecastDays(data.Weather)
+	roundTrip(t, handler, "Oslo", "", collectRows(t, forecastExportDays(days)))
+	roundTrip(t, handler, "Oslo", "?dataset=hourly", collectRows(t, forecastExportHours(days)))
+}
+
+func TestExportHistoryRoundTrip(t *testing.T) {
+	app := newTestApp(t, DefaultConfig())
+	app.history = openTestHistory(t)
+	handler := app.routes()
+
+	// More than two batches, so the export streams across batch boundaries
+	start := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	n := 2*ExportBatchRows + 10
+	for i := 0; i < n; i++ {
+		recordLocal(t, app.history, "Oslo", start.Add(time.Duration(i)*time.Minute), 2*time.Hour, i%30)
+	}
+	query := "?dataset=history&from=" + start.Format(time.RFC3339) + "&to=" + start.Add(time.Duration(n)*time.Minute).Format(time.RFC3339)
+
+	var want []Observation
+	if err := app.history.eachObservation(context.Background(), "Oslo", start, start.Add(time.Duration(n)*time.Minute), func(o Observation) error {
+		want = append(want, o)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != n {
+		t.Fatalf("recorded %d observations, want %d", len(want), n)
+	}
+	// Times go out in UTC, to the second
+	for i := range want {
+		want[i].Time = want[i].Time.UTC()
+	}
+	roundTrip(t, handler, "Oslo", query, want)
+}
+
+func TestExportErrors(t *testing.T) {
+	app := newTestApp(t, DefaultConfig())
+	fakeUpstream(t, app, serveWeather(testWeatherData("Oslo", 12)))
+	handler := app.routes()
+
+	for target, want := range map[string]int{
+		"/export/Oslo.xml":                     http.StatusNotFound,
+		"/export/Oslo.csv?dataset=radar":       http.StatusBadRequest,
+		"/export/Oslo.parquet?dataset=history": http.StatusNotFound,
+	} {
+		if rec := getRoute(handler, target, ""); rec.Code != want {
+			t.Errorf("GET %s: status %d, want %d", target, rec.Code, want)
+		}
+	}
+
+	// Called directly, an unknown format fails before anything is written
+	rec := httptest.NewRecorder()
+	err := writeExport(app, rec, "xml", "Oslo", "forecast", forecastExportDays(nil))
+	if err == nil || !strings.Contains(err.Error(), "unknown export format") {
+		t.Errorf("writeExport = %v", err)
+	}
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 || rec.Header().Get("Content-Type") != "" {
+		t.Errorf("unknown format wrote a %d response with %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Atom (RFC 4287) feeds of forecast changes and fired alerts. The forecast
+// is compared with the last one seen whenever the feed is requested, and an
+// entry is added only when it changed materially, so feed readers polling
+// every few minutes do not flood subscribers.
+
+// feedDay is the part of a forecast day that entries are compared on
+type feedDay struct {
+	Date      string `json:"date"`
+	Day       string `json:"day"`
+	Condition string `json:"condition"`
+	MaxC      int    `json:"max_c"`
+	MinC      int    `json:"min_c"`
+	Rain      int    `json:"rain"`
+}
+
+// FeedEntry is a published forecast update
+type FeedEntry struct {
+	ID      string    `json:"id"`
+	Title   string    `json:"title"`
+	Summary string    `json:"summary"`
+	Updated time.Time `json:"updated"`
+}
+
+// feedLocation is the state of one location's feed
+type feedLocation struct {
+	Name      string      `json:"name"`
+	Days      []feedDay   `json:"days"`
+	Entries   []FeedEntry `json:"entries"`
+	Requested time.Time   `json:"requested"`
+}
+
+// feedStore remembers the last forecast and recent entries per location.
+// Anyone can request a feed for any location, so the store holds at most
+// maxLocations and drops those not requested within expiry.
+type feedStore struct {
+	mu           sync.Mutex
+	stateFile    string
+	maxLocations int
+	expiry       time.Duration
+	locations    map[string]*feedLocation
+
+	// saveMu serialises writes of the state file
+	saveMu sync.Mutex
+}
+
+func newFeedStore(cfg FeedsConfig) *feedStore {
+	return &feedStore{
+		stateFile:    cfg.StateFile,
+		maxLocations: cfg.MaxLocations,
+		expiry:       cfg.Expiry.Duration,
+		locations:    make(map[string]*feedLocation),
+	}
+}
+
+// setupFeeds creates the feed store, restoring it from feeds.state_file
+func (app *App) setupFeeds() error {
+	app.feeds = newFeedStore(app.config.Feeds)
+	return app.feeds.load(time.Now())
+}
+
+// load restores the feed state, if a state file is configured and exists.
+// Feeds saved without a request time count as requested now.
+func (s *feedStore) load(now time.Time) error {
+	if s.stateFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(s.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read feed state: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := json.Unmarshal(b, &s.locations); err != nil {
+		return fmt.Errorf("failed to parse feed state: %w", err)
+	}
+	for _, state := range s.locations {
+		if state.Requested.IsZero() {
+			state.Requested = now.UTC()
+		}
+	}
+	return nil
+}
+
+// save writes the feed state. The state is encoded under s.mu but written
+// without it, so requests are not held up by the disk.
+func (s *feedStore) save() error {
+	if s.stateFile == "" {
+		return nil
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	b, err := json.MarshalIndent(s.locations, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode feed state: %w", err)
+	}
+	tmp := s.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write feed state: %w", err)
+	}
+	return os.Rename(tmp, s.stateFile)
+}
+
+// feedDays reduces processed forecast days to what entries compare
+func feedDays(page PageData, days []Weather) []feedDay {
+	out := make([]feedDay, 0, len(page.Forecast))
+	for i, f := range page.Forecast {
+		rain, _ := maxHourly(days[i].Hourly, func(h Hourly) string { return h.ChanceOfRain })
+		out = append(out, feedDay{
+			Date:      days[i].Date,
+			Day:       f.Day,
+			Condition: f.Description,
+			MaxC:      atoi(days[i].MaxtempC),
+			MinC:      atoi(days[i].MintempC),
+			Rain:      int(rain),
+		})
+	}
+	return out
+}
+
+// forecastChanges describes the material differences between the days
+// both forecasts cover. New days appearing as the forecast rolls forward
+// are not a change on their own.
+func forecastChanges(old, cur []feedDay) []string {
+	previous := make(map[string]feedDay, len(old))
+	for _, d := range old {
+		previous[d.Date] = d
+	}
+
+	var changes []string
+	for _, d := range cur {
+		p, ok := previous[d.Date]
+		if !ok {
+			continue
+		}
+		if weatherIconName(p.Condition) != weatherIconName(d.Condition) {
+			changes = append(changes, fmt.Sprintf("%s: %s → %s", d.Day, p.Condition, d.Condition))
+		}
+		if abs(d.MaxC-p.MaxC) >= FeedTempChange {
+			changes = append(changes, fmt.Sprintf("%s: high %d°C → %d°C", d.Day, p.MaxC, d.MaxC))
+		}
+		if abs(d.MinC-p.MinC) >= FeedTempChange {
+			changes = append(changes, fmt.Sprintf("%s: low %d°C → %d°C", d.Day, p.MinC, d.MinC))
+		}
+		if abs(d.Rain-p.Rain) >= FeedRainChange {
+			changes = append(changes, fmt.Sprintf("%s: rain chance %d%% → %d%%", d.Day, p.Rain, d.Rain))
+		}
+	}
+	return changes
+}
+
+// abs returns the absolute value of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// rollFeedDays keeps the published values of the days cur still covers and
+// takes new days from cur
+func rollFeedDays(published, cur []feedDay) []feedDay {
+	previous := make(map[string]feedDay, len(published))
+	for _, d := range published {
+		previous[d.Date] = d
+	}
+	merged := make([]feedDay, len(cur))
+	for i, d := range cur {
+		if p, ok := previous[d.Date]; ok {
+			merged[i] = p
+			continue
+		}
+		merged[i] = d
+	}
+	return merged
+}
+
+// sameFeedDates reports whether a and b cover the same dates
+func sameFeedDates(a, b []feedDay) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Date != b[i].Date {
+			return false
+		}
+	}
+	return true
+}
+
+// forecastSummary lists the processed forecast, one day per line
+func forecastSummary(page PageData) string {
+	lines := make([]string, len(page.Forecast))
+	for i, f := range page.Forecast {
+		lines[i] = fmt.Sprintf("%s: %s, %s", f.Day, f.Description, f.Temperature)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// observe compares the forecast for location with the last one seen and
+// records an entry when it is new or has changed materially
+func (s *feedStore) observe(location string, page PageData, days []Weather, now time.Time) {
+	cur := feedDays(page, days)
+	if len(cur) == 0 {
+		return
+	}
+	if s.update(location, page, cur, now) {
+		if err := s.save(); err != nil {
+			log.Printf("Error saving feed state: %v", err)
+		}
+	}
+}
+
+// update makes observe's comparison under s.mu and reports whether the
+// published state changed. Request times alone are not worth a write and
+// are saved with the next change.
+func (s *feedStore) update(location string, page PageData, cur []feedDay, now time.Time) bool {
+	key := locationKey(location)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.locations[key]
+	var title, summary string
+	if !ok {
+		s.makeRoomLocked(now)
+		state = &feedLocation{Requested: now.UTC()}
+		s.locations[key] = state
+		title = "Forecast for " + page.Location
+		summary = forecastSummary(page)
+	} else {
+		state.Requested = now.UTC()
+		changes := forecastChanges(state.Days, cur)
+		if len(changes) == 0 {
+			// Keep comparing against the published forecast so slow drifts
+			// add up, but follow the forecast as it rolls forward
+			merged := rollFeedDays(state.Days, cur)
+			if sameFeedDates(merged, state.Days) {
+				return false
+			}
+			state.Days = merged
+			return true
+		}
+		title = "Forecast changed for " + page.Location
+		summary = strings.Join(changes, "\n") + "\n\n" + forecastSummary(page)
+	}
+
+	state.Name = page.Location
+	state.Days = cur
+	state.Entries = append([]FeedEntry{{
+		ID:      feedID("forecast", key, now.UTC().Format(time.RFC3339Nano)),
+		Title:   title,
+		Summary: summary,
+		Updated: now.UTC(),
+	}}, state.Entries...)
+	if len(state.Entries) > FeedMaxEntries {
+		state.Entries = state.Entries[:FeedMaxEntries]
+	}
+	return true
+}
+
+// makeRoomLocked drops the feeds not requested within s.expiry and, if the
+// store is still full, the least recently requested one; s.mu must be held
+func (s *feedStore) makeRoomLocked(now time.Time) {
+	var oldestKey string
+	var oldest time.Time
+	for key, state := range s.locations {
+		if now.Sub(state.Requested) > s.expiry {
+			delete(s.locations, key)
+			continue
+		}
+		if oldestKey == "" || state.Requested.Before(oldest) {
+			oldestKey, oldest = key, state.Requested
+		}
+	}
+	if len(s.locations) >= s.maxLocations {
+		delete(s.locations, oldestKey)
+	}
+}
+
+// entries returns the recorded entries for location, newest first
+func (s *feedStore) entries(location string) []FeedEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state, ok := s.locations[locationKey(location)]; ok {
+		return append([]FeedEntry(nil), state.Entries...)
+	}
+	return nil
+}
+
+// feedID derives a stable urn:uuid from its parts
+func feedID(parts ...string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("wttr-app:"+strings.Join(parts, "\x00"))).URN()
+}
+
+// Atom document elements
+type atomFeed struct {
+	XMLName   xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Links     []atomLink  `xml:"link"`
+	Author    atomPerson  `xml:"author"`
+	Generator string      `xml:"generator"`
+	Entries   []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	ID       string       `xml:"id"`
+	Title    string       `xml:"title"`
+	Updated  string       `xml:"updated"`
+	Link     atomLink     `xml:"link"`
+	Category atomCategory `xml:"category"`
+	Summary  atomText     `xml:"summary"`
+}
+
+// requestBaseURL is the scheme and host the client used
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// feedHandler serves GET /feed/{location}.atom
+func (app *App) feedHandler(w http.ResponseWriter, r *http.Request) {
+	location := strings.TrimSpace(mux.Vars(r)["location"])
+	weatherData, ok := app.fetchForText(w, r, location)
+	if !ok {
+		return
+	}
+	page := app.processWeatherData(weatherData)
+	now := time.Now()
+	app.feeds.observe(location, page, forecastDays(weatherData.Weather), now)
+
+	body, err := app.renderFeed(r, location, page.Location)
+	if err != nil {
+		log.Printf("Error encoding feed for %q: %v", location, err)
+		writeText(w, http.StatusInternalServerError, ErrTemplateExecution)
+		return
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(body)
+}
+
+// renderFeed builds the Atom document of forecast entries and the alerts
+// fired for location
+func (app *App) renderFeed(r *http.Request, location, name string) ([]byte, error) {
+	key := locationKey(location)
+	base := requestBaseURL(r)
+	page := base + "/" + url.PathEscape(location)
+
+	type dated struct {
+		entry atomEntry
+		at    time.Time
+	}
+	var items []dated
+	for _, e := range app.feeds.entries(location) {
+		items = append(items, dated{atomEntry{
+			ID:       e.ID,
+			Title:    e.Title,
+			Updated:  e.Updated.Format(time.RFC3339),
+			Link:     atomLink{Rel: "alternate", Type: "text/html", Href: page},
+			Category: atomCategory{Term: "forecast"},
+			Summary:  atomText{Type: "text", Body: e.Summary},
+		}, e.Updated})
+	}
+	for _, a := range app.alerts.list() {
+		if locationKey(a.Location) != key {
+			continue
+		}
+		items = append(items, dated{atomEntry{
+			ID:       feedID("alert", a.ID, a.FiredAt.UTC().Format(time.RFC3339Nano)),
+			Title:    fmt.Sprintf("Alert: %s in %s", a.Rule, name),
+			Updated:  a.FiredAt.UTC().Format(time.RFC3339),
+			Link:     atomLink{Rel: "alternate", Type: "text/html", Href: base + "/alerts"},
+			Category: atomCategory{Term: "alert"},
+			Summary:  atomText{Type: "text", Body: fmt.Sprintf("%s (value %g) in %s", a.Condition, a.Value, name)},
+		}, a.FiredAt})
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].at.After(items[j].at) })
+
+	entries := make([]atomEntry, len(items))
+	for i, item := range items {
+		entries[i] = item.entry
+	}
+	feedUpdated := time.Now()
+	if len(items) > 0 {
+		feedUpdated = items[0].at
+	}
+	feed := atomFeed{
+		ID:      feedID("feed", key),
+		Title:   "Weather: " + name,
+		Updated: feedUpdated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Type: "application/atom+xml", Href: base + r.URL.Path},
+			{Rel: "alternate", Type: "text/html", Href: page},
+		},
+		Author:    atomPerson{Name: "wttr-app"},
+		Generator: "wttr-app",
+		Entries:   entries,
+	}
+
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(body, '\n')...), nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// parsedFeed is what a feed reader sees of an Atom document
+type parsedFeed struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Entries []struct {
+		ID       string `xml:"id"`
+		Title    string `xml:"title"`
+		Updated  string `xml:"updated"`
+		Category struct {
+			Term string `xml:"term,attr"`
+		} `xml:"category"`
+		Summary string `xml:"summary"`
+	} `xml:"entry"`
+}
+
+// getFeed fetches and parses the feed at target
+func getFeed(t *testing.T, handler http.Handler, target string) parsedFeed {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d: %s", target, rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/atom+xml; charset=utf-8" {
+		t.Errorf("content type = %q", ct)
+	}
+	var feed parsedFeed
+	if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("feed does not parse: %v\n%s", err, rec.Body)
+	}
+	if !strings.HasPrefix(feed.ID, "urn:uuid:") {
+		t.Errorf("feed id = %q", feed.ID)
+	}
+	var previous time.Time
+	for i, e := range feed.Entries {
+		if !strings.HasPrefix(e.ID, "urn:uuid:") {
+			t.Errorf("entry %d id = %q", i, e.ID)
+		}
+		updated, err := time.Parse(time.RFC3339, e.Updated)
+		if err != nil {
+			t.Errorf("entry %d updated = %q: %v", i, e.Updated, err)
+		}
+		if i == 0 && e.Updated != feed.Updated {
+			t.Errorf("feed updated %q, newest entry %q", feed.Updated, e.Updated)
+		}
+		if i > 0 && updated.After(previous) {
+			t.Errorf("entry %d is newer than the one before it", i)
+		}
+		previous = updated
+	}
+	return feed
+}
+
+// entryIDs lists the ids of feed's entries, newest first
+func entryIDs(feed parsedFeed) []string {
+	ids := make([]string, len(feed.Entries))
+	for i, e := range feed.Entries {
+		ids[i] = e.ID
+	}
+	return ids
+}
+
+func TestFeedEntries(t *testing.T) {
+	var mu sync.Mutex
+	data := testWeatherData("Oslo", 12)
+	// shift moves the first day's high and every hour's chance of rain
+	shift := func(maxC, rain int) {
+		mu.Lock()
+		defer mu.Unlock()
+		day := &data.Weather[0]
+		day.MaxtempC = strconv.Itoa(atoi(day.MaxtempC) + maxC)
+		for h := range day.Hourly {
+			day.Hourly[h].ChanceOfRain = strconv.Itoa(atoi(day.Hourly[h].ChanceOfRain) + rain)
+		}
+	}
+
+	app := newTestApp(t, DefaultConfig())
+	fakeUpstream(t, app, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		serveWeather(data)(w, r)
+	}))
+	handler := app.routes()
+
+	feed := getFeed(t, handler, "/feed/Oslo.atom")
+	if len(feed.Entries) != 1 || feed.Entries[0].Category.Term != "forecast" {
+		t.Fatalf("first fetch: %+v", feed.Entries)
+	}
+	first := feed.Entries[0].ID
+	if again := getFeed(t, handler, "/feed/Oslo.atom"); strings.Join(entryIDs(again), ",") != first || again.ID != feed.ID {
+		t.Errorf("unchanged forecast: ids %v, feed %s, want %s, %s", entryIDs(again), again.ID, first, feed.ID)
+	}
+
+	// Drift below the thresholds adds nothing, each time and in total
+	shift(FeedTempChange-1, 0)
+	shift(0, FeedRainChange-10)
+	feed = getFeed(t, handler, "/feed/Oslo.atom")
+	if len(feed.Entries) != 1 || feed.Entries[0].ID != first {
+		t.Fatalf("minor drift added entries: %v", entryIDs(feed))
+	}
+
+	// The drift adds up against the published forecast until it counts
+	shift(1, 10)
+	feed = getFeed(t, handler, "/feed/Oslo.atom")
+	if len(feed.Entries) != 2 || feed.Entries[1].ID != first || feed.Entries[0].ID == first {
+		t.Fatalf("after a material change: %v", entryIDs(feed))
+	}
+	for _, want := range []string{"high 14°C → 17°C", "rain chance 20% → 50%"} {
+		if !strings.Contains(feed.Entries[0].Summary, want) {
+			t.Errorf("summary %q does not mention %q", feed.Entries[0].Summary, want)
+		}
+	}
+	if again := getFeed(t, handler, "/feed/Oslo.atom"); strings.Join(entryIDs(again), ",") != strings.Join(entryIDs(feed), ",") {
+		t.Errorf("ids changed between fetches: %v, then %v", entryIDs(feed), entryIDs(again))
+	}
+}
+
+func TestFeedStoreBounds(t *testing.T) {
+	app := newTestApp(t, DefaultConfig())
+	stateFile := filepath.Join(t.TempDir(), "feeds.json")
+	s := newFeedStore(FeedsConfig{StateFile: stateFile, MaxLocations: 2, Expiry: Duration{24 * time.Hour}})
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	observe := func(location string, at time.Duration) {
+		data := testWeatherData(location, 12)
+		s.observe(location, app.processWeatherData(data), forecastDays(data.Weather), start.Add(at))
+	}
+	locations := func() []string {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		keys := make([]string, 0, len(s.locations))
+		for key := range s.locations {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	observe("Oslo", 0)
+	observe("Bergen", time.Hour)
+	observe("Oslo", 2*time.Hour)
+	// The store is full, so Bergen, requested least recently, makes way
+	observe("Tromsø", 3*time.Hour)
+	if got, want := locations(), []string{locationKey("Oslo"), locationKey("Tromsø")}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("after filling up: %v, want %v", got, want)
+	}
+
+	// A day later both have expired
+	observe("Bodø", 28*time.Hour)
+	if got, want := locations(), []string{locationKey("Bodø")}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("after expiry: %v, want %v", got, want)
+	}
+
+	restored := newFeedStore(FeedsConfig{StateFile: stateFile, MaxLocations: 2, Expiry: Duration{24 * time.Hour}})
+	if err := restored.load(start); err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.locations) != 1 || len(restored.entries("Bodø")) != 1 {
+		t.Errorf("restored %d locations", len(restored.locations))
+	}
+
+	// Feeds saved before request times were kept count as requested at load
+	b, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved map[string]map[string]interface{}
+	if err := json.Unmarshal(b, &saved); err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range saved {
+		delete(state, "requested")
+	}
+	if b, err = json.Marshal(saved); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(stateFile, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	restored = newFeedStore(FeedsConfig{StateFile: stateFile, MaxLocations: 2, Expiry: Duration{24 * time.Hour}})
+	if err := restored.load(start); err != nil {
+		t.Fatal(err)
+	}
+	if state := restored.locations[locationKey("Bodø")]; state == nil || !state.Requested.Equal(start) {
+		t.Errorf("restored without a request time: %+v", state)
+	}
+}
+
+func TestFeedStoreConcurrentObserve(t *testing.T) {
+	app := newTestApp(t, DefaultConfig())
+	s := newFeedStore(FeedsConfig{StateFile: filepath.Join(t.TempDir(), "feeds.json"), MaxLocations: 5, Expiry: Duration{time.Hour}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			location := fmt.Sprintf("Place %d", i)
+			data := testWeatherData(location, i)
+			s.observe(location, app.processWeatherData(data), forecastDays(data.Weather), time.Now())
+			s.entries(location)
+		}(i)
+	}
+	wg.Wait()
+
+	if n := len(s.locations); n != 5 {
+		t.Errorf("kept %d locations, want 5", n)
+	}
+	restored := newFeedStore(FeedsConfig{StateFile: s.stateFile, MaxLocations: 5, Expiry: Duration{time.Hour}})
+	if err := restored.load(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(restored.locations); n != 5 {
+		t.Errorf("state file has %d locations, want 5", n)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// One-line ?format= output for status bars, compatible with wttr.in's
+// placeholders:
+//
+//	%c  condition icon      %C  condition text
+//	%t  temperature         %f  feels-like temperature
+//	%h  humidity            %w  wind
+//	%l  location            %m  moon phase
+//	%%  a literal percent sign
+
+// predefinedFormats are selected with ?format=1 to ?format=4
+var predefinedFormats = map[string]string{
+	"1": "%c %t",
+	"2": "%c 🌡️%t 🌬️%w",
+	"3": "%l: %c %t",
+	"4": "%l: %c 🌡️%t 🌬️%w",
+}
+
+// conditionEmoji mirrors weatherIcons and is looked up by weatherIconName
+var conditionEmoji = map[string]string{
+	"clear":        "☀️",
+	"partlyCloudy": "⛅️",
+	"cloudy":       "☁️",
+	"overcast":     "☁️",
+	"rain":         "🌦",
+	"heavyRain":    "🌧",
+	"thunderstorm": "⛈",
+	"snow":         "🌨",
+	"fog":          "🌫",
+	"wind":         "💨",
+	"default":      "✨",
+}
+
+// moonEmoji maps wttr.in's moon_phase names to emoji
+var moonEmoji = map[string]string{
+	"new moon":        "🌑",
+	"waxing crescent": "🌒",
+	"first quarter":   "🌓",
+	"waxing gibbous":  "🌔",
+	"full moon":       "🌕",
+	"waning gibbous":  "🌖",
+	"last quarter":    "🌗",
+	"waning crescent": "🌘",
+}
+
+// lineValues are the placeholder values for one location
+type lineValues struct {
+	Location    string
+	Condition   string
+	Icon        string
+	Temperature string
+	FeelsLike   string
+	Humidity    string
+	Wind        string
+	MoonPhase   string
+}
+
+// getConditionEmoji returns the emoji for a weather description
+func getConditionEmoji(condition string) string {
+	if icon, ok := conditionEmoji[weatherIconName(condition)]; ok {
+		return icon
+	}
+	return conditionEmoji["default"]
+}
+
+// newLineValues builds placeholder values from validated wttr.in data.
+// location is shown as the client asked for it.
+func newLineValues(location string, data *WeatherData) lineValues {
+	current := data.CurrentCondition[0]
+
+	condition := ""
+	if len(current.WeatherDesc) > 0 {
+		condition = current.WeatherDesc[0].Value
+	}
+	icon := getConditionEmoji(condition)
+
+	wind := current.WindspeedKmph + "km/h"
+	if arrow, ok := windArrows[current.Winddir16Point]; ok {
+		wind = arrow + wind
+	}
+
+	moon := ""
+	if astronomy := data.Weather[0].Astronomy; len(astronomy) > 0 {
+		moon = moonEmoji[strings.ToLower(strings.TrimSpace(astronomy[0].MoonPhase))]
+	}
+
+	return lineValues{
+		Location:    location,
+		Condition:   condition,
+		Icon:        icon,
+		Temperature: signedCelsius(current.TempC),
+		FeelsLike:   signedCelsius(current.FeelsLikeC),
+		Humidity:    current.Humidity + "%",
+		Wind:        wind,
+		MoonPhase:   moon,
+	}
+}
+
+// signedCelsius formats a temperature as wttr.in does, e.g. +12°C
+func signedCelsius(value string) string {
+	t := atoi(value)
+	if t > 0 {
+		return "+" + strconv.Itoa(t) + "°C"
+	}
+	return strconv.Itoa(t) + "°C"
+}
+
+// formatLine expands the placeholders in format. Unknown placeholders are
+// left as they are.
+func formatLine(format string, v lineValues) string {
+	if predefined, ok := predefinedFormats[format]; ok {
+		format = predefined
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch format[i] {
+		case 'c':
+			b.WriteString(v.Icon)
+		case 'C':
+			b.WriteString(v.Condition)
+		case 't':
+			b.WriteString(v.Temperature)
+		case 'f':
+			b.WriteString(v.FeelsLike)
+		case 'h':
+			b.WriteString(v.Humidity)
+		case 'w':
+			b.WriteString(v.Wind)
+		case 'l':
+			b.WriteString(v.Location)
+		case 'm':
+			b.WriteString(v.MoonPhase)
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(format[i])
+		}
+	}
+	return b.String()
+}
+
+// formatParam returns the ?format= value. It is read from the raw query,
+// since wttr.in-style formats such as %c+%t are not valid percent-encoding
+// and url.ParseQuery would drop them: only '+' and well-formed %XX escapes
+// are decoded and any other '%' is kept as it is.
+func formatParam(r *http.Request) (string, bool) {
+	for _, pair := range strings.Split(r.URL.RawQuery, "&") {
+		key, value, _ := strings.Cut(pair, "=")
+		if lenientUnescape(key) == "format" {
+			return lenientUnescape(value), true
+		}
+	}
+	return "", false
+}
+
+// lenientUnescape decodes a query component like url.QueryUnescape but
+// leaves malformed escapes in place instead of failing
+func lenientUnescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '+':
+			b.WriteByte(' ')
+		case s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			n, _ := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			b.WriteByte(byte(n))
+			i += 2
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// formatHandler writes the ?format= line for location
+func (app *App) formatHandler(w http.ResponseWriter, r *http.Request, location string) {
+	format, _ := formatParam(r)
+	if format == "" {
+		format = "1"
+	}
+	if len(format) > MaxFormatLength {
+		writeText(w, http.StatusBadRequest, ErrFormatTooLong)
+		return
+	}
+	weatherData, ok := app.fetchForText(w, r, location)
+	if !ok {
+		return
+	}
+
+	writeText(w, http.StatusOK, formatLine(format, newLineValues(location, weatherData)))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFormatLine(t *testing.T) {
+	v := lineValues{
+		Location:    "Oslo",
+		Condition:   "Partly cloudy",
+		Icon:        "⛅️",
+		Temperature: "+12°C",
+		FeelsLike:   "+10°C",
+		Humidity:    "81%",
+		Wind:        "↗15km/h",
+		MoonPhase:   "🌔",
+	}
+	tests := []struct {
+		format, want string
+	}{
+		{"%c", "⛅️"},
+		{"%C", "Partly cloudy"},
+		{"%t", "+12°C"},
+		{"%f", "+10°C"},
+		{"%h", "81%"},
+		{"%w", "↗15km/h"},
+		{"%l", "Oslo"},
+		{"%m", "🌔"},
+		{"%%", "%"},
+		{"%l: %c %t", "Oslo: ⛅️ +12°C"},
+		{"%c%t", "⛅️+12°C"},
+		{"1", "⛅️ +12°C"},
+		{"2", "⛅️ 🌡️+12°C 🌬️↗15km/h"},
+		{"3", "Oslo: ⛅️ +12°C"},
+		{"4", "Oslo: ⛅️ 🌡️+12°C 🌬️↗15km/h"},
+		// Unknown placeholders and a trailing % are left as they are
+		{"%x %t", "%x +12°C"},
+		{"%Z%z", "%Z%z"},
+		{"100%", "100%"},
+		{"no placeholders", "no placeholders"},
+		{"5", "5"},
+	}
+	for _, tt := range tests {
+		if got := formatLine(tt.format, v); got != tt.want {
+			t.Errorf("formatLine(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestFormatParam(t *testing.T) {
+	tests := []struct {
+		rawQuery string
+		want     string
+		ok       bool
+	}{
+		// wttr.in style, with bare % signs
+		{"format=%c+%t+%w", "%c %t %w", true},
+		{"format=%l:+%c+%t", "%l: %c %t", true},
+		{"format=%C+%h+%m+%f", "%C %h %m %f", true},
+		{"format=%c%t", "%c%t", true},
+		{"format=100%", "100%", true},
+		{"format=%%", "%%", true},
+		// Properly encoded
+		{"format=%25c%20%25t", "%c %t", true},
+		{"format=%E2%9A%A1%c", "⚡%c", true},
+		{"format=3", "3", true},
+		// Alongside other parameters
+		{"nT&format=%c+%t", "%c %t", true},
+		{"format=%c&format=%t", "%c", true},
+		{"format=", "", true},
+		{"format", "", true},
+		{"", "", false},
+		{"formats=%c", "", false},
+		{"T", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/Oslo?"+tt.rawQuery, nil)
+		got, ok := formatParam(r)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("formatParam(%q) = %q, %v, want %q, %v", tt.rawQuery, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFormatEndpoint(t *testing.T) {
+	app := newTestApp(t, DefaultConfig())
+	fakeUpstream(t, app, serveWeather(testWeatherData("Oslo", 12)))
+	handler := app.routes()
+
+	wind := windArrows["WSW"] + "15km/h"
+	tests := []struct {
+		target, want string
+	}{
+		{"/Oslo?format=%c+%t+%w", "⛅️ +12°C " + wind},
+		{"/Oslo?format=%l:+%c+%t", "Oslo: ⛅️ +12°C"},
+		{"/Oslo?format=%25c%20%25t", "⛅️ +12°C"},
+		{"/Oslo?format=%x+%t", "%x +12°C"},
+		{"/Oslo?format=3", "Oslo: ⛅️ +12°C"},
+		{"/Oslo?format=", "⛅️ +12°C"},
+		{"/?format=%l:+%C", DefaultLocation + ": Partly cloudy"},
+	}
+	for _, tt := range tests {
+		// A browser's Accept header would otherwise get the HTML page
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		req.Header.Set("Accept", "text/html")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s: status %d", tt.target, rec.Code)
+			continue
+		}
+		if got := strings.TrimSuffix(rec.Body.String(), "\n"); got != tt.want {
+			t.Errorf("GET %s = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/Oslo?format="+strings.Repeat("%25t", MaxFormatLength), nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("long format: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	_ "modernc.org/sqlite"
+)
+
+// historyMigrations upgrade the database schema in order. The number applied
+// is kept in PRAGMA user_version; append new steps, never edit old ones.
+var historyMigrations = []string{
+	// 1: current condition snapshots
+	`CREATE TABLE observations (
+		id            INTEGER PRIMARY KEY,
+		location      TEXT    NOT NULL,
+		area          TEXT    NOT NULL,
+		observed_at   INTEGER NOT NULL,
+		temp_c        REAL    NOT NULL,
+		feels_like_c  REAL    NOT NULL,
+		humidity      REAL    NOT NULL,
+		wind_kmph     REAL    NOT NULL,
+		wind_dir      TEXT    NOT NULL,
+		visibility_km REAL    NOT NULL,
+		condition     TEXT    NOT NULL,
+		snapshot      TEXT    NOT NULL
+	);
+	CREATE INDEX observations_location_time ON observations (location, observed_at);`,
+	// 2: precipitation over the hour before each snapshot
+	`ALTER TABLE observations ADD COLUMN precip_mm REAL NOT NULL DEFAULT 0;`,
+	// 3: daily forecasts by lead time, for verification
+	`CREATE TABLE forecasts (
+		id          INTEGER PRIMARY KEY,
+		provider    TEXT    NOT NULL,
+		location    TEXT    NOT NULL,
+		issued_at   INTEGER NOT NULL,
+		target_date TEXT    NOT NULL,
+		lead_days   INTEGER NOT NULL,
+		max_temp_c  REAL    NOT NULL,
+		min_temp_c  REAL    NOT NULL
+	);
+	CREATE UNIQUE INDEX forecasts_target ON forecasts (provider, location, target_date, lead_days);`,
+	// 4: the location's UTC offset in seconds, so days are the location's
+	// own; earlier rows count as UTC
+	`ALTER TABLE observations ADD COLUMN utc_offset INTEGER NOT NULL DEFAULT 0;`,
+}
+
+// maxUTCOffset bounds the offsets of real time zones, which run from -12h
+// to +14h; it also widens time range lookups that filter on local time
+const maxUTCOffset = 14 * time.Hour
+
+// utcOffset derives the location's UTC offset from wttr.in's local and UTC
+// observation times, rounded to 15 minutes. It is zero when either is
+// missing.
+func utcOffset(current CurrentCondition) time.Duration {
+	local, err := time.Parse("2006-01-02 03:04 PM", current.LocalObsDateTime)
+	if err != nil {
+		return 0
+	}
+	clock, err := time.Parse("03:04 PM", current.ObservationTime)
+	if err != nil {
+		return 0
+	}
+	utc := time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, time.UTC)
+	offset := local.Sub(utc)
+	// The UTC observation may fall on the day before or after
+	if offset > maxUTCOffset {
+		offset -= 24 * time.Hour
+	} else if offset < -12*time.Hour {
+		offset += 24 * time.Hour
+	}
+	return offset.Round(15 * time.Minute)
+}
+
+// Observation is one recorded CurrentCondition. Its tags name the columns
+// of the history API and exports.
+type Observation struct {
+	Time          time.Time `json:"time" parquet:"time" unit:"UTC"`
+	Area          string    `json:"area" parquet:"area"`
+	TempC         float64   `json:"temp_c" parquet:"temp_c" unit:"°C"`
+	FeelsLikeC    float64   `json:"feels_like_c" parquet:"feels_like_c" unit:"°C"`
+	Humidity      float64   `json:"humidity" parquet:"humidity" unit:"%"`
+	WindspeedKmph float64   `json:"windspeed_kmph" parquet:"windspeed_kmph" unit:"km/h"`
+	WindDirection string    `json:"wind_direction" parquet:"wind_direction" unit:"16-point compass"`
+	VisibilityKm  float64   `json:"visibility_km" parquet:"visibility_km" unit:"km"`
+	PrecipMM      float64   `json:"precip_mm" parquet:"precip_mm" unit:"mm in the previous hour"`
+	Description   string    `json:"description" parquet:"description"`
+}
+
+// historyStore records observations in SQLite
+type historyStore struct {
+	db *sql.DB
+	// tracked holds the location keys the recorder polls, whose served
+	// forecasts are kept for verification
+	tracked map[string]bool
+}
+
+// openHistoryStore opens or creates the database at path and migrates it
+func openHistoryStore(path string) (*historyStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// A single connection serialises writers, which SQLite needs anyway
+	db.SetMaxOpenConns(1)
+
+	s := &historyStore{db: db}
+	if err := s.migrate(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate applies the migrations the database has not seen yet
+func (s *historyStore) migrate(ctx context.Context) error {
+	var version int
+	if err := s.db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version > len(historyMigrations) {
+		return fmt.Errorf("database schema version %d is newer than this build (%d)", version, len(historyMigrations))
+	}
+
+	for i := version; i < len(historyMigrations); i++ {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, historyMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+		// PRAGMA does not take bound parameters
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+		log.Printf("History: applied schema migration %d", i+1)
+	}
+	return nil
+}
+
+// record stores a snapshot of data's current conditions for location
+func (s *historyStore) record(ctx context.Context, location string, at time.Time, data *WeatherData) error {
+	current := data.CurrentCondition[0]
+	area := data.NearestArea[0]
+	snapshot, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	description := ""
+	if len(current.WeatherDesc) > 0 {
+		description = current.WeatherDesc[0].Value
+	}
+
+	_, err = s.db.ExecContext(ctx, `INSERT INTO observations
+		(location, area, observed_at, utc_offset, temp_c, feels_like_c, humidity, wind_kmph, wind_dir, visibility_km, precip_mm, condition, snapshot)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		locationKey(location),
+		fmt.Sprintf("%s, %s", area.AreaName[0].Value, area.Country[0].Value),
+		at.Unix(), int64(utcOffset(current).Seconds()),
+		atoi(current.TempC), atoi(current.FeelsLikeC), atoi(current.Humidity),
+		atoi(current.WindspeedKmph), current.Winddir16Point, atoi(current.Visibility),
+		atof(current.PrecipMM), description, string(snapshot))
+	return err
+}
+
+// observationCursor is the position of a row in (observed_at, id) order
+type observationCursor struct {
+	at int64
+	id int64
+}
+
+// observations returns the snapshots for location in [from, to), oldest
+// first, up to limit rows
+func (s *historyStore) observations(ctx context.Context, location string, from, to time.Time, limit int) ([]Observation, error) {
+	observations, _, err := s.observationsAfter(ctx, locationKey(location), observationCursor{at: from.Unix()}, to, limit)
+	return observations, err
+}
+
+// observationsAfter returns up to limit snapshots after cursor and before
+// to, with the cursor of the last one
+func (s *historyStore) observationsAfter(ctx context.Context, key string, after observationCursor, to time.Time, limit int) ([]Observation, observationCursor, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, observed_at, area, temp_c, feels_like_c, humidity, wind_kmph, wind_dir, visibility_km, precip_mm, condition
+		FROM observations
+		WHERE location = ? AND (observed_at > ? OR (observed_at = ? AND id > ?)) AND observed_at < ?
+		ORDER BY observed_at, id
+		LIMIT ?`,
+		key, after.at, after.at, after.id, to.Unix(), limit)
+	if err != nil {
+		return nil, after, err
+	}
+	defer rows.Close()
+
+	observations := []Observation{}
+	for rows.Next() {
+		var o Observation
+		if err := rows.Scan(&after.id, &after.at, &o.Area, &o.TempC, &o.FeelsLikeC, &o.Humidity, &o.WindspeedKmph, &o.WindDirection, &o.VisibilityKm, &o.PrecipMM, &o.Description); err != nil {
+			return nil, after, err
+		}
+		o.Time = time.Unix(after.at, 0).UTC()
+		observations = append(observations, o)
+	}
+	return observations, after, rows.Err()
+}
+
+// eachObservation calls fn for every snapshot for location in [from, to),
+// oldest first. Rows are read in batches so the connection is free for the
+// recorder while fn is slow, for example writing to a client.
+func (s *historyStore) eachObservation(ctx context.Context, location string, from, to time.Time, fn func(Observation) error) error {
+	key := locationKey(location)
+	cursor := observationCursor{at: from.Unix()}
+	for {
+		batch, next, err := s.observationsAfter(ctx, key, cursor, to, ExportBatchRows)
+		if err != nil {
+			return err
+		}
+		for _, o := range batch {
+			if err := fn(o); err != nil {
+				return err
+			}
+		}
+		if len(batch) < ExportBatchRows {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// DailySummary aggregates one local day of observations. Date is that
+// calendar date at midnight UTC.
+type DailySummary struct {
+	Date        time.Time `json:"date"`
+	MinTempC    float64   `json:"min_temp_c"`
+	MaxTempC    float64   `json:"max_temp_c"`
+	MeanTempC   float64   `json:"mean_temp_c"`
+	PrecipMM    float64   `json:"precip_mm"`
+	MaxWindKmph float64   `json:"max_wind_kmph"`
+	Samples     int       `json:"samples"`
+}
+
+// dailySummaries aggregates the observations for location by the
+// location's local date, oldest first. from and to bound the local dates
+// and are midnight UTC, as DailySummary.Date is. Each snapshot's
+// precipitation covers the previous hour, so the total takes one value per
+// hour rather than summing snapshots that overlap.
+func (s *historyStore) dailySummaries(ctx context.Context, location string, from, to time.Time) ([]DailySummary, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT day, MIN(min_temp), MAX(max_temp), SUM(sum_temp) / SUM(samples),
+			SUM(precip), MAX(max_wind), SUM(samples)
+		FROM (
+			SELECT date(observed_at + utc_offset, 'unixepoch') AS day,
+				strftime('%H', observed_at + utc_offset, 'unixepoch') AS hour,
+				MIN(temp_c) AS min_temp, MAX(temp_c) AS max_temp, SUM(temp_c) AS sum_temp,
+				MAX(precip_mm) AS precip, MAX(wind_kmph) AS max_wind, COUNT(*) AS samples
+			FROM observations
+			WHERE location = ? AND observed_at >= ? AND observed_at < ?
+				AND observed_at + utc_offset >= ? AND observed_at + utc_offset < ?
+			GROUP BY day, hour
+		)
+		GROUP BY day
+		ORDER BY day`,
+		locationKey(location), from.Add(-maxUTCOffset).Unix(), to.Add(maxUTCOffset).Unix(), from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := []DailySummary{}
+	for rows.Next() {
+		var d DailySummary
+		var day string
+		if err := rows.Scan(&day, &d.MinTempC, &d.MaxTempC, &d.MeanTempC, &d.PrecipMM, &d.MaxWindKmph, &d.Samples); err != nil {
+			return nil, err
+		}
+		if d.Date, err = time.Parse("2006-01-02", day); err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
+
+// HistoryRecords are the extremes recorded at a location. HighDate and
+// LowDate are the local dates they were set on, at midnight UTC like
+// DailySummary.Date.
+type HistoryRecords struct {
+	Since    time.Time `json:"since"`
+	HighC    float64   `json:"high_c"`
+	HighAt   time.Time `json:"high_at"`
+	HighDate time.Time `json:"high_date"`
+	LowC     float64   `json:"low_c"`
+	LowAt    time.Time `json:"low_at"`
+	LowDate  time.Time `json:"low_date"`
+	Samples  int       `json:"samples"`
+}
+
+// records returns the highest and lowest temperatures ever recorded at
+// location, or nil when nothing has been recorded. Ties go to the earliest.
+func (s *historyStore) records(ctx context.Context, location string) (*HistoryRecords, error) {
+	key := locationKey(location)
+	var rec HistoryRecords
+	var since int64
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(MIN(observed_at), 0)
+		FROM observations WHERE location = ?`, key).Scan(&rec.Samples, &since); err != nil {
+		return nil, err
+	}
+	if rec.Samples == 0 {
+		return nil, nil
+	}
+	rec.Since = time.Unix(since, 0).UTC()
+
+	extreme := func(order string, temp *float64, at, date *time.Time) error {
+		var ts, offset int64
+		err := s.db.QueryRowContext(ctx, `SELECT temp_c, observed_at, utc_offset FROM observations
+			WHERE location = ?
+			ORDER BY temp_c `+order+`, observed_at
+			LIMIT 1`, key).Scan(temp, &ts, &offset)
+		*at = time.Unix(ts, 0).UTC()
+		*date = time.Unix(ts+offset, 0).UTC().Truncate(24 * time.Hour)
+		return err
+	}
+	if err := extreme("DESC", &rec.HighC, &rec.HighAt, &rec.HighDate); err != nil {
+		return nil, err
+	}
+	if err := extreme("ASC", &rec.LowC, &rec.LowAt, &rec.LowDate); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// localToday returns the current date at location, at midnight UTC, using
+// the UTC offset of its latest observation
+func (s *historyStore) localToday(ctx context.Context, location string, now time.Time) (time.Time, error) {
+	var offset int64
+	err := s.db.QueryRowContext(ctx, `SELECT utc_offset FROM observations
+		WHERE location = ?
+		ORDER BY observed_at DESC
+		LIMIT 1`, locationKey(location)).Scan(&offset)
+	if err != nil && err != sql.ErrNoRows {
+		return time.Time{}, err
+	}
+	return now.Add(time.Duration(offset) * time.Second).UTC().Truncate(24 * time.Hour), nil
+}
+
+// prune deletes observations and forecasts older than before, returning the
+// number of observations removed
+func (s *historyStore) prune(ctx context.Context, before time.Time) (int64, error) {
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM forecasts WHERE issued_at < ?", before.Unix()); err != nil {
+		return 0, err
+	}
+	res, err := s.db.ExecContext(ctx, "DELETE FROM observations WHERE observed_at < ?", before.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Close closes the database
+func (s *historyStore) Close() error {
+	return s.db.Close()
+}
+
+// historyRecorder polls the configured locations into the store
+type historyRecorder struct {
+	app       *App
+	store     *historyStore
+	cfg       HistoryConfig
+	locations []string
+}
+
+// recordAll fetches and stores every location once
+func (h *historyRecorder) recordAll(ctx context.Context) {
+	now := time.Now()
+	for _, location := range h.locations {
+		if ctx.Err() != nil {
+			return
+		}
+		data, err := h.app.fetchWeatherData(ctx, location)
+		if err != nil {
+			log.Printf("History: error fetching %q: %v", location, err)
+			continue
+		}
+		if err := h.app.validateWeatherData(data); err != nil {
+			log.Printf("History: invalid data for %q: %v", location, err)
+			continue
+		}
+		if err := h.store.record(ctx, location, now, data); err != nil {
+			log.Printf("History: error recording %q: %v", location, err)
+		}
+		if err := h.store.recordForecasts(ctx, location, now, data); err != nil {
+			log.Printf("History: error recording forecasts for %q: %v", location, err)
+		}
+	}
+}
+
+// applyRetention drops observations older than the retention period
+func (h *historyRecorder) applyRetention(ctx context.Context) {
+	if h.cfg.Retention.Duration <= 0 {
+		return
+	}
+	n, err := h.store.prune(ctx, time.Now().Add(-h.cfg.Retention.Duration))
+	if err != nil {
+		log.Printf("History: error applying retention: %v", err)
+		return
+	}
+	if n > 0 {
+		log.Printf("History: removed %d observations older than %s", n, h.cfg.Retention.Duration)
+	}
+}
+
+// run records once per interval until ctx is cancelled
+func (h *historyRecorder) run(ctx context.Context) {
+	ticker := time.NewTicker(h.cfg.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		h.recordAll(ctx)
+		h.applyRetention(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// setupHistory opens the database and starts the recorder when configured
+func (app *App) setupHistory() error {
+	cfg := app.config.History
+	if cfg.Database == "" {
+		return nil
+	}
+
+	locations := cfg.Locations
+	if len(locations) == 0 {
+		locations = app.config.Favourites
+	}
+	if len(locations) == 0 {
+		return fmt.Errorf("history needs locations or favourites")
+	}
+
+	store, err := openHistoryStore(cfg.Database)
+	if err != nil {
+		return err
+	}
+	store.tracked = make(map[string]bool, len(locations))
+	for _, location := range locations {
+		store.tracked[locationKey(location)] = true
+	}
+	app.history = store
+	app.onShutdown(func(ctx context.Context) error {
+		return store.Close()
+	})
+
+	recorder := &historyRecorder{app: app, store: store, cfg: cfg, locations: locations}
+	app.goBackground(recorder.run)
+	return nil
+}
+
+// parseHistoryTime accepts RFC 3339 or a plain date. A date as the end of a
+// range includes the whole day.
+func parseHistoryTime(s string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not an RFC 3339 time or YYYY-MM-DD date", s)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// historyRange reads ?from= and ?to=, defaulting to the span before now
+func historyRange(r *http.Request, now time.Time, span time.Duration) (time.Time, time.Time, error) {
+	from, to := now.Add(-span), now
+	var err error
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = parseHistoryTime(v, false); err != nil {
+			return from, to, fmt.Errorf("from: %w", err)
+		}
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = parseHistoryTime(v, true); err != nil {
+			return from, to, fmt.Errorf("to: %w", err)
+		}
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("from must be before to")
+	}
+	return from, to, nil
+}
+
+// APIHistory is the response body of GET /api/v1/history/{location}
+type APIHistory struct {
+	Location     string        `json:"location"`
+	From         time.Time     `json:"from"`
+	To           time.Time     `json:"to"`
+	Truncated    bool          `json:"truncated"`
+	Observations []Observation `json:"observations"`
+}
+
+// apiHistoryHandler serves recorded observations for a location
+func (app *App) apiHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if app.history == nil {
+		writeJSONError(w, http.StatusNotFound, ErrHistoryDisabled)
+		return
+	}
+	location := strings.TrimSpace(mux.Vars(r)["location"])
+	if location == "" {
+		writeJSONError(w, http.StatusBadRequest, ErrEmptyLocation)
+		return
+	}
+	from, to, err := historyRange(r, time.Now(), 24*time.Hour)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Fetch one extra row to tell whether the result was cut off
+	observations, err := app.history.observations(r.Context(), location, from, to, HistoryMaxRows+1)
+	if err != nil {
+		log.Printf("Error querying history for %q: %v", location, err)
+		writeJSONError(w, http.StatusInternalServerError, ErrHistoryQuery)
+		return
+	}
+	truncated := len(observations) > HistoryMaxRows
+	if truncated {
+		observations = observations[:HistoryMaxRows]
+	}
+
+	writeJSON(w, http.StatusOK, APIHistory{
+		Location:     location,
+		From:         from.UTC(),
+		To:           to.UTC(),
+		Truncated:    truncated,
+		Observations: observations,
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUTCOffset(t *testing.T) {
+	tests := []struct {
+		local, utc string
+		want       time.Duration
+	}{
+		{"2024-05-01 10:23 AM", "08:23 AM", 2 * time.Hour},
+		{"2024-05-01 03:05 AM", "07:05 AM", -4 * time.Hour},
+		// The UTC observation falls on the previous or next day
+		{"2024-05-02 01:00 AM", "04:00 PM", 9 * time.Hour},
+		{"2024-05-01 07:00 PM", "04:00 AM", -9 * time.Hour},
+		{"2024-05-02 12:45 AM", "03:30 PM", 9*time.Hour + 15*time.Minute},
+		{"2024-05-01 04:45 PM", "11:00 AM", 5*time.Hour + 45*time.Minute},
+		// A minute's skew between the two is rounded away
+		{"2024-05-01 10:24 AM", "08:23 AM", 2 * time.Hour},
+		{"2024-05-01 10:23 AM", "", 0},
+		{"", "08:23 AM", 0},
+	}
+	for _, tt := range tests {
+		current := CurrentCondition{LocalObsDateTime: tt.local, ObservationTime: tt.utc}
+		if got := utcOffset(current); got != tt.want {
+			t.Errorf("utcOffset(%q, %q) = %s, want %s", tt.local, tt.utc, got, tt.want)
+		}
+	}
+}
+
+// openTestHistory opens a fresh history database for one test
+func openTestHistory(t *testing.T) *historyStore {
+	t.Helper()
+	s, err := openHistoryStore(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// recordLocal records an observation of tempC at location, at the UTC time
+// at, as wttr.in reports it for a location offset from UTC
+func recordLocal(t *testing.T, s *historyStore, location string, at time.Time, offset time.Duration, tempC int) {
+	t.Helper()
+	data := testWeatherData(location, tempC)
+	data.CurrentCondition[0].ObservationTime = at.Format("03:04 PM")
+	data.CurrentCondition[0].LocalObsDateTime = at.Add(offset).Format("2006-01-02 03:04 PM")
+	if err := s.record(context.Background(), location, at, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDailySummariesUseLocalDate(t *testing.T) {
+	s := openTestHistory(t)
+	ctx := context.Background()
+	tokyo := 9 * time.Hour
+
+	// 16:00 UTC on 1 May to 13:00 UTC on 2 May is all of 2 May in Tokyo
+	start := time.Date(2024, 5, 1, 16, 0, 0, 0, time.UTC)
+	for h := 0; h < 22; h++ {
+		recordLocal(t, s, "Tokyo", start.Add(time.Duration(h)*time.Hour), tokyo, 10+h%12)
+	}
+	// The hottest reading is on 3 May in Tokyo, though still 2 May in UTC
+	recordLocal(t, s, "Tokyo", time.Date(2024, 5, 2, 16, 0, 0, 0, time.UTC), tokyo, 30)
+
+	may2 := time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)
+	may3 := may2.AddDate(0, 0, 1)
+	summaries, err := s.dailySummaries(ctx, "Tokyo", may2, may3.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("got %d days, want 2: %+v", len(summaries), summaries)
+	}
+	if day := summaries[0]; !day.Date.Equal(may2) || day.Samples != 22 || day.MinTempC != 10 || day.MaxTempC != 21 {
+		t.Errorf("2 May = %+v", day)
+	}
+	if day := summaries[1]; !day.Date.Equal(may3) || day.Samples != 1 || day.MaxTempC != 30 {
+		t.Errorf("3 May = %+v", day)
+	}
+
+	// A range of one local day leaves out the neighbouring ones
+	summaries, err = s.dailySummaries(ctx, "Tokyo", may2, may3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].Samples != 22 {
+		t.Errorf("2 May alone = %+v", summaries)
+	}
+
+	rec, err := s.records(ctx, "Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.HighDate.Equal(may3) || !rec.LowDate.Equal(may2) {
+		t.Errorf("records dated %s and %s, want 3 and 2 May", rec.HighDate, rec.LowDate)
+	}
+	days := markRecords(summaries, rec)
+	if days[0].RecordHigh || !days[0].RecordLow {
+		t.Errorf("2 May marked high %v, low %v", days[0].RecordHigh, days[0].RecordLow)
+	}
+
+	today, err := s.localToday(ctx, "Tokyo", time.Date(2024, 5, 2, 20, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !today.Equal(may3) {
+		t.Errorf("today in Tokyo = %s, want 3 May", today)
+	}
+	today, err = s.localToday(ctx, "Nowhere", time.Date(2024, 5, 2, 20, 0, 0, 0, time.UTC))
+	if err != nil || !today.Equal(may2) {
+		t.Errorf("today without observations = %s, %v, want 2 May", today, err)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// The history page summarises recorded observations by day, with calendar
+// heatmaps of temperature and precipitation. Like the forecast chart, the
+// heatmaps use presentation attributes only so they can be inlined under
+// the page's CSP.
+
+const (
+	heatmapCell  = 13
+	heatmapGap   = 3
+	heatmapLeft  = 30
+	heatmapTop   = 18
+	heatmapEmpty = "#ebedf0"
+
+	recordHighColor = "#d63031"
+	recordLowColor  = "#0984e3"
+)
+
+// heatmapStop is one colour of a heatmap scale
+type heatmapStop struct {
+	Value float64
+	R     uint8
+	G     uint8
+	B     uint8
+}
+
+// Scales run from the first to the last stop and clamp outside them
+var (
+	temperatureScale = []heatmapStop{
+		{-20, 0x3b, 0x4c, 0xc0},
+		{0, 0x8d, 0xb0, 0xfe},
+		{10, 0xdd, 0xdc, 0xdc},
+		{20, 0xf4, 0x9a, 0x7b},
+		{35, 0xb4, 0x04, 0x26},
+	}
+	precipitationScale = []heatmapStop{
+		{0, 0xf1, 0xf2, 0xf6},
+		{5, 0x74, 0xb9, 0xff},
+		{20, 0x2d, 0x34, 0x96},
+	}
+)
+
+// scaleColor interpolates v on scale
+func scaleColor(scale []heatmapStop, v float64) string {
+	if v <= scale[0].Value {
+		s := scale[0]
+		return fmt.Sprintf("#%02x%02x%02x", s.R, s.G, s.B)
+	}
+	for i := 1; i < len(scale); i++ {
+		lo, hi := scale[i-1], scale[i]
+		if v > hi.Value {
+			continue
+		}
+		t := (v - lo.Value) / (hi.Value - lo.Value)
+		mix := func(a, b uint8) uint8 { return uint8(math.Round(float64(a) + t*(float64(b)-float64(a)))) }
+		return fmt.Sprintf("#%02x%02x%02x", mix(lo.R, hi.R), mix(lo.G, hi.G), mix(lo.B, hi.B))
+	}
+	s := scale[len(scale)-1]
+	return fmt.Sprintf("#%02x%02x%02x", s.R, s.G, s.B)
+}
+
+// HistoryDay is a DailySummary marked with any record it holds
+type HistoryDay struct {
+	DailySummary
+	RecordHigh bool
+	RecordLow  bool
+}
+
+// HistoryPageData is the data for the history page
+type HistoryPageData struct {
+	Location      string
+	From          string
+	To            string
+	Days          []HistoryDay
+	Records       *HistoryRecords
+	TempHeatmap   template.HTML
+	PrecipHeatmap template.HTML
+	Error         string
+	Nonce         string
+}
+
+// historyPageHandler serves GET /history/{location}
+func (app *App) historyPageHandler(w http.ResponseWriter, r *http.Request) {
+	if app.history == nil {
+		writeText(w, http.StatusNotFound, ErrHistoryDisabled)
+		return
+	}
+	location := strings.TrimSpace(mux.Vars(r)["location"])
+	if location == "" {
+		writeText(w, http.StatusBadRequest, ErrEmptyLocation)
+		return
+	}
+
+	// Whole local days, ending with today at the location
+	today, err := app.history.localToday(r.Context(), location, time.Now())
+	if err != nil {
+		log.Printf("Error querying history for %q: %v", location, err)
+		writeText(w, http.StatusInternalServerError, ErrHistoryQuery)
+		return
+	}
+	from, to, err := historyRange(r, today.AddDate(0, 0, 1), HistoryPageDays*24*time.Hour)
+	if err != nil {
+		writeText(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	from, to = from.UTC().Truncate(24*time.Hour), to.UTC()
+	if to.Sub(from) > HistoryPageMaxDays*24*time.Hour {
+		writeText(w, http.StatusBadRequest, fmt.Sprintf("range must be at most %d days", HistoryPageMaxDays))
+		return
+	}
+
+	data := HistoryPageData{
+		Location: location,
+		From:     from.Format("2006-01-02"),
+		To:       to.Add(-time.Second).Format("2006-01-02"),
+		Nonce:    cspNonce(r),
+	}
+
+	summaries, err := app.history.dailySummaries(r.Context(), location, from, to)
+	if err == nil {
+		data.Records, err = app.history.records(r.Context(), location)
+	}
+	if err != nil {
+		log.Printf("Error querying history for %q: %v", location, err)
+		data.Error = ErrHistoryQuery
+	} else {
+		data.Days = markRecords(summaries, data.Records)
+		data.TempHeatmap = template.HTML(renderHeatmap(data.Days, from, to, "Mean temperature (°C)", temperatureScale,
+			func(d HistoryDay) (float64, string) {
+				return d.MeanTempC, fmt.Sprintf("%.1f°C (%.0f° to %.0f°)", d.MeanTempC, d.MinTempC, d.MaxTempC)
+			}))
+		data.PrecipHeatmap = template.HTML(renderHeatmap(data.Days, from, to, "Precipitation (mm)", precipitationScale,
+			func(d HistoryDay) (float64, string) {
+				return d.PrecipMM, fmt.Sprintf("%.1f mm", d.PrecipMM)
+			}))
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := app.tmpl.ExecuteTemplate(w, "history", data); err != nil {
+		log.Printf("Error executing history template: %v", err)
+		http.Error(w, ErrTemplateExecution, http.StatusInternalServerError)
+	}
+}
+
+// markRecords flags the days on which the all-time records were set
+func markRecords(summaries []DailySummary, rec *HistoryRecords) []HistoryDay {
+	days := make([]HistoryDay, len(summaries))
+	for i, s := range summaries {
+		days[i] = HistoryDay{DailySummary: s}
+		if rec == nil {
+			continue
+		}
+		days[i].RecordHigh = s.Date.Equal(rec.HighDate)
+		days[i].RecordLow = s.Date.Equal(rec.LowDate)
+	}
+	return days
+}
+
+// renderHeatmap draws one square per day of [from, to) in weekly columns,
+// Monday at the top. Days without observations are grey; record days are
+// outlined.
+func renderHeatmap(days []HistoryDay, from, to time.Time, title string, scale []heatmapStop, value func(HistoryDay) (float64, string)) string {
+	byDate := make(map[string]HistoryDay, len(days))
+	for _, d := range days {
+		byDate[d.Date.Format("2006-01-02")] = d
+	}
+
+	// Start the grid on the Monday of the first week
+	start := from.AddDate(0, 0, -((int(from.Weekday()) + 6) % 7))
+	weeks := int(to.Sub(start).Hours()/24+6) / 7
+	pitch := heatmapCell + heatmapGap
+	// Wide enough for the legend on short ranges
+	width := max(heatmapLeft+weeks*pitch, 240)
+	height := heatmapTop + 7*pitch + 22
+
+	var b svgBuilder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" font-family="%s" role="img" aria-label="%s">`,
+		width, height, width, template.HTMLEscapeString(widgetFontFamily), template.HTMLEscapeString(title))
+	fmt.Fprintf(&b, `<title>%s</title>`, template.HTMLEscapeString(title))
+
+	for i, label := range []string{"Mon", "", "Wed", "", "Fri", "", "Sun"} {
+		if label != "" {
+			b.text(label, heatmapLeft-6, float64(heatmapTop+i*pitch+heatmapCell-2), 10, false, chartTextColor, "end")
+		}
+	}
+
+	for day := from; day.Before(to); day = day.AddDate(0, 0, 1) {
+		week := int(day.Sub(start).Hours()/24) / 7
+		x := heatmapLeft + week*pitch
+		y := heatmapTop + ((int(day.Weekday())+6)%7)*pitch
+		// Label months where they start, and the first month if there is
+		// room before the next label
+		if day.Day() == 1 || (day.Equal(from) && day.AddDate(0, 0, 14).Month() == day.Month()) {
+			b.text(day.Format("Jan"), float64(x), heatmapTop-6, 10, false, chartTextColor, "start")
+		}
+
+		fill, stroke, label := heatmapEmpty, "", "no observations"
+		if d, ok := byDate[day.Format("2006-01-02")]; ok {
+			var v float64
+			v, label = value(d)
+			fill = scaleColor(scale, v)
+			switch {
+			case d.RecordHigh:
+				stroke, label = recordHighColor, label+", record high"
+			case d.RecordLow:
+				stroke, label = recordLowColor, label+", record low"
+			}
+		}
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" rx="2" fill="%s"`, x, y, heatmapCell, heatmapCell, fill)
+		if stroke != "" {
+			fmt.Fprintf(&b, ` stroke="%s" stroke-width="2"`, stroke)
+		}
+		fmt.Fprintf(&b, `><title>%s: %s</title></rect>`, day.Format("Mon 2 Jan 2006"), template.HTMLEscapeString(label))
+	}
+
+	// Legend along the scale's stops
+	lx, ly := float64(heatmapLeft), float64(heatmapTop+7*pitch+6)
+	for _, s := range scale {
+		fmt.Fprintf(&b, `<rect x="%g" y="%g" width="10" height="10" rx="2" fill="%s"/>`, lx, ly, scaleColor(scale, s.Value))
+		label := fmt.Sprintf("%g", s.Value)
+		b.text(label, lx+13, ly+9, 10, false, chartTextColor, "start")
+		lx += 13 + float64(len(label))*6 + 10
+	}
+	return b.close()
+}
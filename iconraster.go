@@ -0,0 +1,402 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/vector"
+)
+
+// A small rasterizer for the SVG subset used by weatherIcons: circle, line,
+// path (M, L, C and Z, absolute only), text and g elements with fill, stroke,
+// stroke-width and opacity. It lets PNG output reuse the same icons as the
+// HTML page.
+
+const (
+	iconViewBox    = 100
+	circleSegments = 48
+	curveSegments  = 16
+)
+
+type point struct{ X, Y float64 }
+
+// svgShape is one flattened element. Paths are lists of polylines.
+type svgShape struct {
+	Paths       [][]point
+	Closed      bool
+	Fill        color.Color
+	Stroke      color.Color
+	StrokeWidth float64
+
+	// Text elements
+	Text     string
+	TextAt   point
+	FontSize float64
+}
+
+// svgStyle is the presentation state inherited through g elements
+type svgStyle struct {
+	fill        color.Color
+	stroke      color.Color
+	strokeWidth float64
+	opacity     float64
+}
+
+// rasterIcons parses weatherIcons once
+var rasterIcons = sync.OnceValues(func() (map[string][]svgShape, error) {
+	icons := make(map[string][]svgShape, len(weatherIcons))
+	for name, src := range weatherIcons {
+		shapes, err := parseIconSVG(strings.NewReader(src))
+		if err != nil {
+			return nil, fmt.Errorf("icon %s: %w", name, err)
+		}
+		icons[name] = shapes
+	}
+	return icons, nil
+})
+
+// parseIconSVG flattens an icon into shapes in viewBox units
+func parseIconSVG(r io.Reader) ([]svgShape, error) {
+	dec := xml.NewDecoder(r)
+	stack := []svgStyle{{fill: color.Black, strokeWidth: 1, opacity: 1}}
+	var shapes []svgShape
+	var text *svgShape
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return shapes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			style, err := applyStyle(stack[len(stack)-1], t.Attr)
+			if err != nil {
+				return nil, fmt.Errorf("<%s>: %w", t.Name.Local, err)
+			}
+			stack = append(stack, style)
+
+			attr := func(name string) float64 {
+				v, _ := strconv.ParseFloat(attrValue(t.Attr, name), 64)
+				return v
+			}
+			shape := svgShape{
+				Fill:        withOpacity(style.fill, style.opacity),
+				Stroke:      withOpacity(style.stroke, style.opacity),
+				StrokeWidth: style.strokeWidth,
+			}
+			switch t.Name.Local {
+			case "circle":
+				shape.Paths = [][]point{circlePoints(point{attr("cx"), attr("cy")}, attr("r"))}
+				shape.Closed = true
+			case "line":
+				shape.Paths = [][]point{{{attr("x1"), attr("y1")}, {attr("x2"), attr("y2")}}}
+				shape.Fill = nil
+			case "path":
+				paths, closed, err := parsePathData(attrValue(t.Attr, "d"))
+				if err != nil {
+					return nil, fmt.Errorf("<path>: %w", err)
+				}
+				shape.Paths, shape.Closed = paths, closed
+			case "text":
+				shape.TextAt = point{attr("x"), attr("y")}
+				shape.FontSize = attr("font-size")
+				text = &shape
+				continue
+			default:
+				continue
+			}
+			shapes = append(shapes, shape)
+
+		case xml.CharData:
+			if text != nil {
+				text.Text += strings.TrimSpace(string(t))
+			}
+
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+			if t.Name.Local == "text" && text != nil {
+				shapes = append(shapes, *text)
+				text = nil
+			}
+		}
+	}
+}
+
+// applyStyle returns parent overridden by the presentation attributes
+func applyStyle(parent svgStyle, attrs []xml.Attr) (svgStyle, error) {
+	style := parent
+	var err error
+	for _, a := range attrs {
+		switch a.Name.Local {
+		case "fill":
+			style.fill, err = parseSVGColor(a.Value)
+		case "stroke":
+			style.stroke, err = parseSVGColor(a.Value)
+		case "stroke-width":
+			style.strokeWidth, err = strconv.ParseFloat(a.Value, 64)
+		case "opacity":
+			var opacity float64
+			opacity, err = strconv.ParseFloat(a.Value, 64)
+			style.opacity = parent.opacity * opacity
+		}
+		if err != nil {
+			return style, fmt.Errorf("%s: %w", a.Name.Local, err)
+		}
+	}
+	return style, nil
+}
+
+func attrValue(attrs []xml.Attr, name string) string {
+	for _, a := range attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// parseSVGColor parses #rgb, #rrggbb, "none" and the few named colours the
+// icons use
+func parseSVGColor(s string) (color.Color, error) {
+	switch s {
+	case "none":
+		return nil, nil
+	case "white":
+		return color.White, nil
+	case "black":
+		return color.Black, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 || hex == s {
+		return nil, fmt.Errorf("unsupported colour %q", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported colour %q", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+}
+
+// withOpacity scales c by opacity, keeping it premultiplied
+func withOpacity(c color.Color, opacity float64) color.Color {
+	if c == nil || opacity >= 1 {
+		return c
+	}
+	r, g, b, a := c.RGBA()
+	scale := func(v uint32) uint16 { return uint16(float64(v) * opacity) }
+	return color.RGBA64{scale(r), scale(g), scale(b), scale(a)}
+}
+
+// parsePathData flattens absolute M, L, C and Z commands
+func parsePathData(d string) ([][]point, bool, error) {
+	fields := strings.FieldsFunc(d, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\n' || r == '\t'
+	})
+	// Split commands glued to their first number, e.g. "M25"
+	var tokens []string
+	for _, f := range fields {
+		if c := f[0]; c >= 'A' && c <= 'Z' {
+			tokens = append(tokens, f[:1])
+			f = f[1:]
+		}
+		if f != "" {
+			tokens = append(tokens, f)
+		}
+	}
+
+	var paths [][]point
+	var current []point
+	closed := false
+	i := 0
+	next := func() (point, error) {
+		if i+1 >= len(tokens) {
+			return point{}, fmt.Errorf("path data ends early")
+		}
+		x, errX := strconv.ParseFloat(tokens[i], 64)
+		y, errY := strconv.ParseFloat(tokens[i+1], 64)
+		i += 2
+		if errX != nil || errY != nil {
+			return point{}, fmt.Errorf("bad coordinate %q %q", tokens[i-2], tokens[i-1])
+		}
+		return point{x, y}, nil
+	}
+
+	cmd := ""
+	for i < len(tokens) {
+		if c := tokens[i][0]; c >= 'A' && c <= 'Z' {
+			cmd = tokens[i]
+			i++
+		}
+		switch cmd {
+		case "M":
+			p, err := next()
+			if err != nil {
+				return nil, false, err
+			}
+			if len(current) > 0 {
+				paths = append(paths, current)
+			}
+			current = []point{p}
+			cmd = "L" // implicit lineto after the first pair
+		case "L":
+			p, err := next()
+			if err != nil {
+				return nil, false, err
+			}
+			current = append(current, p)
+		case "C":
+			var ctrl [3]point
+			for k := range ctrl {
+				p, err := next()
+				if err != nil {
+					return nil, false, err
+				}
+				ctrl[k] = p
+			}
+			if len(current) == 0 {
+				return nil, false, fmt.Errorf("curve without start point")
+			}
+			current = append(current, cubicPoints(current[len(current)-1], ctrl[0], ctrl[1], ctrl[2])...)
+		case "Z":
+			closed = true
+			cmd = "" // coordinates must not follow Z
+		default:
+			return nil, false, fmt.Errorf("unsupported path command %q", cmd)
+		}
+	}
+	if len(current) > 0 {
+		paths = append(paths, current)
+	}
+	return paths, closed, nil
+}
+
+// cubicPoints flattens a cubic Bézier, excluding its start point
+func cubicPoints(p0, p1, p2, p3 point) []point {
+	points := make([]point, 0, curveSegments)
+	for k := 1; k <= curveSegments; k++ {
+		t := float64(k) / curveSegments
+		mt := 1 - t
+		a, b, c, d := mt*mt*mt, 3*mt*mt*t, 3*mt*t*t, t*t*t
+		points = append(points, point{
+			a*p0.X + b*p1.X + c*p2.X + d*p3.X,
+			a*p0.Y + b*p1.Y + c*p2.Y + d*p3.Y,
+		})
+	}
+	return points
+}
+
+// circlePoints returns a closed polygon approximating a circle. Points run
+// anticlockwise on screen, the same winding as addSegment, so overlapping
+// stroke pieces never cancel out.
+func circlePoints(c point, r float64) []point {
+	points := make([]point, circleSegments)
+	for k := range points {
+		a := -2 * math.Pi * float64(k) / circleSegments
+		points[k] = point{c.X + r*math.Cos(a), c.Y + r*math.Sin(a)}
+	}
+	return points
+}
+
+// drawIcon rasterizes the named icon into dst at rect, which should be
+// square. drawText renders text elements centred on their position, since
+// text needs a font.
+func drawIcon(dst draw.Image, rect image.Rectangle, name string, drawText func(s string, at point, size float64, c color.Color)) error {
+	icons, err := rasterIcons()
+	if err != nil {
+		return err
+	}
+	shapes, ok := icons[name]
+	if !ok {
+		shapes = icons["default"]
+	}
+
+	scale := float64(rect.Dx()) / iconViewBox
+	toPx := func(p point) (float32, float32) {
+		return float32(p.X * scale), float32(p.Y * scale)
+	}
+	fill := func(c color.Color, add func(z *vector.Rasterizer)) {
+		z := vector.NewRasterizer(rect.Dx(), rect.Dy())
+		add(z)
+		z.Draw(dst, rect, image.NewUniform(c), image.Point{})
+	}
+
+	for _, shape := range shapes {
+		if shape.Text != "" {
+			if drawText != nil && shape.Fill != nil {
+				drawText(shape.Text, point{float64(rect.Min.X) + shape.TextAt.X*scale, float64(rect.Min.Y) + shape.TextAt.Y*scale}, shape.FontSize*scale, shape.Fill)
+			}
+			continue
+		}
+
+		if shape.Fill != nil && shape.Closed {
+			fill(shape.Fill, func(z *vector.Rasterizer) {
+				for _, path := range shape.Paths {
+					z.MoveTo(toPx(path[0]))
+					for _, p := range path[1:] {
+						z.LineTo(toPx(p))
+					}
+					z.ClosePath()
+				}
+			})
+		}
+
+		if shape.Stroke != nil && shape.StrokeWidth > 0 {
+			half := shape.StrokeWidth / 2
+			fill(shape.Stroke, func(z *vector.Rasterizer) {
+				for _, path := range shape.Paths {
+					pts := path
+					if shape.Closed {
+						pts = append(append([]point{}, path...), path[0])
+					}
+					for k := 0; k+1 < len(pts); k++ {
+						addSegment(z, pts[k], pts[k+1], half, toPx)
+					}
+					// Round joins and caps
+					for _, p := range pts {
+						addPolygon(z, circlePoints(p, half), toPx)
+					}
+				}
+			})
+		}
+	}
+	return nil
+}
+
+// addSegment adds a rectangle of half-width half around a-b
+func addSegment(z *vector.Rasterizer, a, b point, half float64, toPx func(point) (float32, float32)) {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return
+	}
+	nx, ny := -dy/length*half, dx/length*half
+	addPolygon(z, []point{
+		{a.X + nx, a.Y + ny},
+		{b.X + nx, b.Y + ny},
+		{b.X - nx, b.Y - ny},
+		{a.X - nx, a.Y - ny},
+	}, toPx)
+}
+
+func addPolygon(z *vector.Rasterizer, pts []point, toPx func(point) (float32, float32)) {
+	z.MoveTo(toPx(pts[0]))
+	for _, p := range pts[1:] {
+		z.LineTo(toPx(p))
+	}
+	z.ClosePath()
+}
@@ -140,29 +140,34 @@ var weatherIcons = map[string]string{
 
 // getWeatherIcon returns the appropriate SVG icon based on weather condition
 func getWeatherIcon(condition string) string {
+	return weatherIcons[weatherIconName(condition)]
+}
+
+// weatherIconName returns the weatherIcons key for a weather condition
+func weatherIconName(condition string) string {
 	lowerCondition := strings.ToLower(condition)
 
 	if strings.Contains(lowerCondition, "clear") || strings.Contains(lowerCondition, "sunny") {
-		return weatherIcons["clear"]
+		return "clear"
 	} else if strings.Contains(lowerCondition, "partly") || strings.Contains(lowerCondition, "partial") {
-		return weatherIcons["partlyCloudy"]
+		return "partlyCloudy"
 	} else if strings.Contains(lowerCondition, "overcast") {
-		return weatherIcons["overcast"]
+		return "overcast"
 	} else if strings.Contains(lowerCondition, "cloudy") || strings.Contains(lowerCondition, "cloud") {
-		return weatherIcons["cloudy"]
+		return "cloudy"
 	} else if strings.Contains(lowerCondition, "thunder") || strings.Contains(lowerCondition, "storm") {
-		return weatherIcons["thunderstorm"]
+		return "thunderstorm"
 	} else if strings.Contains(lowerCondition, "heavy rain") || strings.Contains(lowerCondition, "downpour") {
-		return weatherIcons["heavyRain"]
+		return "heavyRain"
 	} else if strings.Contains(lowerCondition, "rain") || strings.Contains(lowerCondition, "shower") || strings.Contains(lowerCondition, "drizzle") {
-		return weatherIcons["rain"]
+		return "rain"
 	} else if strings.Contains(lowerCondition, "snow") || strings.Contains(lowerCondition, "blizzard") {
-		return weatherIcons["snow"]
+		return "snow"
 	} else if strings.Contains(lowerCondition, "fog") || strings.Contains(lowerCondition, "mist") || strings.Contains(lowerCondition, "haze") {
-		return weatherIcons["fog"]
+		return "fog"
 	} else if strings.Contains(lowerCondition, "wind") {
-		return weatherIcons["wind"]
+		return "wind"
 	}
 
-	return weatherIcons["default"]
+	return "default"
 }
@@ -3,16 +3,21 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"net/url"
+	"path"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 )
 
 // WeatherData represents the structure from wttr.in API
@@ -31,7 +36,10 @@ type CurrentCondition struct {
 	WindspeedKmph string `json:"windspeedKmph"`
 	Winddir16Point string `json:"winddir16Point"`
 	Visibility  string `json:"visibility"`
+	PrecipMM    string `json:"precipMM"`
 	WeatherDesc []WeatherDesc `json:"weatherDesc"`
+	ObservationTime  string `json:"observation_time"`
+	LocalObsDateTime string `json:"localObsDateTime"`
 }
 
 type WeatherDesc struct {
@@ -52,14 +60,32 @@ type Country struct {
 }
 
 type Weather struct {
-	Date     string   `json:"date"`
-	MaxtempC string   `json:"maxtempC"`
-	MintempC string   `json:"mintempC"`
-	Hourly   []Hourly `json:"hourly"`
+	Date      string      `json:"date"`
+	MaxtempC  string      `json:"maxtempC"`
+	MintempC  string      `json:"mintempC"`
+	MaxtempF  string      `json:"maxtempF"`
+	MintempF  string      `json:"mintempF"`
+	Astronomy []Astronomy `json:"astronomy"`
+	Hourly    []Hourly    `json:"hourly"`
+}
+
+type Astronomy struct {
+	MoonPhase string `json:"moon_phase"`
+	Sunrise   string `json:"sunrise"`
+	Sunset    string `json:"sunset"`
 }
 
 type Hourly struct {
-	WeatherDesc []WeatherDesc `json:"weatherDesc"`
+	Time          string        `json:"time"`
+	TempC         string        `json:"tempC"`
+	TempF         string        `json:"tempF"`
+	FeelsLikeC    string        `json:"FeelsLikeC"`
+	FeelsLikeF    string        `json:"FeelsLikeF"`
+	WeatherDesc   []WeatherDesc `json:"weatherDesc"`
+	ChanceOfRain  string        `json:"chanceofrain"`
+	ChanceOfSnow  string        `json:"chanceofsnow"`
+	WindspeedKmph string        `json:"windspeedKmph"`
+	PrecipMM      string        `json:"precipMM"`
 }
 
 // Template data structure
@@ -73,8 +99,12 @@ type PageData struct {
 	Wind            string
 	Visibility      string
 	Forecast        []ForecastDay
+	Chart           template.HTML
 	Error          string
 	HasData        bool
+	Nonce          string
+	CSRFToken      string
+	Query          string
 }
 
 type ForecastDay struct {
@@ -86,14 +116,34 @@ type ForecastDay struct {
 
 // App holds the application configuration and dependencies
 type App struct {
+	config *Config
 	tmpl   *template.Template
 	client *http.Client
+
+	metrics         *metricsRegistry
+	clientLimiter   *rateLimiter
+	upstreamLimiter *rateLimiter
+	apiKeys         *apiKeyStore
+	poller          *weatherPoller
+	upgrader        *websocket.Upgrader
+	alerts          *alertEngine
+	bots            *chatBots
+	history         *historyStore
+	feeds           *feedStore
+
+	// Background workers run under ctx and are cancelled on shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	shutdownMu    sync.Mutex
+	shutdownHooks []func(context.Context) error
 }
 
 // NewApp creates a new application instance with proper configuration
-func NewApp() (*App, error) {
-	// Parse template once at startup
-	tmpl, err := template.New("weather").Parse(htmlTemplate)
+func NewApp(cfg *Config) (*App, error) {
+	// Parse templates once at startup
+	tmpl, err := parseTemplates()
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse template: %w", err)
 	}
@@ -103,57 +153,314 @@ func NewApp() (*App, error) {
 		Timeout: APITimeout,
 	}
 
-	return &App{
-		tmpl:   tmpl,
-		client: client,
-	}, nil
+	ctx, cancel := context.WithCancel(context.Background())
+
+	app := &App{
+		config:   cfg,
+		tmpl:     tmpl,
+		client:   client,
+		metrics:  newMetricsRegistry(),
+		upgrader: newUpgrader(cfg.WebSocket),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	app.setupRateLimiting()
+	app.setupPoller()
+	if err := app.setupAPIKeys(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to load API keys: %w", err)
+	}
+	if err := app.setupAlerts(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to set up alerts: %w", err)
+	}
+	if err := app.setupWebhooks(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to set up webhooks: %w", err)
+	}
+	if err := app.setupDigest(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to set up digest: %w", err)
+	}
+	if err := app.setupBots(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to set up bots: %w", err)
+	}
+	if err := app.setupMQTT(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to set up MQTT: %w", err)
+	}
+	if err := app.setupHistory(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to set up history: %w", err)
+	}
+	if err := app.setupFeeds(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to set up feeds: %w", err)
+	}
+
+	return app, nil
 }
 
-func main() {
-	app, err := NewApp()
+// parseTemplates parses the page templates and the shared styles
+func parseTemplates() (*template.Template, error) {
+	tmpl, err := template.New("weather").Parse(htmlTemplate)
 	if err != nil {
-		log.Fatalf("Failed to initialize application: %v", err)
+		return nil, err
 	}
+	for name, text := range map[string]string{
+		"base-styles":  baseStyles,
+		"alerts":       alertsTemplate,
+		"embed":        embedTemplate,
+		"history":      historyTemplate,
+		"verification": verificationTemplate,
+	} {
+		if _, err := tmpl.New(name).Parse(text); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return tmpl, nil
+}
+
+// goBackground runs fn in a goroutine tied to the application lifetime.
+// fn must return once ctx is cancelled.
+func (app *App) goBackground(fn func(ctx context.Context)) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+		fn(app.ctx)
+	}()
+}
+
+// onShutdown registers a hook that runs after background workers have stopped,
+// e.g. to flush a cache to disk
+func (app *App) onShutdown(hook func(context.Context) error) {
+	app.shutdownMu.Lock()
+	defer app.shutdownMu.Unlock()
+	app.shutdownHooks = append(app.shutdownHooks, hook)
+}
 
+// Shutdown cancels background workers, waits for them to exit and runs the
+// registered shutdown hooks, giving up when ctx expires
+func (app *App) Shutdown(ctx context.Context) error {
+	app.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("background workers did not stop: %w", ctx.Err())
+	}
+
+	app.shutdownMu.Lock()
+	hooks := app.shutdownHooks
+	app.shutdownMu.Unlock()
+
+	var errs []error
+	for _, hook := range hooks {
+		if err := hook(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// routes builds the HTTP router
+func (app *App) routes() http.Handler {
 	r := mux.NewRouter()
 	
 	// Serve static files (CSS, etc.)
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir(StaticPath))))
 	
 	// Routes
-	r.HandleFunc("/", app.homeHandler).Methods("GET")
-	r.HandleFunc("/weather", app.weatherHandler).Methods("POST")
+	r.Handle("/", app.rateLimit(http.HandlerFunc(app.homeHandler))).Methods("GET")
+	r.Handle("/weather", app.rateLimit(csrfProtect(http.HandlerFunc(app.weatherHandler)))).Methods("POST")
+	r.Handle("/metrics", app.adminAuth(app.metrics)).Methods("GET")
 	
-	fmt.Printf("Server starting on %s...\n", ServerPort)
-	log.Fatal(http.ListenAndServe(ServerPort, r))
+	// JSON API
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.Handle("/weather/{location}", app.apiAuth(http.HandlerFunc(app.apiWeatherHandler))).Methods("GET")
+	api.Handle("/ws", app.apiAuth(http.HandlerFunc(app.websocketHandler))).Methods("GET")
+	api.Handle("/history/{location}", app.apiAuth(http.HandlerFunc(app.apiHistoryHandler))).Methods("GET")
+	api.Handle("/verification", app.apiAuth(http.HandlerFunc(app.apiVerificationHandler))).Methods("GET")
+	
+	// Live updates
+	r.Handle("/events/weather/{location}", app.rateLimit(http.HandlerFunc(app.eventsHandler))).Methods("GET")
+	
+	// Alerts
+	r.HandleFunc("/alerts", app.alertsHandler).Methods("GET")
+	r.Handle("/alerts/{id}/ack", csrfProtect(http.HandlerFunc(app.acknowledgeAlertHandler))).Methods("POST")
+	
+	// Chat integrations. These are signed by the platform and arrive from
+	// its shared addresses, so the per-client limit does not apply.
+	if app.config.Slack.SigningSecret != "" {
+		r.HandleFunc("/integrations/slack/command", app.slackCommandHandler).Methods("POST")
+		r.HandleFunc("/integrations/slack/interactive", app.slackInteractiveHandler).Methods("POST")
+	}
+	if app.bots != nil && app.bots.messengers[PlatformTelegram] != nil {
+		r.HandleFunc("/integrations/telegram/webhook", app.telegramWebhookHandler).Methods("POST")
+	}
+	if app.bots != nil && app.bots.discord != nil {
+		r.HandleFunc("/integrations/discord/interactions", app.discordInteractionHandler).Methods("POST")
+	}
+	
+	r.Handle("/admin/usage", app.adminAuth(http.HandlerFunc(app.apiUsageHandler))).Methods("GET")
+	
+	// Embeddable page
+	r.Handle("/embed/{location}", app.rateLimit(http.HandlerFunc(app.embedHandler))).Methods("GET")
+	
+	// Observation history
+	r.Handle("/history/{location}", app.rateLimit(http.HandlerFunc(app.historyPageHandler))).Methods("GET")
+	r.Handle("/verification", app.rateLimit(http.HandlerFunc(app.verificationHandler))).Methods("GET")
+	
+	// Data exports
+	r.HandleFunc("/export/schema.json", app.exportSchemaHandler).Methods("GET")
+	r.Handle("/export/{location}.{format:csv|jsonl|parquet}", app.rateLimit(http.HandlerFunc(app.exportHandler))).Methods("GET")
+	
+	// Calendar feeds
+	r.Handle("/calendar/{location}.ics", app.rateLimit(http.HandlerFunc(app.calendarHandler))).Methods("GET")
+	
+	// Atom feeds
+	r.Handle("/feed/{location}.atom", app.rateLimit(http.HandlerFunc(app.feedHandler))).Methods("GET")
+	
+	// Images
+	r.Handle("/chart/{location}.svg", app.rateLimit(http.HandlerFunc(app.chartHandler))).Methods("GET")
+	r.Handle("/card/{location}.png", app.rateLimit(http.HandlerFunc(app.cardHandler))).Methods("GET")
+	r.Handle("/widget/{location}.svg", app.rateLimit(http.HandlerFunc(app.widgetHandler))).Methods("GET")
+	
+	// Catch-all location pages, registered last so they never shadow the
+	// routes above
+	r.Handle("/{location}", app.rateLimit(http.HandlerFunc(app.locationHandler))).Methods("GET")
+	
+	r.Use(securityHeaders)
+	
+	return r
+}
+
+func main() {
+	configPath := flag.String("config", "", "path to JSON config file")
+	flag.Parse()
+
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	app, err := NewApp(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize application: %v", err)
+	}
+
+	servers, err := buildServers(app, app.routes())
+	if err != nil {
+		log.Fatalf("Failed to configure server: %v", err)
+	}
+
+	if err := serve(app, servers...); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func (app *App) homeHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := formatParam(r); ok {
+		app.formatHandler(w, r, DefaultLocation)
+		return
+	}
+	if wantsTerminal(r) {
+		app.terminalHandler(w, r, DefaultLocation)
+		return
+	}
 	data := PageData{HasData: false}
-	app.renderTemplate(w, data)
+	app.renderTemplate(w, r, data)
 }
 
 func (app *App) weatherHandler(w http.ResponseWriter, r *http.Request) {
 	location := strings.TrimSpace(r.FormValue("location"))
+	app.renderWeather(w, r, location)
+}
+
+// fileExtension returns the extension of the last path segment when it
+// looks like a file name, e.g. "png" for /card/Oslo.png. Extensions are
+// short and lower case, so place names such as "St.Paul" have none.
+func fileExtension(p string) string {
+	ext := path.Ext(p)
+	if len(ext) < 2 || len(ext) > 6 {
+		return ""
+	}
+	for _, c := range ext[1:] {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return ""
+		}
+	}
+	return ext[1:]
+}
+
+// isFileProbe reports whether a /{location} path names a file or dotfile,
+// as requested by browsers, crawlers and scanners (/favicon.ico, /.env,
+// /wp-login.php), rather than a place. These are never looked up upstream.
+func isFileProbe(location string) bool {
+	return strings.HasPrefix(location, ".") || fileExtension(location) != ""
+}
+
+// locationHandler serves GET /{location} as a page, as text for terminal
+// clients or as a one-line ?format= string
+func (app *App) locationHandler(w http.ResponseWriter, r *http.Request) {
+	location := strings.TrimSpace(mux.Vars(r)["location"])
+	if isFileProbe(location) {
+		http.NotFound(w, r)
+		return
+	}
+	if _, ok := formatParam(r); ok {
+		app.formatHandler(w, r, location)
+		return
+	}
+	if wantsTerminal(r) {
+		app.terminalHandler(w, r, location)
+		return
+	}
+	app.renderWeather(w, r, location)
+}
+
+// renderWeather fetches location and renders the weather page
+func (app *App) renderWeather(w http.ResponseWriter, r *http.Request, location string) {
 	if location == "" {
 		data := PageData{Error: ErrEmptyLocation, HasData: false}
-		app.renderTemplate(w, data)
+		app.renderTemplate(w, r, data)
 		return
 	}
 
 	weatherData, err := app.fetchWeatherData(r.Context(), location)
+	if errors.Is(err, errUpstreamRateLimited) {
+		app.writeRateLimited(w, r, upstreamRetryAfter(err))
+		return
+	}
 	if err != nil {
 		log.Printf("Error fetching weather data for %q: %v", location, err)
 		data := PageData{Error: ErrFetchWeatherData, HasData: false}
-		app.renderTemplate(w, data)
+		app.renderTemplate(w, r, data)
 		return
 	}
 
 	data := app.processWeatherData(weatherData)
-	app.renderTemplate(w, data)
+	data.Query = location
+	if data.HasData {
+		// The chart has one temperature axis, so it follows ?units=
+		imperial, _ := chartUnits(r)
+		data.Chart = template.HTML(renderForecastChart(forecastDays(weatherData.Weather), imperial))
+		app.recordServedForecast(r.Context(), location, weatherData)
+	}
+	app.renderTemplate(w, r, data)
 }
 
 func (app *App) fetchWeatherData(ctx context.Context, location string) (*WeatherData, error) {
+	if ok, wait := app.allowUpstream(); !ok {
+		return nil, &upstreamLimitError{wait: wait}
+	}
+
 	encodedLocation := url.QueryEscape(location)
 	apiURL := fmt.Sprintf(WeatherAPIURL, encodedLocation)
 	
@@ -250,26 +557,14 @@ func (app *App) processForecast(weatherData []Weather) []ForecastDay {
 			break
 		}
 		
-		dayName := "Today"
-		if i > 0 {
-			date, err := time.Parse("2006-01-02", day.Date)
-			if err == nil {
-				dayName = date.Format("Mon")
-			}
-		}
+		dayName := forecastDayName(day.Date, i)
 		
 		maxTemp, _ := strconv.Atoi(day.MaxtempC)
 		minTemp, _ := strconv.Atoi(day.MintempC)
 		temp := fmt.Sprintf("%d° / %d°", maxTemp, minTemp)
 		
 		// Get weather condition from hourly data (midday)
-		condition := ""
-		if len(day.Hourly) > 0 {
-			midIndex := len(day.Hourly) / 2
-			if len(day.Hourly[midIndex].WeatherDesc) > 0 {
-				condition = day.Hourly[midIndex].WeatherDesc[0].Value
-			}
-		}
+		condition := middayDescription(day)
 		
 		forecast = append(forecast, ForecastDay{
 			Day:         dayName,
@@ -282,8 +577,39 @@ func (app *App) processForecast(weatherData []Weather) []ForecastDay {
 	return forecast
 }
 
-func (app *App) renderTemplate(w http.ResponseWriter, data PageData) {
+// forecastDayName names the i-th forecast day: "Today", then weekdays
+func forecastDayName(date string, i int) string {
+	if i == 0 {
+		return "Today"
+	}
+	if t, err := time.Parse("2006-01-02", date); err == nil {
+		return t.Format("Mon")
+	}
+	return "Today"
+}
+
+// middayDescription returns the condition from the middle hourly entry
+func middayDescription(day Weather) string {
+	if len(day.Hourly) == 0 {
+		return ""
+	}
+	midIndex := len(day.Hourly) / 2
+	if len(day.Hourly[midIndex].WeatherDesc) == 0 {
+		return ""
+	}
+	return day.Hourly[midIndex].WeatherDesc[0].Value
+}
+
+func (app *App) renderTemplate(w http.ResponseWriter, r *http.Request, data PageData) {
+	app.renderTemplateStatus(w, r, http.StatusOK, data)
+}
+
+// renderTemplateStatus renders the page with a non-200 status code
+func (app *App) renderTemplateStatus(w http.ResponseWriter, r *http.Request, status int, data PageData) {
+	data.Nonce = cspNonce(r)
+	data.CSRFToken = csrfToken(w, r)
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
 	
 	if err := app.tmpl.Execute(w, data); err != nil {
 		log.Printf("Error executing template: %v", err)
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testWeatherData returns a three-day forecast for area in wttr.in's j1
+// shape, dated from today
+func testWeatherData(area string, tempC int) *WeatherData {
+	data := &WeatherData{
+		CurrentCondition: []CurrentCondition{{
+			TempC:          strconv.Itoa(tempC),
+			TempF:          strconv.Itoa(tempC*9/5 + 32),
+			FeelsLikeC:     strconv.Itoa(tempC - 2),
+			FeelsLikeF:     strconv.Itoa((tempC-2)*9/5 + 32),
+			Humidity:       "81",
+			WindspeedKmph:  "15",
+			Winddir16Point: "WSW",
+			Visibility:     "10",
+			PrecipMM:       "0.2",
+			WeatherDesc:    []WeatherDesc{{Value: "Partly cloudy"}},
+		}},
+		NearestArea: []NearestArea{{
+			AreaName: []AreaName{{Value: area}},
+			Country:  []Country{{Value: "Norway"}},
+		}},
+	}
+	today := time.Now().UTC()
+	for d := 0; d < 3; d++ {
+		day := Weather{
+			Date:      today.AddDate(0, 0, d).Format("2006-01-02"),
+			MaxtempC:  strconv.Itoa(tempC + 2 + d),
+			MintempC:  strconv.Itoa(tempC - 6 + d),
+			MaxtempF:  strconv.Itoa((tempC+2+d)*9/5 + 32),
+			MintempF:  strconv.Itoa((tempC-6+d)*9/5 + 32),
+			Astronomy: []Astronomy{{MoonPhase: "Waxing Gibbous", Sunrise: "07:30 AM", Sunset: "06:10 PM"}},
+		}
+		for h := 0; h < 8; h++ {
+			day.Hourly = append(day.Hourly, Hourly{
+				Time:          strconv.Itoa(h * 300),
+				TempC:         strconv.Itoa(tempC - 4 + h),
+				TempF:         strconv.Itoa((tempC-4+h)*9/5 + 32),
+				FeelsLikeC:    strconv.Itoa(tempC - 6 + h),
+				FeelsLikeF:    strconv.Itoa((tempC-6+h)*9/5 + 32),
+				WeatherDesc:   []WeatherDesc{{Value: "Partly cloudy"}},
+				ChanceOfRain:  "20",
+				ChanceOfSnow:  "0",
+				WindspeedKmph: "15",
+				PrecipMM:      "0.1",
+			})
+		}
+		data.Weather = append(data.Weather, day)
+	}
+	return data
+}
+
+// redirectTransport sends every request to a test server instead
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = t.target.Scheme, t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// fakeUpstream points the app's wttr.in client at handler. The location
+// asked for is the last path segment of the request.
+func fakeUpstream(t *testing.T, app *App, handler http.Handler) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	target, _ := url.Parse(srv.URL)
+	app.client = &http.Client{Timeout: APITimeout, Transport: redirectTransport{target: target}}
+}
+
+// serveWeather answers every upstream request with data
+func serveWeather(data *WeatherData) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metricSample is one value of a metric with optional labels
+type metricSample struct {
+	Labels map[string]string
+	Value  float64
+}
+
+// metric describes a metric whose samples are collected on each scrape
+type metric struct {
+	name    string
+	help    string
+	kind    string // "counter" or "gauge"
+	collect func() []metricSample
+}
+
+// metricsRegistry collects metrics from the components that own them and
+// writes them in the Prometheus text format
+type metricsRegistry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{metrics: make(map[string]metric)}
+}
+
+// register adds a metric; registering the same name again replaces it
+func (m *metricsRegistry) register(name, kind, help string, collect func() []metricSample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metrics[name] = metric{name: name, help: help, kind: kind, collect: collect}
+}
+
+// gauge registers a single unlabelled gauge
+func (m *metricsRegistry) gauge(name, help string, value func() float64) {
+	m.register(name, "gauge", help, func() []metricSample {
+		return []metricSample{{Value: value()}}
+	})
+}
+
+// ServeHTTP writes all metrics sorted by name
+func (m *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	names := make([]string, 0, len(m.metrics))
+	for name := range m.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, len(names))
+	for i, name := range names {
+		metrics[i] = m.metrics[name]
+	}
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, mt := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", mt.name, mt.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", mt.name, mt.kind)
+		for _, s := range mt.collect() {
+			fmt.Fprintf(w, "%s%s %g\n", mt.name, formatLabels(s.Labels), s.Value)
+		}
+	}
+}
+
+// labelEscaper escapes label values as the text format expects
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders labels as {k="v",...} in key order
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + `="` + labelEscaper.Replace(labels[k]) + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"golang.org/x/text/unicode/norm"
+)
+
+// mqttSensor describes one published value and its Home Assistant metadata
+type mqttSensor struct {
+	Field       string
+	Name        string
+	Unit        string
+	DeviceClass string
+	Value       func(CurrentCondition) string
+}
+
+// mqttSensors are published under <prefix>/<location>/<field>
+var mqttSensors = []mqttSensor{
+	{Field: "temperature", Name: "Temperature", Unit: "°C", DeviceClass: "temperature",
+		Value: func(c CurrentCondition) string { return c.TempC }},
+	{Field: "feels_like", Name: "Feels like", Unit: "°C", DeviceClass: "temperature",
+		Value: func(c CurrentCondition) string { return c.FeelsLikeC }},
+	{Field: "humidity", Name: "Humidity", Unit: "%", DeviceClass: "humidity",
+		Value: func(c CurrentCondition) string { return c.Humidity }},
+	{Field: "wind_speed", Name: "Wind speed", Unit: "km/h", DeviceClass: "wind_speed",
+		Value: func(c CurrentCondition) string { return c.WindspeedKmph }},
+	{Field: "wind_direction", Name: "Wind direction",
+		Value: func(c CurrentCondition) string { return c.Winddir16Point }},
+	{Field: "visibility", Name: "Visibility", Unit: "km", DeviceClass: "distance",
+		Value: func(c CurrentCondition) string { return c.Visibility }},
+	{Field: "condition", Name: "Condition",
+		Value: func(c CurrentCondition) string {
+			if len(c.WeatherDesc) == 0 {
+				return ""
+			}
+			return c.WeatherDesc[0].Value
+		}},
+}
+
+var topicUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// topicLetters spells out letters that do not decompose into an ASCII
+// letter and a combining mark
+var topicLetters = strings.NewReplacer(
+	"ß", "ss", "æ", "ae", "œ", "oe", "ø", "o", "ł", "l", "đ", "d", "ð", "d", "þ", "th", "ı", "i",
+)
+
+// topicSlug turns a location into a single MQTT topic level. Accents are
+// stripped, so "Zürich" becomes "zurich". Letters with no ASCII spelling
+// are dropped and a short hash of the location appended instead, so "東京"
+// and "大阪" get distinct slugs and no slug is ever empty.
+func topicSlug(location string) string {
+	lower := strings.ToLower(strings.TrimSpace(location))
+
+	var b strings.Builder
+	lossy := false
+	for _, r := range norm.NFKD.String(topicLetters.Replace(lower)) {
+		switch {
+		case r < utf8.RuneSelf:
+			b.WriteRune(r)
+		case unicode.Is(unicode.Mn, r):
+			// A combining accent
+		default:
+			lossy = lossy || unicode.IsLetter(r) || unicode.IsNumber(r)
+			b.WriteByte(' ')
+		}
+	}
+
+	slug := strings.Trim(topicUnsafe.ReplaceAllString(b.String(), "_"), "_")
+	if lossy || slug == "" {
+		sum := sha256.Sum256([]byte(lower))
+		hash := hex.EncodeToString(sum[:4])
+		if slug == "" {
+			return hash
+		}
+		slug += "_" + hash
+	}
+	return slug
+}
+
+// mqttPublisher periodically publishes current conditions for the
+// configured locations
+type mqttPublisher struct {
+	app       *App
+	cfg       MQTTConfig
+	locations []string
+	client    mqtt.Client
+	// connected is signalled on every (re)connect so a fresh cycle is
+	// published straight away
+	connected chan struct{}
+}
+
+// statusTopic carries "online"/"offline" and is the broker's last will
+func (p *mqttPublisher) statusTopic() string {
+	return p.cfg.TopicPrefix + "/status"
+}
+
+// newMQTTTLSConfig builds the TLS settings for ssl:// brokers
+func newMQTTTLSConfig(cfg MQTTTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mqtt ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in mqtt ca_file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load mqtt client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+func newMQTTPublisher(app *App, cfg MQTTConfig, locations []string) (*mqttPublisher, error) {
+	p := &mqttPublisher{app: app, cfg: cfg, locations: locations, connected: make(chan struct{}, 1)}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectTimeout(MQTTTimeout).
+		SetWill(p.statusTopic(), "offline", cfg.QoS, true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			log.Printf("MQTT: connected to %s", cfg.Broker)
+			// Discovery and status are re-sent on every (re)connect in case
+			// the broker lost its retained messages
+			ctx := context.Background()
+			p.publish(ctx, p.statusTopic(), "online", true)
+			if cfg.Discovery.Enabled {
+				p.publishDiscovery(ctx)
+			}
+			select {
+			case p.connected <- struct{}{}:
+			default:
+			}
+		}).
+		SetConnectionLostHandler(func(c mqtt.Client, err error) {
+			log.Printf("MQTT: connection lost: %v", err)
+		})
+
+	if cfg.TLS.Enabled {
+		tlsConfig, err := newMQTTTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	p.client = mqtt.NewClient(opts)
+	return p, nil
+}
+
+// publish sends payload without blocking the caller for longer than the
+// publish timeout, or at all once ctx is cancelled
+func (p *mqttPublisher) publish(ctx context.Context, topic, payload string, retained bool) {
+	token := p.client.Publish(topic, p.cfg.QoS, retained, payload)
+	timer := time.NewTimer(MQTTTimeout)
+	defer timer.Stop()
+	select {
+	case <-token.Done():
+	case <-ctx.Done():
+		return
+	case <-timer.C:
+		log.Printf("MQTT: timed out publishing %s", topic)
+		return
+	}
+	if err := token.Error(); err != nil {
+		log.Printf("MQTT: error publishing %s: %v", topic, err)
+	}
+}
+
+// publishDiscovery announces every sensor to Home Assistant
+func (p *mqttPublisher) publishDiscovery(ctx context.Context) {
+	for _, location := range p.locations {
+		slug := topicSlug(location)
+		device := map[string]interface{}{
+			"identifiers":  []string{"wttr_app_" + slug},
+			"name":         "Weather " + location,
+			"manufacturer": "wttr-app",
+			"model":        "wttr.in",
+		}
+
+		for _, s := range mqttSensors {
+			uniqueID := "wttr_app_" + slug + "_" + s.Field
+			config := map[string]interface{}{
+				"name":               s.Name,
+				"unique_id":          uniqueID,
+				"state_topic":        p.cfg.TopicPrefix + "/" + slug + "/" + s.Field,
+				"availability_topic": p.statusTopic(),
+				"device":             device,
+			}
+			if s.Unit != "" {
+				config["unit_of_measurement"] = s.Unit
+				config["state_class"] = "measurement"
+			}
+			if s.DeviceClass != "" {
+				config["device_class"] = s.DeviceClass
+			}
+
+			payload, err := json.Marshal(config)
+			if err != nil {
+				log.Printf("MQTT: error encoding discovery config: %v", err)
+				continue
+			}
+			topic := fmt.Sprintf("%s/sensor/%s/config", p.cfg.Discovery.Prefix, uniqueID)
+			p.publish(ctx, topic, string(payload), true)
+		}
+	}
+}
+
+// publishConditions fetches and publishes each location once. The cycle is
+// skipped while the broker is unreachable rather than queueing stale values.
+func (p *mqttPublisher) publishConditions(ctx context.Context) {
+	if !p.client.IsConnectionOpen() {
+		return
+	}
+	for _, location := range p.locations {
+		if ctx.Err() != nil {
+			return
+		}
+		data, err := p.app.fetchWeatherData(ctx, location)
+		if err != nil {
+			log.Printf("MQTT: error fetching %q: %v", location, err)
+			continue
+		}
+		if len(data.CurrentCondition) == 0 {
+			continue
+		}
+
+		current := data.CurrentCondition[0]
+		slug := topicSlug(location)
+		for _, s := range mqttSensors {
+			if ctx.Err() != nil {
+				return
+			}
+			p.publish(ctx, p.cfg.TopicPrefix+"/"+slug+"/"+s.Field, s.Value(current), p.cfg.Retain)
+		}
+	}
+}
+
+// run connects and publishes on every (re)connect and once per interval
+// until ctx is cancelled, then marks the publisher offline and disconnects
+// cleanly
+func (p *mqttPublisher) run(ctx context.Context) {
+	// With SetConnectRetry the token only completes once connected, so
+	// don't wait on it; the connect handler starts the first cycle
+	p.client.Connect()
+
+	ticker := time.NewTicker(p.cfg.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if p.client.IsConnectionOpen() {
+				// The run context is gone; the offline status still gets
+				// the publish timeout
+				p.publish(context.Background(), p.statusTopic(), "offline", true)
+			}
+			p.client.Disconnect(uint(MQTTDisconnectQuiesce.Milliseconds()))
+			return
+		case <-p.connected:
+		case <-ticker.C:
+		}
+		p.publishConditions(ctx)
+	}
+}
+
+// setupMQTT starts the publisher when a broker is configured
+func (app *App) setupMQTT() error {
+	cfg := app.config.MQTT
+	if cfg.Broker == "" {
+		return nil
+	}
+
+	locations := cfg.Locations
+	if len(locations) == 0 {
+		locations = app.config.Favourites
+	}
+	if len(locations) == 0 {
+		return fmt.Errorf("mqtt needs locations or favourites")
+	}
+
+	p, err := newMQTTPublisher(app, cfg, locations)
+	if err != nil {
+		return err
+	}
+	app.goBackground(p.run)
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+)
+
+func TestTopicSlug(t *testing.T) {
+	tests := []struct {
+		location, want string
+	}{
+		{"Oslo", "oslo"},
+		{" New York ", "new_york"},
+		{"St. Paul", "st_paul"},
+		{"Zürich", "zurich"},
+		{"São Paulo", "sao_paulo"},
+		{"Kraków", "krakow"},
+		{"Łódź", "lodz"},
+		{"Ærøskøbing", "aeroskobing"},
+		{"Großenhain", "grossenhain"},
+		{"İstanbul", "istanbul"},
+	}
+	for _, tt := range tests {
+		if got := topicSlug(tt.location); got != tt.want {
+			t.Errorf("topicSlug(%q) = %q, want %q", tt.location, got, tt.want)
+		}
+	}
+
+	// Names that cannot be spelled in ASCII still get distinct, valid levels
+	seen := make(map[string]string)
+	for _, location := range []string{"東京", "大阪", "Москва", "Киев", "Tokyo 東京", "Tokyo 大阪", "", "!!!"} {
+		slug := topicSlug(location)
+		if slug == "" || strings.ContainsAny(slug, "/+#") {
+			t.Errorf("topicSlug(%q) = %q, not a valid topic level", location, slug)
+		}
+		if other, ok := seen[slug]; ok {
+			t.Errorf("%q and %q share the slug %q", location, other, slug)
+		}
+		seen[slug] = location
+	}
+	if slug := topicSlug("Tokyo 東京"); !strings.HasPrefix(slug, "tokyo_") {
+		t.Errorf("topicSlug(\"Tokyo 東京\") = %q, want the ASCII part kept", slug)
+	}
+	if topicSlug("東京") != topicSlug(" 東京 ") {
+		t.Error("slug depends on surrounding whitespace")
+	}
+}
+
+// brokerRecorder keeps the latest payload the broker routed to each topic
+type brokerRecorder struct {
+	mu       sync.Mutex
+	payloads map[string]string
+}
+
+func (r *brokerRecorder) get(topic string) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	payload, ok := r.payloads[topic]
+	return payload, ok
+}
+
+func (r *brokerRecorder) topics() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	topics := make([]string, 0, len(r.payloads))
+	for topic := range r.payloads {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// startBroker runs an embedded MQTT broker on a local port, recording
+// everything published to it, and returns its address
+func startBroker(t *testing.T) (*brokerRecorder, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := mochi.New(&mochi.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.AddListener(listeners.NewNet("test", ln)); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := &brokerRecorder{payloads: make(map[string]string)}
+	err = server.Subscribe("#", 1, func(cl *mochi.Client, sub packets.Subscription, pk packets.Packet) {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		rec.payloads[pk.TopicName] = string(pk.Payload)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return rec, "tcp://" + ln.Addr().String()
+}
+
+func TestMQTTPublisher(t *testing.T) {
+	rec, broker := startBroker(t)
+
+	app := newTestApp(t, DefaultConfig())
+	fakeUpstream(t, app, serveWeather(testWeatherData("Zürich", 12)))
+
+	cfg := DefaultConfig().MQTT
+	cfg.Broker = broker
+	cfg.Discovery.Enabled = true
+	p, err := newMQTTPublisher(app, cfg, []string{"Zürich", "東京"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	tokyo := topicSlug("東京")
+	wantTopics := []string{
+		"weather/status",
+		"weather/zurich/temperature",
+		"weather/zurich/condition",
+		"weather/" + tokyo + "/temperature",
+		"homeassistant/sensor/wttr_app_zurich_temperature/config",
+		"homeassistant/sensor/wttr_app_" + tokyo + "_humidity/config",
+	}
+	waitFor(t, "the published messages", func() bool {
+		for _, topic := range wantTopics {
+			if _, ok := rec.get(topic); !ok {
+				return false
+			}
+		}
+		return true
+	})
+
+	for _, topic := range rec.topics() {
+		for _, level := range strings.Split(topic, "/") {
+			if level == "" {
+				t.Errorf("topic %q has an empty level", topic)
+			}
+		}
+	}
+	if got, _ := rec.get("weather/status"); got != "online" {
+		t.Errorf("status = %q, want online", got)
+	}
+	if got, _ := rec.get("weather/zurich/temperature"); got != "12" {
+		t.Errorf("temperature = %q, want 12", got)
+	}
+	if got, _ := rec.get("weather/zurich/condition"); got != "Partly cloudy" {
+		t.Errorf("condition = %q", got)
+	}
+
+	var discovery map[string]interface{}
+	config, _ := rec.get("homeassistant/sensor/wttr_app_zurich_temperature/config")
+	if err := json.Unmarshal([]byte(config), &discovery); err != nil {
+		t.Fatal(err)
+	}
+	if discovery["state_topic"] != "weather/zurich/temperature" || discovery["unit_of_measurement"] != "°C" {
+		t.Errorf("discovery config = %v", discovery)
+	}
+	if device, _ := discovery["device"].(map[string]interface{}); device["name"] != "Weather Zürich" {
+		t.Errorf("device = %v", discovery["device"])
+	}
+
+	cancel()
+	<-done
+	waitFor(t, "the offline status", func() bool {
+		status, _ := rec.get("weather/status")
+		return status == "offline"
+	})
+}
+
+func TestMQTTPublisherDisconnected(t *testing.T) {
+	app := newTestApp(t, DefaultConfig())
+	var fetched atomic.Int32
+	fakeUpstream(t, app, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched.Add(1)
+		serveWeather(testWeatherData("Oslo", 12))(w, r)
+	}))
+
+	cfg := DefaultConfig().MQTT
+	cfg.Broker = "tcp://" + freeAddr(t)
+	p, err := newMQTTPublisher(app, cfg, []string{"Oslo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a connection the cycle is skipped instead of queueing values
+	p.publishConditions(context.Background())
+	if n := fetched.Load(); n != 0 {
+		t.Errorf("fetched %d times while disconnected", n)
+	}
+
+	// A cancelled publish returns without waiting out the timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	p.publish(ctx, "weather/oslo/temperature", "12", false)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled publish took %s", elapsed)
+	}
+}
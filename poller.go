@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// weatherUpdate is the formatted current conditions pushed to live clients
+type weatherUpdate struct {
+	Query       string    `json:"query"`
+	Location    string    `json:"location"`
+	Temperature string    `json:"temperature"`
+	Description string    `json:"description"`
+	FeelsLike   string    `json:"feels_like"`
+	Humidity    string    `json:"humidity"`
+	Wind        string    `json:"wind"`
+	Visibility  string    `json:"visibility"`
+	Icon        string    `json:"icon"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// sameConditions reports whether two updates show the same weather
+func (u weatherUpdate) sameConditions(o weatherUpdate) bool {
+	u.UpdatedAt, o.UpdatedAt = time.Time{}, time.Time{}
+	return u == o
+}
+
+// newWeatherUpdate builds an update from a rendered page
+func newWeatherUpdate(query string, page PageData) weatherUpdate {
+	return weatherUpdate{
+		Query:       query,
+		Location:    page.Location,
+		Temperature: page.Temperature,
+		Description: page.Description,
+		FeelsLike:   page.FeelsLike,
+		Humidity:    page.Humidity,
+		Wind:        page.Wind,
+		Visibility:  page.Visibility,
+		Icon:        string(page.WeatherIcon),
+		UpdatedAt:   time.Now(),
+	}
+}
+
+// subscription receives updates for one location. The poller closes ch when
+// the subscriber falls behind or the poller stops. Failed fetches are
+// reported on errs, dropping the report if the last one is still unread.
+type subscription struct {
+	key  string
+	ch   chan weatherUpdate
+	errs chan error
+}
+
+// pollTarget is a location with at least one subscriber
+type pollTarget struct {
+	query string
+	subs  map[*subscription]struct{}
+	last  *weatherUpdate
+}
+
+// weatherPoller fetches each subscribed location once per interval no matter
+// how many clients follow it, and fans changes out to the subscribers
+type weatherPoller struct {
+	app      *App
+	interval time.Duration
+
+	mu      sync.Mutex
+	targets map[string]*pollTarget
+	stopped bool
+	wake    chan string
+}
+
+func newWeatherPoller(app *App, interval time.Duration) *weatherPoller {
+	return &weatherPoller{
+		app:      app,
+		interval: interval,
+		targets:  make(map[string]*pollTarget),
+		wake:     make(chan string, PollerWakeBuffer),
+	}
+}
+
+// locationKey normalises a location so "Oslo" and " oslo" share a target
+func locationKey(location string) string {
+	return strings.ToLower(strings.TrimSpace(location))
+}
+
+// subscribe follows location. The latest known update, if any, is delivered
+// immediately; a new location is fetched right away.
+func (p *weatherPoller) subscribe(location string) *subscription {
+	key := locationKey(location)
+	sub := &subscription{
+		key:  key,
+		ch:   make(chan weatherUpdate, SubscriberBuffer),
+		errs: make(chan error, 1),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.stopped {
+		close(sub.ch)
+		return sub
+	}
+
+	target, ok := p.targets[key]
+	if !ok {
+		target = &pollTarget{query: strings.TrimSpace(location), subs: make(map[*subscription]struct{})}
+		p.targets[key] = target
+		select {
+		case p.wake <- key:
+		default:
+		}
+	}
+	target.subs[sub] = struct{}{}
+	if target.last != nil {
+		sub.ch <- *target.last
+	}
+	return sub
+}
+
+// unsubscribe stops sub and forgets the location once nobody follows it
+func (p *weatherPoller) unsubscribe(sub *subscription) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	target, ok := p.targets[sub.key]
+	if !ok {
+		return
+	}
+	if _, ok := target.subs[sub]; !ok {
+		return
+	}
+	delete(target.subs, sub)
+	close(sub.ch)
+	if len(target.subs) == 0 {
+		delete(p.targets, sub.key)
+	}
+}
+
+// run polls until ctx is cancelled, then closes every subscription
+func (p *weatherPoller) run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	defer p.stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case key := <-p.wake:
+			p.poll(ctx, key)
+		case <-ticker.C:
+			for _, key := range p.keys() {
+				if ctx.Err() != nil {
+					return
+				}
+				p.poll(ctx, key)
+			}
+		}
+	}
+}
+
+// keys returns the currently followed locations
+func (p *weatherPoller) keys() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	keys := make([]string, 0, len(p.targets))
+	for key := range p.targets {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// poll fetches one location and broadcasts it if the conditions changed
+func (p *weatherPoller) poll(ctx context.Context, key string) {
+	p.mu.Lock()
+	target, ok := p.targets[key]
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	data, err := p.app.fetchWeatherData(ctx, target.query)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Poller: error fetching %q: %v", target.query, err)
+		p.fail(key, err)
+		return
+	}
+	page := p.app.processWeatherData(data)
+	if !page.HasData {
+		p.fail(key, errors.New(page.Error))
+		return
+	}
+	p.publish(key, newWeatherUpdate(target.query, page))
+}
+
+// fail tells the subscribers of key that the latest fetch failed
+func (p *weatherPoller) fail(key string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	target, ok := p.targets[key]
+	if !ok {
+		return
+	}
+	for sub := range target.subs {
+		select {
+		case sub.errs <- err:
+		default:
+		}
+	}
+}
+
+// publish records update for key and sends it to subscribers when it
+// differs from the previous one. Subscribers whose buffer is full are
+// disconnected rather than allowed to stall the poller.
+func (p *weatherPoller) publish(key string, update weatherUpdate) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	target, ok := p.targets[key]
+	if !ok {
+		return
+	}
+	if target.last != nil && target.last.sameConditions(update) {
+		return
+	}
+	target.last = &update
+
+	for sub := range target.subs {
+		select {
+		case sub.ch <- update:
+		default:
+			log.Printf("Poller: dropping slow subscriber for %q", target.query)
+			delete(target.subs, sub)
+			close(sub.ch)
+		}
+	}
+	if len(target.subs) == 0 {
+		delete(p.targets, key)
+	}
+}
+
+// stop closes all subscriptions so streaming handlers return
+func (p *weatherPoller) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopped = true
+	for key, target := range p.targets {
+		for sub := range target.subs {
+			close(sub.ch)
+		}
+		delete(p.targets, key)
+	}
+}
+
+// subscriberCount returns the number of live subscriptions
+func (p *weatherPoller) subscriberCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := 0
+	for _, target := range p.targets {
+		n += len(target.subs)
+	}
+	return n
+}
+
+// setupPoller starts the shared poller used by live-update endpoints
+func (app *App) setupPoller() {
+	app.poller = newWeatherPoller(app, app.config.Live.PollInterval.Duration)
+	app.goBackground(app.poller.run)
+
+	app.metrics.gauge("wttr_live_subscribers",
+		"Clients subscribed to live weather updates.", func() float64 {
+			return float64(app.poller.subscriberCount())
+		})
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errUpstreamRateLimited is returned when the global upstream budget is spent
+var errUpstreamRateLimited = errors.New("upstream rate limit exceeded")
+
+// upstreamLimitError carries how long until the upstream budget refills
+type upstreamLimitError struct {
+	wait time.Duration
+}
+
+func (e *upstreamLimitError) Error() string { return errUpstreamRateLimited.Error() }
+func (e *upstreamLimitError) Unwrap() error { return errUpstreamRateLimited }
+
+// upstreamRetryAfter extracts the wait from an upstream limit error
+func upstreamRetryAfter(err error) time.Duration {
+	var limitErr *upstreamLimitError
+	if errors.As(err, &limitErr) {
+		return limitErr.wait
+	}
+	return time.Minute
+}
+
+// tokenBucket is a classic token bucket refilled continuously at rate tokens
+// per second up to burst
+type tokenBucket struct {
+	mu       sync.Mutex
+	rate     float64
+	burst    float64
+	tokens   float64
+	last     time.Time
+	lastSeen time.Time
+}
+
+func newTokenBucket(perMinute, burst int) *tokenBucket {
+	now := time.Now()
+	return &tokenBucket{
+		rate:     float64(perMinute) / 60,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     now,
+		lastSeen: now,
+	}
+}
+
+// refill adds the tokens earned since the last call; caller holds mu
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// allow takes a token if one is available, otherwise it reports how long
+// until the next token
+func (b *tokenBucket) allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.refill(now)
+	b.lastSeen = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if b.rate <= 0 {
+		return false, time.Minute
+	}
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	return false, wait
+}
+
+// available returns the current number of whole tokens
+func (b *tokenBucket) available() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	return math.Floor(b.tokens)
+}
+
+// idleSince reports whether the bucket has not been used since t
+func (b *tokenBucket) idleSince(t time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastSeen.Before(t)
+}
+
+// rateLimiter holds a token bucket per key plus rejection counters
+type rateLimiter struct {
+	perMinute int
+	burst     int
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+
+	rejected atomic.Uint64
+}
+
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	return &rateLimiter{
+		perMinute: perMinute,
+		burst:     burst,
+		buckets:   make(map[string]*tokenBucket),
+	}
+}
+
+// allow checks the bucket for key, creating it on first use
+func (rl *rateLimiter) allow(key string) (bool, time.Duration) {
+	rl.mu.Lock()
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = newTokenBucket(rl.perMinute, rl.burst)
+		rl.buckets[key] = b
+	}
+	rl.mu.Unlock()
+
+	ok, wait := b.allow()
+	if !ok {
+		rl.rejected.Add(1)
+	}
+	return ok, wait
+}
+
+// tokens returns the tokens left for key; unknown keys have a full bucket
+func (rl *rateLimiter) tokens(key string) float64 {
+	rl.mu.Lock()
+	b, ok := rl.buckets[key]
+	rl.mu.Unlock()
+	if !ok {
+		return float64(rl.burst)
+	}
+	return b.available()
+}
+
+// size returns the number of tracked keys
+func (rl *rateLimiter) size() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return len(rl.buckets)
+}
+
+// cleanup periodically drops buckets that have been idle for longer than
+// idle, since a full bucket behaves the same as a fresh one
+func (rl *rateLimiter) cleanup(ctx context.Context, idle time.Duration) {
+	ticker := time.NewTicker(idle)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cutoff := time.Now().Add(-idle)
+			rl.mu.Lock()
+			for key, b := range rl.buckets {
+				if b.idleSince(cutoff) {
+					delete(rl.buckets, key)
+				}
+			}
+			rl.mu.Unlock()
+		}
+	}
+}
+
+// clientIP returns the address used as the rate-limit key. Forwarded headers
+// are only honoured when the server sits behind trustedHops proxies. Each
+// proxy appends to X-Forwarded-For, so the client address is trustedHops
+// entries from the right; anything further left was sent by the client and
+// cannot be trusted.
+func clientIP(r *http.Request, trustedHops int) string {
+	if trustedHops > 0 {
+		if hops := forwardedFor(r); len(hops) > 0 {
+			return hops[max(len(hops)-trustedHops, 0)]
+		}
+		if real := r.Header.Get("X-Real-IP"); real != "" {
+			return real
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// forwardedFor lists the X-Forwarded-For addresses across all header lines,
+// leftmost first
+func forwardedFor(r *http.Request) []string {
+	var hops []string
+	for _, line := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(line, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+// wantsJSON reports whether the client prefers a JSON response
+func wantsJSON(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/api/") ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// retryAfterSeconds rounds wait up to whole seconds for Retry-After
+func retryAfterSeconds(wait time.Duration) int {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+// wantsPage reports whether the client is a browser asking for an HTML page
+// rather than a feed, image or download
+func wantsPage(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html") &&
+		fileExtension(r.URL.Path) == "" && !wantsTerminal(r)
+}
+
+// writeRateLimited sends a 429 as JSON, as the HTML page for browsers or as
+// plain text
+func (app *App) writeRateLimited(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	secs := retryAfterSeconds(wait)
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+
+	if wantsJSON(r) {
+		writeJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+			"error":       ErrRateLimited,
+			"retry_after": secs,
+		})
+		return
+	}
+	if !wantsPage(r) {
+		writeText(w, http.StatusTooManyRequests, ErrRateLimited)
+		return
+	}
+
+	data := PageData{Error: ErrRateLimited, HasData: false}
+	app.renderTemplateStatus(w, r, http.StatusTooManyRequests, data)
+}
+
+// rateLimit applies the per-client limit to next
+func (app *App) rateLimit(next http.Handler) http.Handler {
+	if app.clientLimiter == nil {
+		return next
+	}
+	trustedHops := 0
+	if app.config.RateLimit.TrustProxyHeaders {
+		trustedHops = app.config.RateLimit.TrustedProxyHops
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := app.clientLimiter.allow(clientIP(r, trustedHops))
+		if !ok {
+			app.writeRateLimited(w, r, wait)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowUpstream takes a token from the global upstream budget
+func (app *App) allowUpstream() (bool, time.Duration) {
+	if app.upstreamLimiter == nil {
+		return true, 0
+	}
+	return app.upstreamLimiter.allow("upstream")
+}
+
+// setupRateLimiting creates the limiters from config and registers their
+// metrics and cleanup worker
+func (app *App) setupRateLimiting() {
+	cfg := app.config.RateLimit
+	if !cfg.Enabled {
+		return
+	}
+
+	if cfg.ClientPerMinute > 0 {
+		app.clientLimiter = newRateLimiter(cfg.ClientPerMinute, cfg.ClientBurst)
+		app.goBackground(func(ctx context.Context) {
+			app.clientLimiter.cleanup(ctx, RateLimitIdleTimeout)
+		})
+	}
+	if cfg.UpstreamPerMinute > 0 {
+		app.upstreamLimiter = newRateLimiter(cfg.UpstreamPerMinute, cfg.UpstreamPerMinute)
+	}
+
+	app.metrics.register("wttr_ratelimit_rejected_total", "counter",
+		"Requests rejected by a rate limiter.", func() []metricSample {
+			var samples []metricSample
+			if app.clientLimiter != nil {
+				samples = append(samples, metricSample{
+					Labels: map[string]string{"scope": "client"},
+					Value:  float64(app.clientLimiter.rejected.Load()),
+				})
+			}
+			if app.upstreamLimiter != nil {
+				samples = append(samples, metricSample{
+					Labels: map[string]string{"scope": "upstream"},
+					Value:  float64(app.upstreamLimiter.rejected.Load()),
+				})
+			}
+			return samples
+		})
+	if app.clientLimiter != nil {
+		app.metrics.gauge("wttr_ratelimit_tracked_clients",
+			"Client IPs currently tracked by the rate limiter.", func() float64 {
+				return float64(app.clientLimiter.size())
+			})
+	}
+	if app.upstreamLimiter != nil {
+		app.metrics.gauge("wttr_ratelimit_upstream_tokens",
+			"Upstream requests still available in the current window.", func() float64 {
+				return app.upstreamLimiter.tokens("upstream")
+			})
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestApp builds an App from cfg and shuts it down when the test ends
+func newTestApp(t *testing.T, cfg *Config) *App {
+	t.Helper()
+	app, err := NewApp(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := app.Shutdown(ctx); err != nil {
+			t.Errorf("shutdown: %v", err)
+		}
+	})
+	return app
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name        string
+		trustedHops int
+		forwarded   []string
+		realIP      string
+		want        string
+	}{
+		{"proxy headers ignored", 0, []string{"203.0.113.9"}, "", "192.0.2.1"},
+		{"no forwarded header", 1, nil, "", "192.0.2.1"},
+		{"single proxy", 1, []string{"198.51.100.7"}, "", "198.51.100.7"},
+		{"spoofed entry ignored", 1, []string{"203.0.113.9, 198.51.100.7"}, "", "198.51.100.7"},
+		{"two proxies", 2, []string{"203.0.113.9, 198.51.100.7, 10.0.0.2"}, "", "198.51.100.7"},
+		{"split header lines", 1, []string{"203.0.113.9", "198.51.100.7"}, "", "198.51.100.7"},
+		{"fewer entries than hops", 3, []string{"198.51.100.7, 10.0.0.2"}, "", "198.51.100.7"},
+		{"real ip fallback", 1, nil, "198.51.100.8", "198.51.100.8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/Oslo", nil)
+			r.RemoteAddr = "192.0.2.1:5555"
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if got := clientIP(r, tt.trustedHops); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitedResponseType(t *testing.T) {
+	app := newTestApp(t, DefaultConfig())
+	const browser = "text/html,application/xhtml+xml,*/*;q=0.8"
+
+	tests := []struct {
+		path   string
+		accept string
+		want   string
+	}{
+		{"/Oslo", browser, "text/html"},
+		{"/api/v1/weather/Oslo", "", "application/json"},
+		{"/Oslo", "application/json", "application/json"},
+		{"/calendar/Oslo.ics", "text/calendar", "text/plain"},
+		{"/feed/Oslo.atom", "application/atom+xml", "text/plain"},
+		{"/export/Oslo.csv", browser, "text/plain"},
+		{"/card/Oslo.png", "image/avif,image/webp,*/*", "text/plain"},
+		{"/Oslo", "*/*", "text/plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.accept, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.Header.Set("User-Agent", "Mozilla/5.0")
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			app.writeRateLimited(w, r, 1500*time.Millisecond)
+
+			if w.Code != http.StatusTooManyRequests {
+				t.Errorf("status = %d, want 429", w.Code)
+			}
+			if got := w.Header().Get("Retry-After"); got != "2" {
+				t.Errorf("Retry-After = %q, want 2", got)
+			}
+			if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("Content-Type = %q, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	got := formatLabels(map[string]string{"scope": "client", "key": `a "b"\c` + "\nd ü"})
+	want := `{key="a \"b\"\\c\nd ü",scope="client"}`
+	if got != want {
+		t.Errorf("formatLabels = %s, want %s", got, want)
+	}
+}
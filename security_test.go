@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRFProtect(t *testing.T) {
+	const token = "c2VjcmV0LXRva2Vu"
+	handler := csrfProtect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name   string
+		method string
+		cookie string
+		header string
+		form   string
+		want   int
+	}{
+		{"valid form token", http.MethodPost, token, "", token, http.StatusNoContent},
+		{"valid header token", http.MethodPost, token, token, "", http.StatusNoContent},
+		{"header wins over form", http.MethodPost, token, token, "other", http.StatusNoContent},
+		{"missing cookie", http.MethodPost, "", "", token, http.StatusForbidden},
+		{"missing token", http.MethodPost, token, "", "", http.StatusForbidden},
+		{"mismatched form token", http.MethodPost, token, "", token + "x", http.StatusForbidden},
+		{"mismatched header token", http.MethodPost, token, "wrong", token, http.StatusForbidden},
+		{"delete needs a token", http.MethodDelete, token, "", "", http.StatusForbidden},
+		{"GET is exempt", http.MethodGet, "", "", "", http.StatusNoContent},
+		{"HEAD is exempt", http.MethodHead, "", "", "", http.StatusNoContent},
+		{"OPTIONS is exempt", http.MethodOptions, "", "", "", http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			if tt.form != "" {
+				form.Set(CSRFFieldName, tt.form)
+			}
+			req := httptest.NewRequest(tt.method, "/weather", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: tt.cookie})
+			}
+			if tt.header != "" {
+				req.Header.Set(CSRFHeaderName, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSRFTokenRoundTrip(t *testing.T) {
+	app := newTestApp(t, DefaultConfig())
+	fakeUpstream(t, app, serveWeather(testWeatherData("Oslo", 12)))
+	handler := app.routes()
+
+	// The page sets the cookie and embeds the same token in its form
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "text/html")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == CSRFCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("CSRF cookie = %+v", cookie)
+	}
+	if !strings.Contains(rec.Body.String(), `name="csrf_token" value="`+cookie.Value+`"`) {
+		t.Fatal("page form does not carry the cookie's token")
+	}
+
+	post := func(token string) int {
+		form := url.Values{"location": {"Oslo"}, CSRFFieldName: {token}}
+		req := httptest.NewRequest(http.MethodPost, "/weather", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := post(cookie.Value); code == http.StatusForbidden {
+		t.Errorf("valid token rejected")
+	}
+	if code := post("forged"); code != http.StatusForbidden {
+		t.Errorf("forged token: status %d, want 403", code)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// newHTTPServer builds an http.Server with the timeouts from cfg so slow
+// clients cannot hold connections open indefinitely
+func newHTTPServer(cfg ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout.Duration,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout.Duration,
+		WriteTimeout:      cfg.WriteTimeout.Duration,
+		IdleTimeout:       cfg.IdleTimeout.Duration,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// buildServers returns the main server and, when TLS is enabled with a
+// redirect address, the plain HTTP redirect server
+func buildServers(app *App, handler http.Handler) ([]*http.Server, error) {
+	cfg := app.config.Server
+	if !cfg.TLS.Enabled {
+		srv := newHTTPServer(cfg, handler)
+		// Stop background work as soon as shutdown starts so streaming
+		// responses end and the server can drain
+		srv.RegisterOnShutdown(app.cancel)
+		return []*http.Server{srv}, nil
+	}
+
+	reloader, err := newCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	app.goBackground(func(ctx context.Context) {
+		reloader.watch(ctx, cfg.TLS.ReloadInterval.Duration)
+	})
+
+	srv := newHTTPServer(cfg, hstsMiddleware(cfg.TLS.HSTSMaxAge.Duration, handler))
+	srv.TLSConfig = newTLSConfig(reloader)
+	srv.RegisterOnShutdown(app.cancel)
+
+	servers := []*http.Server{srv}
+	if cfg.TLS.RedirectAddr != "" {
+		servers = append(servers, newRedirectServer(cfg))
+	}
+	return servers, nil
+}
+
+// listen starts srv, over TLS when it has a TLS config
+func listen(srv *http.Server) error {
+	if srv.TLSConfig != nil {
+		fmt.Printf("HTTPS server starting on %s...\n", srv.Addr)
+		return srv.ListenAndServeTLS("", "")
+	}
+	fmt.Printf("Server starting on %s...\n", srv.Addr)
+	return srv.ListenAndServe()
+}
+
+// serve runs the servers until SIGINT or SIGTERM, then drains in-flight
+// requests and shuts the application down within the configured deadline
+func serve(app *App, servers ...*http.Server) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			if err := listen(srv); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errCh <- fmt.Errorf("%s: %w", srv.Addr, err)
+			}
+		}(srv)
+	}
+
+	var errs []error
+	select {
+	case err := <-errCh:
+		errs = append(errs, err)
+	case <-ctx.Done():
+		log.Printf("Shutdown signal received, draining connections")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.config.Server.ShutdownTimeout.Duration)
+	defer cancel()
+
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			errs = append(errs, fmt.Errorf("server %s shutdown: %w", srv.Addr, err))
+		}
+	}
+	if err := app.Shutdown(shutdownCtx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
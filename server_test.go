@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address nothing is listening on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+func TestServeShutsDownGracefully(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Server.Addr = freeAddr(t)
+	cfg.Server.ShutdownTimeout = Duration{5 * time.Second}
+	app, err := NewApp(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var hookRuns, workersRunning atomic.Int32
+	workerStopped := make(chan struct{})
+	app.goBackground(func(ctx context.Context) {
+		workersRunning.Add(1)
+		<-ctx.Done()
+		workersRunning.Add(-1)
+		close(workerStopped)
+	})
+	app.onShutdown(func(ctx context.Context) error {
+		// Hooks run after the workers have stopped
+		if workersRunning.Load() != 0 {
+			t.Error("shutdown hook ran before the workers stopped")
+		}
+		hookRuns.Add(1)
+		return nil
+	})
+
+	// A request in flight when the signal arrives still completes
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		io.WriteString(w, "done")
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	servers, err := buildServers(app, mux)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- serve(app, servers...) }()
+
+	base := "http://" + cfg.Server.Addr
+	waitFor(t, "the server to listen", func() bool {
+		resp, err := http.Get(base + "/")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	})
+
+	slow := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(base + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		slow <- string(b)
+	}()
+	<-started
+
+	// serve has registered for the signal by the time it is listening
+	start := time.Now()
+	if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-workerStopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("background worker did not stop after the signal")
+	}
+	close(release)
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve: %v", err)
+		}
+	case <-time.After(cfg.Server.ShutdownTimeout.Duration):
+		t.Fatal("serve did not return within the shutdown timeout")
+	}
+	if elapsed := time.Since(start); elapsed > cfg.Server.ShutdownTimeout.Duration {
+		t.Errorf("shutdown took %s", elapsed)
+	}
+	if got := <-slow; got != "done" {
+		t.Errorf("in-flight request got %q", got)
+	}
+	if n := hookRuns.Load(); n != 1 {
+		t.Errorf("shutdown hook ran %d times, want 1", n)
+	}
+	if _, err := http.Get(base + "/"); err == nil {
+		t.Error("server still accepting connections after shutdown")
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	app, err := NewApp(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stuck := make(chan struct{})
+	defer close(stuck)
+	app.goBackground(func(ctx context.Context) { <-stuck })
+	var hookRan atomic.Bool
+	app.onShutdown(func(ctx context.Context) error {
+		hookRan.Store(true)
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = app.Shutdown(ctx)
+	if err == nil || !strings.Contains(err.Error(), "background workers did not stop") {
+		t.Errorf("Shutdown = %v, want a deadline error", err)
+	}
+	if hookRan.Load() {
+		t.Error("shutdown hooks ran while a worker was still running")
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slackBlock is a Block Kit block; only the fields used here are modelled
+type slackBlock map[string]interface{}
+
+// slackMessage is a message posted back to a Slack response_url
+type slackMessage struct {
+	ResponseType    string       `json:"response_type,omitempty"`
+	ReplaceOriginal bool         `json:"replace_original"`
+	Text            string       `json:"text"`
+	Blocks          []slackBlock `json:"blocks,omitempty"`
+}
+
+// slackInteraction is the subset of a block_actions payload that is used
+type slackInteraction struct {
+	Type        string `json:"type"`
+	ResponseURL string `json:"response_url"`
+	Actions     []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+// verifySlackSignature checks X-Slack-Signature against the raw body and
+// rejects stale timestamps to prevent replays
+func verifySlackSignature(secret string, header http.Header, body []byte, now time.Time) error {
+	ts := header.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid timestamp")
+	}
+	if age := now.Sub(time.Unix(sec, 0)); age > SlackMaxRequestAge || age < -SlackMaxRequestAge {
+		return fmt.Errorf("stale timestamp")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:", ts)
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature"))) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// readSlackForm reads and verifies a signed Slack request body
+func (app *App) readSlackForm(r *http.Request) (url.Values, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, SlackMaxBodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+	if err := verifySlackSignature(app.config.Slack.SigningSecret, r.Header, body, time.Now()); err != nil {
+		return nil, err
+	}
+	return url.ParseQuery(string(body))
+}
+
+// slackCommandHandler answers "/weather <location>". Slack expects a reply
+// within three seconds, so the request is acknowledged at once and the
+// forecast is posted to response_url when it arrives.
+func (app *App) slackCommandHandler(w http.ResponseWriter, r *http.Request) {
+	form, err := app.readSlackForm(r)
+	if err != nil {
+		log.Printf("Slack: rejected command: %v", err)
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return
+	}
+
+	location := strings.TrimSpace(form.Get("text"))
+	if location == "" {
+		writeJSON(w, http.StatusOK, slackMessage{
+			ResponseType: "ephemeral",
+			Text:         "Usage: " + form.Get("command") + " <location>",
+		})
+		return
+	}
+
+	responseURL := form.Get("response_url")
+	if !strings.HasPrefix(responseURL, SlackResponseURLPrefix) {
+		http.Error(w, "invalid response_url", http.StatusBadRequest)
+		return
+	}
+
+	app.goBackground(func(ctx context.Context) {
+		msg := app.slackWeatherMessage(ctx, location)
+		app.postSlackResponse(ctx, responseURL, msg)
+	})
+	w.WriteHeader(http.StatusOK)
+}
+
+// slackInteractiveHandler handles the "Compare" buttons on weather messages
+func (app *App) slackInteractiveHandler(w http.ResponseWriter, r *http.Request) {
+	form, err := app.readSlackForm(r)
+	if err != nil {
+		log.Printf("Slack: rejected interaction: %v", err)
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return
+	}
+
+	var payload slackInteraction
+	if err := json.Unmarshal([]byte(form.Get("payload")), &payload); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(payload.ResponseURL, SlackResponseURLPrefix) {
+		http.Error(w, "invalid response_url", http.StatusBadRequest)
+		return
+	}
+
+	for _, action := range payload.Actions {
+		if action.ActionID != SlackCompareAction {
+			continue
+		}
+		first, second, ok := strings.Cut(action.Value, "|")
+		if !ok {
+			continue
+		}
+		app.goBackground(func(ctx context.Context) {
+			msg := app.slackCompareMessage(ctx, first, second)
+			app.postSlackResponse(ctx, payload.ResponseURL, msg)
+		})
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// slackPage runs the same fetch and processing pipeline as the web page
+func (app *App) slackPage(ctx context.Context, location string) (PageData, error) {
+	data, err := app.fetchWeatherData(ctx, location)
+	if err != nil {
+		return PageData{}, err
+	}
+	page := app.processWeatherData(data)
+	if !page.HasData {
+		return PageData{}, fmt.Errorf("%s", page.Error)
+	}
+	return page, nil
+}
+
+// slackWeatherMessage builds the Block Kit message for one location
+func (app *App) slackWeatherMessage(ctx context.Context, location string) slackMessage {
+	page, err := app.slackPage(ctx, location)
+	if err != nil {
+		log.Printf("Slack: error fetching %q: %v", location, err)
+		return slackMessage{ResponseType: "ephemeral", Text: ErrFetchWeatherData}
+	}
+
+	blocks := []slackBlock{
+		{"type": "header", "text": plainText(page.Location)},
+		{
+			"type": "section",
+			"text": markdownText(fmt.Sprintf("*%s* %s", page.Temperature, page.Description)),
+			"fields": []interface{}{
+				markdownText("*Feels like*\n" + page.FeelsLike),
+				markdownText("*Humidity*\n" + page.Humidity),
+				markdownText("*Wind*\n" + page.Wind),
+				markdownText("*Visibility*\n" + page.Visibility),
+			},
+		},
+		{"type": "divider"},
+		{"type": "section", "fields": forecastFields(page.Forecast)},
+	}
+
+	var buttons []interface{}
+	for _, fav := range app.config.Favourites {
+		if locationKey(fav) == locationKey(location) || len(buttons) >= SlackMaxCompareButtons {
+			continue
+		}
+		buttons = append(buttons, map[string]interface{}{
+			"type":      "button",
+			"text":      plainText("Compare with " + fav),
+			"action_id": SlackCompareAction,
+			"value":     location + "|" + fav,
+		})
+	}
+	if len(buttons) > 0 {
+		blocks = append(blocks, slackBlock{"type": "actions", "elements": buttons})
+	}
+
+	return slackMessage{
+		ResponseType: app.config.Slack.ResponseType,
+		Text:         fmt.Sprintf("%s: %s, %s", page.Location, page.Temperature, page.Description),
+		Blocks:       blocks,
+	}
+}
+
+// slackCompareMessage puts two locations side by side
+func (app *App) slackCompareMessage(ctx context.Context, first, second string) slackMessage {
+	a, errA := app.slackPage(ctx, first)
+	b, errB := app.slackPage(ctx, second)
+	if errA != nil || errB != nil {
+		return slackMessage{ResponseType: "ephemeral", Text: ErrFetchWeatherData}
+	}
+
+	column := func(p PageData) interface{} {
+		return markdownText(fmt.Sprintf("*%s*\n%s %s\nFeels like %s\nWind %s",
+			p.Location, p.Temperature, p.Description, p.FeelsLike, p.Wind))
+	}
+
+	return slackMessage{
+		ResponseType: app.config.Slack.ResponseType,
+		Text:         fmt.Sprintf("%s vs %s", a.Location, b.Location),
+		Blocks: []slackBlock{
+			{"type": "header", "text": plainText(a.Location + " vs " + b.Location)},
+			{"type": "section", "fields": []interface{}{column(a), column(b)}},
+		},
+	}
+}
+
+// forecastFields renders the forecast days as section fields
+func forecastFields(days []ForecastDay) []interface{} {
+	fields := make([]interface{}, 0, len(days))
+	for _, d := range days {
+		fields = append(fields, markdownText(fmt.Sprintf("*%s*\n%s\n%s", d.Day, d.Temperature, d.Description)))
+	}
+	return fields
+}
+
+func plainText(s string) map[string]interface{} {
+	return map[string]interface{}{"type": "plain_text", "text": s, "emoji": true}
+}
+
+func markdownText(s string) map[string]interface{} {
+	return map[string]interface{}{"type": "mrkdwn", "text": s}
+}
+
+// postSlackResponse sends msg to a Slack response_url
+func (app *App) postSlackResponse(ctx context.Context, responseURL string, msg slackMessage) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Slack: error encoding response: %v", err)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, responseURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Slack: error creating response request: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.client.Do(req)
+	if err != nil {
+		log.Printf("Slack: error posting response: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Slack: response_url returned status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSlackSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+// signSlack returns the headers Slack sends with body at ts
+func signSlack(secret string, ts time.Time, body string) http.Header {
+	stamp := strconv.FormatInt(ts.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", stamp, body)
+	h := http.Header{}
+	h.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.Set("X-Slack-Request-Timestamp", stamp)
+	h.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return h
+}
+
+// slackResponses records the messages posted to hooks.slack.com, while
+// every other upstream request gets weather for the last path segment
+type slackResponses struct {
+	mu       sync.Mutex
+	messages []map[string]interface{}
+}
+
+func (s *slackResponses) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Host != "hooks.slack.com" {
+		location, _ := url.PathUnescape(path.Base(r.URL.Path))
+		serveWeather(testWeatherData(location, len(location)))(w, r)
+		return
+	}
+	var msg map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.messages = append(s.messages, msg)
+	s.mu.Unlock()
+}
+
+func (s *slackResponses) posted() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]interface{}(nil), s.messages...)
+}
+
+// newSlackTestApp returns the routes of an app with Slack enabled and
+// favourites to offer as compare buttons
+func newSlackTestApp(t *testing.T) (http.Handler, *slackResponses) {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.Slack.SigningSecret = testSlackSecret
+	cfg.Favourites = []string{"Oslo", "Bergen"}
+	app := newTestApp(t, cfg)
+	responses := &slackResponses{}
+	fakeUpstream(t, app, responses)
+	return app.routes(), responses
+}
+
+// postSlack sends a signed form to the Slack endpoint target
+func postSlack(handler http.Handler, target string, form url.Values, header http.Header) *httptest.ResponseRecorder {
+	body := form.Encode()
+	if header == nil {
+		header = signSlack(testSlackSecret, time.Now(), body)
+	}
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header = header
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestVerifySlackSignature(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	body := []byte("command=%2Fweather&text=Oslo")
+
+	if err := verifySlackSignature(testSlackSecret, signSlack(testSlackSecret, now, string(body)), body, now); err != nil {
+		t.Errorf("valid signature: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		body   string
+		want   string
+	}{
+		{"wrong secret", signSlack("other-secret", now, string(body)), string(body), "signature mismatch"},
+		{"tampered body", signSlack(testSlackSecret, now, string(body)), "command=%2Fweather&text=Bergen", "signature mismatch"},
+		{"stale", signSlack(testSlackSecret, now.Add(-SlackMaxRequestAge-time.Second), string(body)), string(body), "stale timestamp"},
+		{"from the future", signSlack(testSlackSecret, now.Add(SlackMaxRequestAge+time.Second), string(body)), string(body), "stale timestamp"},
+		{"no timestamp", http.Header{"X-Slack-Signature": {"v0=00"}}, string(body), "invalid timestamp"},
+	}
+	for _, tt := range tests {
+		err := verifySlackSignature(testSlackSecret, tt.header, []byte(tt.body), now)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// Re-signing an old request with a fresh timestamp needs the secret
+	replayed := signSlack(testSlackSecret, now.Add(-time.Hour), string(body))
+	replayed.Set("X-Slack-Request-Timestamp", strconv.FormatInt(now.Unix(), 10))
+	if err := verifySlackSignature(testSlackSecret, replayed, body, now); err == nil {
+		t.Error("accepted an old signature with a new timestamp")
+	}
+}
+
+func TestSlackCommand(t *testing.T) {
+	handler, responses := newSlackTestApp(t)
+	form := url.Values{
+		"command":      {"/weather"},
+		"text":         {" Tromsø "},
+		"response_url": {"https://hooks.slack.com/commands/T1/123/abc"},
+	}
+
+	// Bad signature
+	header := signSlack(testSlackSecret, time.Now(), form.Encode())
+	header.Set("X-Slack-Signature", "v0="+strings.Repeat("0", 64))
+	if rec := postSlack(handler, "/integrations/slack/command", form, header); rec.Code != http.StatusUnauthorized {
+		t.Errorf("bad signature: status %d", rec.Code)
+	}
+
+	// A captured request replayed after the window
+	header = signSlack(testSlackSecret, time.Now().Add(-SlackMaxRequestAge-time.Minute), form.Encode())
+	if rec := postSlack(handler, "/integrations/slack/command", form, header); rec.Code != http.StatusUnauthorized {
+		t.Errorf("stale timestamp: status %d", rec.Code)
+	}
+
+	for _, responseURL := range []string{
+		"http://hooks.slack.com/commands/T1/123/abc",
+		"https://hooks.slack.com.example.net/commands",
+		"https://example.net/hooks.slack.com/",
+		"",
+	} {
+		bad := url.Values{"command": {"/weather"}, "text": {"Oslo"}, "response_url": {responseURL}}
+		if rec := postSlack(handler, "/integrations/slack/command", bad, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("response_url %q: status %d, want 400", responseURL, rec.Code)
+		}
+	}
+	if n := len(responses.posted()); n != 0 {
+		t.Fatalf("rejected commands posted %d responses", n)
+	}
+
+	// Usage is answered inline
+	rec := postSlack(handler, "/integrations/slack/command", url.Values{"command": {"/weather"}}, nil)
+	var usage slackMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &usage); err != nil || usage.ResponseType != "ephemeral" || usage.Text != "Usage: /weather <location>" {
+		t.Errorf("empty text: status %d, %s", rec.Code, rec.Body)
+	}
+
+	rec = postSlack(handler, "/integrations/slack/command", form, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("valid command: status %d, %s", rec.Code, rec.Body)
+	}
+	waitFor(t, "the command response", func() bool { return len(responses.posted()) == 1 })
+
+	msg := responses.posted()[0]
+	if msg["response_type"] != "in_channel" || !strings.HasPrefix(msg["text"].(string), "Tromsø, Norway: ") {
+		t.Errorf("response = %v", msg)
+	}
+	blocks, _ := msg["blocks"].([]interface{})
+	last, _ := blocks[len(blocks)-1].(map[string]interface{})
+	buttons, _ := last["elements"].([]interface{})
+	if last["type"] != "actions" || len(buttons) != 2 {
+		t.Fatalf("last block = %v, want two compare buttons", last)
+	}
+	button := buttons[0].(map[string]interface{})
+	if button["action_id"] != SlackCompareAction || button["value"] != "Tromsø|Oslo" {
+		t.Errorf("button = %v", button)
+	}
+}
+
+func TestSlackCompareAction(t *testing.T) {
+	handler, responses := newSlackTestApp(t)
+	interaction := func(responseURL string) url.Values {
+		payload, _ := json.Marshal(map[string]interface{}{
+			"type":         "block_actions",
+			"response_url": responseURL,
+			"actions": []map[string]string{
+				{"action_id": "something_else", "value": "Oslo|Bergen"},
+				{"action_id": SlackCompareAction, "value": "Tromsø|Oslo"},
+			},
+		})
+		return url.Values{"payload": {string(payload)}}
+	}
+
+	header := signSlack("other-secret", time.Now(), interaction("https://hooks.slack.com/actions/1").Encode())
+	if rec := postSlack(handler, "/integrations/slack/interactive", interaction("https://hooks.slack.com/actions/1"), header); rec.Code != http.StatusUnauthorized {
+		t.Errorf("bad signature: status %d", rec.Code)
+	}
+	if rec := postSlack(handler, "/integrations/slack/interactive", interaction("https://attacker.example/actions/1"), nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("foreign response_url: status %d", rec.Code)
+	}
+	if rec := postSlack(handler, "/integrations/slack/interactive", url.Values{"payload": {"{"}}, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed payload: status %d", rec.Code)
+	}
+
+	if rec := postSlack(handler, "/integrations/slack/interactive", interaction("https://hooks.slack.com/actions/1"), nil); rec.Code != http.StatusOK {
+		t.Fatalf("valid action: status %d, %s", rec.Code, rec.Body)
+	}
+	waitFor(t, "the comparison", func() bool { return len(responses.posted()) == 1 })
+	time.Sleep(50 * time.Millisecond)
+	if n := len(responses.posted()); n != 1 {
+		t.Fatalf("posted %d messages for one compare action", n)
+	}
+
+	// Round-trip the message to check its Block Kit shape
+	b, _ := json.Marshal(responses.posted()[0])
+	var msg struct {
+		ResponseType string `json:"response_type"`
+		Text         string `json:"text"`
+		Blocks       []struct {
+			Type string `json:"type"`
+			Text *struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"text"`
+			Fields []struct {
+				Type string `json:"type"`
+				Text string `json:"text"`
+			} `json:"fields"`
+		} `json:"blocks"`
+	}
+	if err := json.Unmarshal(b, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ResponseType != "in_channel" || msg.Text != "Tromsø, Norway vs Oslo, Norway" {
+		t.Errorf("message = %s", b)
+	}
+	if len(msg.Blocks) != 2 {
+		t.Fatalf("got %d blocks: %s", len(msg.Blocks), b)
+	}
+	if h := msg.Blocks[0]; h.Type != "header" || h.Text == nil || h.Text.Type != "plain_text" || h.Text.Text != "Tromsø, Norway vs Oslo, Norway" {
+		t.Errorf("header block = %+v", h)
+	}
+	section := msg.Blocks[1]
+	if section.Type != "section" || len(section.Fields) != 2 {
+		t.Fatalf("section block = %+v", section)
+	}
+	for i, want := range []string{"*Tromsø, Norway*\n", "*Oslo, Norway*\n"} {
+		if f := section.Fields[i]; f.Type != "mrkdwn" || !strings.HasPrefix(f.Text, want) || !strings.Contains(f.Text, "Feels like") {
+			t.Errorf("field %d = %+v", i, f)
+		}
+	}
+}
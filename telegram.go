@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// telegramUpdate is the subset of a Bot API Update that is used
+type telegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+	} `json:"message"`
+}
+
+// telegramClient calls the Telegram Bot API
+type telegramClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newTelegramClient(cfg TelegramConfig, client *http.Client) *telegramClient {
+	return &telegramClient{
+		baseURL: strings.TrimSuffix(cfg.APIURL, "/") + "/bot" + cfg.Token,
+		client:  client,
+	}
+}
+
+// SendMessage implements chatMessenger
+func (t *telegramClient) SendMessage(ctx context.Context, chatID, text string) error {
+	body, err := json.Marshal(map[string]string{"chat_id": chatID, "text": text})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.baseURL+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sendMessage: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("sendMessage returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// parseTelegramCommand splits "/weather@MyBot Oslo" into "weather", "Oslo".
+// Plain text without a slash is treated as a weather query.
+func parseTelegramCommand(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return "weather", text
+	}
+	command, args, _ := strings.Cut(text[1:], " ")
+	command, _, _ = strings.Cut(command, "@")
+	if command == "start" || command == "help" {
+		return "help", ""
+	}
+	return strings.ToLower(command), args
+}
+
+// telegramWebhookHandler receives Bot API updates. The update is
+// acknowledged at once and the reply sent through the Bot API.
+func (app *App) telegramWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	secret := app.config.Bots.Telegram.SecretToken
+	got := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if secret == "" || subtle.ConstantTimeCompare([]byte(got), []byte(secret)) != 1 {
+		http.Error(w, "invalid secret token", http.StatusUnauthorized)
+		return
+	}
+
+	var update telegramUpdate
+	if err := json.NewDecoder(io.LimitReader(r.Body, BotMaxBodyBytes)).Decode(&update); err != nil {
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if update.Message == nil || update.Message.Text == "" {
+		return
+	}
+
+	chatID := strconv.FormatInt(update.Message.Chat.ID, 10)
+	command, args := parseTelegramCommand(update.Message.Text)
+	messenger := app.bots.messengers[PlatformTelegram]
+
+	app.goBackground(func(ctx context.Context) {
+		reply := app.bots.handleCommand(ctx, PlatformTelegram, chatID, command, args)
+		if err := messenger.SendMessage(ctx, chatID, reply); err != nil {
+			log.Printf("Telegram: error replying to %s: %v", chatID, err)
+		}
+	})
+}
@@ -6,7 +6,110 @@ const htmlTemplate = `<!DOCTYPE html>
     <meta charset="UTF-8">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
     <title>Weather Forecast</title>
-    <style>
+    <style nonce="{{.Nonce}}">
+{{template "styles"}}
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>🌤️ Weather Forecast</h1>
+            <p>Get current weather and forecast for any location</p>
+        </div>
+
+        <form class="location-input" method="POST" action="/weather">
+            <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+            <input type="text" name="location" placeholder="Enter city name (e.g., London, New York)" 
+                   value="{{if not .HasData}}London{{end}}" required>
+            <button type="submit">Get Weather</button>
+        </form>
+
+        {{if .Error}}
+        <div class="error">{{.Error}}</div>
+        {{end}}
+
+        {{if .HasData}}
+        <div class="current-weather"{{if .Query}} data-live-location="{{.Query}}"{{end}}>
+            <div class="weather-icon" data-field="icon">{{.WeatherIcon}}</div>
+            <div class="temperature" data-field="temperature">{{.Temperature}}</div>
+            <div class="description" data-field="description">{{.Description}}</div>
+            <div class="location-name" data-field="location">{{.Location}}</div>
+            
+            <div class="details">
+                <div class="detail-item">
+                    <div class="detail-label">Feels Like</div>
+                    <div class="detail-value" data-field="feels_like">{{.FeelsLike}}</div>
+                </div>
+                <div class="detail-item">
+                    <div class="detail-label">Humidity</div>
+                    <div class="detail-value" data-field="humidity">{{.Humidity}}</div>
+                </div>
+                <div class="detail-item">
+                    <div class="detail-label">Wind</div>
+                    <div class="detail-value" data-field="wind">{{.Wind}}</div>
+                </div>
+                <div class="detail-item">
+                    <div class="detail-label">Visibility</div>
+                    <div class="detail-value" data-field="visibility">{{.Visibility}}</div>
+                </div>
+            </div>
+        </div>
+
+        <div class="forecast">
+            <h3>3-Day Forecast</h3>
+            <div class="forecast-grid">
+                {{range .Forecast}}
+                <div class="forecast-item">
+                    <div class="forecast-day">{{.Day}}</div>
+                    <div class="forecast-icon">{{.Icon}}</div>
+                    <div class="forecast-temp">{{.Temperature}}</div>
+                    <div class="forecast-desc">{{.Description}}</div>
+                </div>
+                {{end}}
+            </div>
+        </div>
+
+        {{if .Chart}}
+        <div class="chart">
+            <h3>Hourly Forecast</h3>
+            {{.Chart}}
+        </div>
+        {{end}}
+        {{end}}
+    </div>
+
+    {{if .Query}}
+    <!-- Live updates are optional: without JavaScript the page stays as rendered -->
+    <script nonce="{{.Nonce}}">
+        (function () {
+            var root = document.querySelector('[data-live-location]');
+            if (!root || !window.EventSource) {
+                return;
+            }
+            var source = new EventSource('/events/weather/' + encodeURIComponent(root.dataset.liveLocation));
+            source.addEventListener('weather', function (event) {
+                var update = JSON.parse(event.data);
+                root.querySelectorAll('[data-field]').forEach(function (el) {
+                    var value = update[el.dataset.field];
+                    if (value === undefined) {
+                        return;
+                    }
+                    if (el.dataset.field === 'icon') {
+                        el.innerHTML = value;
+                    } else {
+                        el.textContent = value;
+                    }
+                });
+            });
+        })();
+    </script>
+    {{end}}
+</body>
+</html>`
+
+
+// baseStyles is shared by every page template
+const baseStyles = `{{define "styles"}}
         * {
             margin: 0;
             padding: 0;
@@ -175,6 +278,30 @@ const htmlTemplate = `<!DOCTYPE html>
             margin-top: 5px;
         }
 
+        .chart {
+            margin-top: 30px;
+            background: white;
+            padding: 15px;
+            border-radius: 10px;
+            box-shadow: 0 5px 15px rgba(0, 0, 0, 0.08);
+        }
+
+        .chart h3 {
+            color: #2d3436;
+            margin-bottom: 10px;
+            text-align: center;
+        }
+
+        .chart svg {
+            display: block;
+            height: auto;
+        }
+
+        .location-name {
+            font-size: 1.1rem;
+            color: #636e72;
+        }
+
         .error {
             background: #ff6b6b;
             color: white;
@@ -198,66 +325,549 @@ const htmlTemplate = `<!DOCTYPE html>
                 font-size: 2rem;
             }
         }
+{{end}}`
+
+// alertsTemplate lists weather alerts with their state
+const alertsTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Weather Alerts</title>
+    <style nonce="{{.Nonce}}">
+{{template "styles"}}
+
+        .alert-list {
+            display: grid;
+            gap: 15px;
+        }
+
+        .alert-item {
+            background: white;
+            padding: 15px 20px;
+            border-radius: 10px;
+            box-shadow: 0 5px 15px rgba(0, 0, 0, 0.08);
+            border-left: 6px solid #b2bec3;
+        }
+
+        .alert-item.firing {
+            border-left-color: #ff6b6b;
+        }
+
+        .alert-item.acknowledged {
+            border-left-color: #fdcb6e;
+        }
+
+        .alert-title {
+            font-weight: bold;
+            color: #2d3436;
+        }
+
+        .alert-meta {
+            font-size: 0.9rem;
+            color: #636e72;
+            margin-top: 5px;
+        }
+
+        .alert-item form {
+            margin-top: 10px;
+        }
+
+        .alert-item button {
+            padding: 6px 16px;
+            background: #74b9ff;
+            color: white;
+            border: none;
+            border-radius: 15px;
+            cursor: pointer;
+        }
+
+        .empty {
+            text-align: center;
+            color: #636e72;
+        }
     </style>
 </head>
 <body>
     <div class="container">
         <div class="header">
-            <h1>🌤️ Weather Forecast</h1>
-            <p>Get current weather and forecast for any location</p>
+            <h1>🚨 Weather Alerts</h1>
+            <p>{{len .Rules}} rule{{if ne (len .Rules) 1}}s{{end}} configured</p>
         </div>
 
-        <form class="location-input" method="POST" action="/weather">
-            <input type="text" name="location" placeholder="Enter city name (e.g., London, New York)" 
-                   value="{{if not .HasData}}London{{end}}" required>
-            <button type="submit">Get Weather</button>
+        {{if .Alerts}}
+        <div class="alert-list">
+            {{range .Alerts}}
+            <div class="alert-item {{.State}}">
+                <div class="alert-title">{{.Rule}} &middot; {{.Location}}</div>
+                <div class="alert-meta">{{.Condition}} &middot; last value {{.Value}}</div>
+                <div class="alert-meta">
+                    {{.State}} &middot; fired {{.FiredAt.Format "Mon 2 Jan 15:04"}}
+                    {{with .AcknowledgedAt}} &middot; acknowledged {{.Format "Mon 2 Jan 15:04"}}{{end}}
+                    {{with .ResolvedAt}} &middot; resolved {{.Format "Mon 2 Jan 15:04"}}{{end}}
+                </div>
+                {{if eq .State "firing"}}
+                <form method="POST" action="/alerts/{{.ID}}/ack">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <button type="submit">Acknowledge</button>
+                </form>
+                {{end}}
+            </div>
+            {{end}}
+        </div>
+        {{else}}
+        <p class="empty">No alerts have fired.</p>
+        {{end}}
+    </div>
+</body>
+</html>`
+
+// embedTemplate is the framed variant of the weather page: no search form
+// and no live-update script, with an optional meta refresh instead
+const embedTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    {{if .RefreshSeconds}}<meta http-equiv="refresh" content="{{.RefreshSeconds}}">{{end}}
+    <title>{{if .HasData}}{{.Location}} - {{end}}Weather</title>
+    <style nonce="{{.Nonce}}">
+        * {
+            margin: 0;
+            padding: 0;
+            box-sizing: border-box;
+        }
+
+        body {
+            font-family: 'Arial', sans-serif;
+            background: #ffffff;
+            color: #2d3436;
+            padding: 12px;
+        }
+
+        body.dark {
+            background: #1e272e;
+            color: #f5f6fa;
+        }
+
+        .current {
+            display: flex;
+            align-items: center;
+            gap: 12px;
+        }
+
+        .weather-icon {
+            width: 64px;
+            height: 64px;
+            flex-shrink: 0;
+        }
+
+        .temperature {
+            font-size: 2rem;
+            font-weight: bold;
+        }
+
+        .muted {
+            color: #636e72;
+            font-size: 0.9rem;
+        }
+
+        .dark .muted {
+            color: #b2bec3;
+        }
+
+        .details {
+            display: grid;
+            grid-template-columns: repeat(2, 1fr);
+            gap: 4px 12px;
+            margin-top: 10px;
+            font-size: 0.9rem;
+        }
+
+        .forecast-grid {
+            display: grid;
+            grid-template-columns: repeat(auto-fit, minmax(90px, 1fr));
+            gap: 8px;
+            margin-top: 12px;
+        }
+
+        .forecast-item {
+            background: #f1f2f6;
+            border-radius: 8px;
+            padding: 8px;
+            text-align: center;
+            font-size: 0.85rem;
+        }
+
+        .dark .forecast-item {
+            background: #2f3640;
+        }
+
+        .forecast-icon {
+            width: 32px;
+            height: 32px;
+            margin: 4px auto;
+        }
+
+        .compact .weather-icon {
+            width: 40px;
+            height: 40px;
+        }
+
+        .compact .temperature {
+            font-size: 1.4rem;
+        }
+
+        .error {
+            background: #ff6b6b;
+            color: white;
+            padding: 10px;
+            border-radius: 8px;
+        }
+
+        a {
+            color: inherit;
+        }
+
+        .source {
+            margin-top: 10px;
+            font-size: 0.75rem;
+        }
+    </style>
+</head>
+<body class="{{.Theme}}{{if .Compact}} compact{{end}}">
+    {{if .Error}}
+    <div class="error">{{.Error}}</div>
+    {{end}}
+
+    {{if .HasData}}
+    <div class="current">
+        <div class="weather-icon">{{.WeatherIcon}}</div>
+        <div>
+            <div class="temperature">{{.Temperature}}</div>
+            <div class="muted">{{.Description}} &middot; {{.Location}}</div>
+        </div>
+    </div>
+
+    {{if not .Compact}}
+    <div class="details">
+        <div><span class="muted">Feels like</span> {{.FeelsLike}}</div>
+        <div><span class="muted">Humidity</span> {{.Humidity}}</div>
+        <div><span class="muted">Wind</span> {{.Wind}}</div>
+        <div><span class="muted">Visibility</span> {{.Visibility}}</div>
+    </div>
+    {{end}}
+
+    {{if .Forecast}}
+    <div class="forecast-grid">
+        {{range .Forecast}}
+        <div class="forecast-item">
+            <div><strong>{{.Day}}</strong></div>
+            <div class="forecast-icon">{{.Icon}}</div>
+            <div>{{.Temperature}}</div>
+            {{if not $.Compact}}<div class="muted">{{.Description}}</div>{{end}}
+        </div>
+        {{end}}
+    </div>
+    {{end}}
+
+    <div class="source muted"><a href="/{{.Query}}" target="_blank" rel="noopener">Full forecast</a></div>
+    {{end}}
+</body>
+</html>`
+
+// historyTemplate summarises recorded observations for a location
+const historyTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{.Location}} - Weather History</title>
+    <style nonce="{{.Nonce}}">
+{{template "styles"}}
+
+        .location-input input[type="date"] {
+            width: 170px;
+        }
+
+        .records {
+            display: grid;
+            grid-template-columns: repeat(auto-fit, minmax(200px, 1fr));
+            gap: 15px;
+            margin-bottom: 20px;
+        }
+
+        .record-high .detail-value {
+            color: #d63031;
+        }
+
+        .record-low .detail-value {
+            color: #0984e3;
+        }
+
+        .chart svg {
+            max-width: 100%;
+        }
+
+        .history-table {
+            width: 100%;
+            margin-top: 30px;
+            border-collapse: collapse;
+            background: white;
+            border-radius: 10px;
+            overflow: hidden;
+            box-shadow: 0 5px 15px rgba(0, 0, 0, 0.08);
+        }
+
+        .history-table th,
+        .history-table td {
+            padding: 8px 12px;
+            text-align: right;
+            color: #2d3436;
+        }
+
+        .history-table th:first-child,
+        .history-table td:first-child {
+            text-align: left;
+        }
+
+        .history-table thead {
+            background: rgba(116, 185, 255, 0.1);
+        }
+
+        .history-table td.record-high {
+            color: #d63031;
+            font-weight: bold;
+        }
+
+        .history-table td.record-low {
+            color: #0984e3;
+            font-weight: bold;
+        }
+
+        .empty {
+            text-align: center;
+            color: #636e72;
+            margin-top: 20px;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>📈 {{.Location}}</h1>
+            <p><a href="/{{.Location}}">Current weather and forecast</a></p>
+        </div>
+
+        <form class="location-input" method="GET">
+            <input type="date" name="from" value="{{.From}}" aria-label="From">
+            <input type="date" name="to" value="{{.To}}" aria-label="To">
+            <button type="submit">Show</button>
         </form>
 
         {{if .Error}}
         <div class="error">{{.Error}}</div>
+        {{else if not .Records}}
+        <p class="empty">No observations have been recorded for this location.</p>
+        {{else}}
+        {{with .Records}}
+        <div class="records">
+            <div class="detail-item record-high">
+                <div class="detail-label">Record high</div>
+                <div class="detail-value">{{printf "%.0f" .HighC}}°C</div>
+                <div class="detail-label">{{.HighAt.Format "Mon 2 Jan 2006 15:04"}} UTC</div>
+            </div>
+            <div class="detail-item record-low">
+                <div class="detail-label">Record low</div>
+                <div class="detail-value">{{printf "%.0f" .LowC}}°C</div>
+                <div class="detail-label">{{.LowAt.Format "Mon 2 Jan 2006 15:04"}} UTC</div>
+            </div>
+            <div class="detail-item">
+                <div class="detail-label">Recording since</div>
+                <div class="detail-value">{{.Since.Format "2 Jan 2006"}}</div>
+                <div class="detail-label">{{.Samples}} observations</div>
+            </div>
+        </div>
         {{end}}
 
-        {{if .HasData}}
-        <div class="current-weather">
-            <div class="weather-icon">{{.WeatherIcon}}</div>
-            <div class="temperature">{{.Temperature}}</div>
-            <div class="description">{{.Description}}</div>
-            <div style="font-size: 1.1rem; color: #636e72;">{{.Location}}</div>
-            
-            <div class="details">
-                <div class="detail-item">
-                    <div class="detail-label">Feels Like</div>
-                    <div class="detail-value">{{.FeelsLike}}</div>
-                </div>
-                <div class="detail-item">
-                    <div class="detail-label">Humidity</div>
-                    <div class="detail-value">{{.Humidity}}</div>
-                </div>
-                <div class="detail-item">
-                    <div class="detail-label">Wind</div>
-                    <div class="detail-value">{{.Wind}}</div>
-                </div>
-                <div class="detail-item">
-                    <div class="detail-label">Visibility</div>
-                    <div class="detail-value">{{.Visibility}}</div>
-                </div>
-            </div>
+        <div class="chart">
+            <h3>Mean Temperature</h3>
+            {{.TempHeatmap}}
         </div>
 
-        <div class="forecast">
-            <h3>3-Day Forecast</h3>
-            <div class="forecast-grid">
-                {{range .Forecast}}
-                <div class="forecast-item">
-                    <div class="forecast-day">{{.Day}}</div>
-                    <div class="forecast-icon">{{.Icon}}</div>
-                    <div class="forecast-temp">{{.Temperature}}</div>
-                    <div class="forecast-desc">{{.Description}}</div>
-                </div>
+        <div class="chart">
+            <h3>Precipitation</h3>
+            {{.PrecipHeatmap}}
+        </div>
+
+        {{if .Days}}
+        <table class="history-table">
+            <thead>
+                <tr>
+                    <th>Date</th>
+                    <th>Min</th>
+                    <th>Max</th>
+                    <th>Mean</th>
+                    <th>Precipitation</th>
+                    <th>Max wind</th>
+                </tr>
+            </thead>
+            <tbody>
+                {{range .Days}}
+                <tr>
+                    <td>{{.Date.Format "Mon 2 Jan 2006"}}</td>
+                    <td{{if .RecordLow}} class="record-low" title="Record low"{{end}}>{{printf "%.0f" .MinTempC}}°C</td>
+                    <td{{if .RecordHigh}} class="record-high" title="Record high"{{end}}>{{printf "%.0f" .MaxTempC}}°C</td>
+                    <td>{{printf "%.1f" .MeanTempC}}°C</td>
+                    <td>{{printf "%.1f" .PrecipMM}} mm</td>
+                    <td>{{printf "%.0f" .MaxWindKmph}} km/h</td>
+                </tr>
                 {{end}}
-            </div>
+            </tbody>
+        </table>
+        {{else}}
+        <p class="empty">No observations in this range.</p>
+        {{end}}
+        {{end}}
+    </div>
+</body>
+</html>`
+
+// verificationTemplate reports forecast bias and error by lead time
+const verificationTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Forecast Verification</title>
+    <style nonce="{{.Nonce}}">
+{{template "styles"}}
+
+        .verification-table {
+            width: 100%;
+            border-collapse: collapse;
+            background: white;
+            border-radius: 10px;
+            overflow: hidden;
+            box-shadow: 0 5px 15px rgba(0, 0, 0, 0.08);
+        }
+
+        .verification-table th,
+        .verification-table td {
+            padding: 8px 12px;
+            text-align: right;
+            color: #2d3436;
+        }
+
+        .verification-table th:nth-child(-n+2),
+        .verification-table td:nth-child(-n+2) {
+            text-align: left;
+        }
+
+        .verification-table thead {
+            background: rgba(116, 185, 255, 0.1);
+        }
+
+        .note {
+            font-size: 0.9rem;
+            color: #636e72;
+            margin-top: 15px;
+        }
+
+        .empty {
+            text-align: center;
+            color: #636e72;
+        }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <div class="header">
+            <h1>🎯 Forecast Verification</h1>
+            <p>Daily forecasts compared with recorded observations up to {{.Until.Format "2 Jan 2006"}}</p>
         </div>
+
+        {{if .Error}}
+        <div class="error">{{.Error}}</div>
+        {{else if .Stats}}
+        <table class="verification-table">
+            <thead>
+                <tr>
+                    <th>Location</th>
+                    <th>Provider</th>
+                    <th>Lead (days)</th>
+                    <th>Days</th>
+                    <th>Max bias</th>
+                    <th>Max MAE</th>
+                    <th>Min bias</th>
+                    <th>Min MAE</th>
+                </tr>
+            </thead>
+            <tbody>
+                {{range .Stats}}
+                <tr>
+                    <td><a href="/history/{{.Location}}">{{.Location}}</a></td>
+                    <td>{{.Provider}}</td>
+                    <td>{{.LeadDays}}</td>
+                    <td>{{.Days}}</td>
+                    <td>{{printf "%+.1f" .MaxBiasC}}°C</td>
+                    <td>{{printf "%.1f" .MaxMAEC}}°C</td>
+                    <td>{{printf "%+.1f" .MinBiasC}}°C</td>
+                    <td>{{printf "%.1f" .MinMAEC}}°C</td>
+                </tr>
+                {{end}}
+            </tbody>
+        </table>
+        <p class="note">Bias is forecast minus observed: positive values mean the forecast ran warm. MAE is the mean absolute error. Lead 0 is the first forecast day.</p>
+        {{else}}
+        <p class="empty">No forecasts can be verified yet. Each day needs a stored forecast and a full local day of observations.</p>
         {{end}}
     </div>
 </body>
 </html>`
+
+// digestHTMLTemplate is the HTML part of the morning digest email. Email
+// clients ignore <style> blocks, so styles are inline.
+const digestHTMLTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>Weather for {{.Date}}</title>
+</head>
+<body style="margin: 0; padding: 20px; background: #74b9ff; font-family: Arial, sans-serif;">
+    <div style="max-width: 600px; margin: 0 auto; background: #ffffff; border-radius: 20px; padding: 30px;">
+        <h1 style="color: #2d3436; font-size: 1.6rem; margin: 0 0 20px; text-align: center;">🌤️ Weather for {{.Date}}</h1>
+        {{range .Locations}}
+        <div style="margin-bottom: 25px;">
+            <h2 style="color: #2d3436; font-size: 1.2rem; margin: 0 0 5px;">{{.Location}}</h2>
+            <p style="color: #636e72; margin: 0 0 10px;">Now: {{.Current}}</p>
+            <table role="presentation" width="100%" cellpadding="0" cellspacing="0">
+                <tr>
+                    {{range .Days}}
+                    <td style="background: #f5f6fa; border-radius: 10px; padding: 15px; text-align: center; width: 50%;">
+                        <div style="font-weight: bold; color: #2d3436;">{{.Day}}</div>
+                        <img src="cid:{{.IconCID}}" width="48" height="48" alt="{{.Description}}">
+                        <div style="font-weight: bold; color: #2d3436;">{{.Temperature}}</div>
+                        <div style="font-size: 0.85rem; color: #636e72;">{{.Description}}</div>
+                    </td>
+                    {{end}}
+                </tr>
+            </table>
+        </div>
+        {{end}}
+        {{if .Failed}}
+        <p style="color: #d63031; font-size: 0.85rem;">No forecast available for: {{range $i, $l := .Failed}}{{if $i}}, {{end}}{{$l}}{{end}}</p>
+        {{end}}
+    </div>
+</body>
+</html>`
+
+// digestTextTemplate is the plain-text part of the morning digest email
+const digestTextTemplate = `Weather for {{.Date}}
+{{range .Locations}}
+{{.Location}}
+Now: {{.Current}}
+{{range .Days}}  {{printf "%-6s" .Day}} {{.Temperature}}  {{.Description}}
+{{end}}{{end}}{{if .Failed}}
+No forecast available for: {{range $i, $l := .Failed}}{{if $i}}, {{end}}{{$l}}{{end}}
+{{end}}`
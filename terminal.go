@@ -0,0 +1,447 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Terminal output for curl, wget and httpie, in the spirit of wttr.in's own
+// console view. Icons are 5 lines of 13 columns; each line has one colour.
+
+type ansiIcon struct {
+	lines  [5]string
+	colors [5]int
+}
+
+// ansiIcons mirrors weatherIcons and is looked up by weatherIconName
+var ansiIcons = map[string]ansiIcon{
+	"clear": {
+		lines: [5]string{
+			`    \   /    `,
+			`     .-.     `,
+			`  - (   ) -  `,
+			"     `-'     ",
+			`    /   \    `,
+		},
+		colors: [5]int{226, 226, 226, 226, 226},
+	},
+	"partlyCloudy": {
+		lines: [5]string{
+			`   \  /      `,
+			` _ /"".-.    `,
+			`   \_(   ).  `,
+			`   /(___(__) `,
+			`             `,
+		},
+		colors: [5]int{226, 226, 250, 250, 250},
+	},
+	"cloudy": {
+		lines: [5]string{
+			`             `,
+			`     .--.    `,
+			`  .-(    ).  `,
+			` (___.__)__) `,
+			`             `,
+		},
+		colors: [5]int{250, 250, 250, 250, 250},
+	},
+	"overcast": {
+		lines: [5]string{
+			`             `,
+			`     .--.    `,
+			`  .-(    ).  `,
+			` (___.__)__) `,
+			`             `,
+		},
+		colors: [5]int{240, 240, 240, 240, 240},
+	},
+	"rain": {
+		lines: [5]string{
+			`     .-.     `,
+			`    (   ).   `,
+			`   (___(__)  `,
+			`    ' ' ' '  `,
+			`   ' ' ' '   `,
+		},
+		colors: [5]int{250, 250, 250, 111, 111},
+	},
+	"heavyRain": {
+		lines: [5]string{
+			`     .-.     `,
+			`    (   ).   `,
+			`   (___(__)  `,
+			`  ,','','',' `,
+			`  ,','','',' `,
+		},
+		colors: [5]int{240, 240, 240, 21, 21},
+	},
+	"thunderstorm": {
+		lines: [5]string{
+			`     .-.     `,
+			`    (   ).   `,
+			`   (___(__)  `,
+			`    ,'/ ,'   `,
+			`     /_ /    `,
+		},
+		colors: [5]int{240, 240, 240, 228, 228},
+	},
+	"snow": {
+		lines: [5]string{
+			`     .-.     `,
+			`    (   ).   `,
+			`   (___(__)  `,
+			`    *  *  *  `,
+			`   *  *  *   `,
+		},
+		colors: [5]int{250, 250, 250, 255, 255},
+	},
+	"fog": {
+		lines: [5]string{
+			`             `,
+			` _ - _ - _ - `,
+			`  _ - _ - _  `,
+			` _ - _ - _ - `,
+			`             `,
+		},
+		colors: [5]int{251, 251, 251, 251, 251},
+	},
+	"wind": {
+		lines: [5]string{
+			`             `,
+			`  ~~~~ ~~~   `,
+			` ~~~ ~~~~~~  `,
+			`   ~~~~ ~~~  `,
+			`             `,
+		},
+		colors: [5]int{250, 250, 250, 250, 250},
+	},
+	"default": {
+		lines: [5]string{
+			`    .-.      `,
+			`     __)     `,
+			`    (        `,
+			"     `-'     ",
+			`      *      `,
+		},
+		colors: [5]int{250, 250, 250, 250, 250},
+	},
+}
+
+// getANSIIcon returns the terminal icon for a weather condition
+func getANSIIcon(condition string) ansiIcon {
+	if icon, ok := ansiIcons[weatherIconName(condition)]; ok {
+		return icon
+	}
+	return ansiIcons["default"]
+}
+
+// windArrows points the way the wind blows, keyed by the direction it
+// comes from
+var windArrows = map[string]string{
+	"N": "↓", "NNE": "↓", "NE": "↙", "ENE": "↙",
+	"E": "←", "ESE": "←", "SE": "↖", "SSE": "↖",
+	"S": "↑", "SSW": "↑", "SW": "↗", "WSW": "↗",
+	"W": "→", "WNW": "→", "NW": "↘", "NNW": "↘",
+}
+
+// terminalUserAgents are lower-cased User-Agent prefixes of command-line
+// clients that get text instead of HTML
+var terminalUserAgents = []string{"curl/", "wget/", "httpie/"}
+
+// textOptions are the ?flags accepted by the terminal view
+type textOptions struct {
+	Color  bool
+	Narrow bool
+}
+
+// parseTextOptions reads single-letter flags from the query string. Flags can
+// be combined as in wttr.in, e.g. ?nT. T disables colour, n selects the narrow
+// layout and w the wide one (the default).
+func parseTextOptions(r *http.Request) textOptions {
+	opts := textOptions{Color: true}
+	for key, values := range r.URL.Query() {
+		if len(values) > 0 && values[0] != "" {
+			continue
+		}
+		for _, flag := range key {
+			switch flag {
+			case 'T':
+				opts.Color = false
+			case 'n':
+				opts.Narrow = true
+			case 'w':
+				opts.Narrow = false
+			}
+		}
+	}
+	return opts
+}
+
+// wantsTerminal reports whether the client is a command-line HTTP client or
+// explicitly prefers plain text over HTML
+func wantsTerminal(r *http.Request) bool {
+	ua := strings.ToLower(r.Header.Get("User-Agent"))
+	for _, prefix := range terminalUserAgents {
+		if strings.HasPrefix(ua, prefix) {
+			return true
+		}
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/plain") && !strings.Contains(accept, "text/html")
+}
+
+// terminalHandler writes the weather for location as terminal text
+func (app *App) terminalHandler(w http.ResponseWriter, r *http.Request, location string) {
+	weatherData, ok := app.fetchForText(w, r, location)
+	if !ok {
+		return
+	}
+
+	writeText(w, http.StatusOK, renderTerminal(weatherData, parseTextOptions(r)))
+}
+
+// fetchForText fetches and validates the weather for location, writing a
+// plain text error and returning false on failure
+func (app *App) fetchForText(w http.ResponseWriter, r *http.Request, location string) (*WeatherData, bool) {
+	if location == "" {
+		writeText(w, http.StatusBadRequest, ErrEmptyLocation)
+		return nil, false
+	}
+
+	weatherData, err := app.fetchWeatherData(r.Context(), location)
+	if errors.Is(err, errUpstreamRateLimited) {
+		app.writeRateLimited(w, r, upstreamRetryAfter(err))
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Error fetching weather data for %q: %v", location, err)
+		writeText(w, http.StatusBadGateway, ErrFetchWeatherData)
+		return nil, false
+	}
+	if err := app.validateWeatherData(weatherData); err != nil {
+		log.Printf("Invalid weather data: %v", err)
+		writeText(w, http.StatusBadGateway, ErrInvalidWeatherData)
+		return nil, false
+	}
+	return weatherData, true
+}
+
+// writeText writes a plain text response, adding a trailing newline so shell
+// prompts start on their own line
+func writeText(w http.ResponseWriter, status int, text string) {
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(text))
+}
+
+// painter applies 256-colour ANSI escapes, or nothing when colour is off
+type painter struct {
+	enabled bool
+}
+
+func (p painter) paint(color int, s string) string {
+	if !p.enabled || strings.TrimSpace(s) == "" {
+		return s
+	}
+	return fmt.Sprintf("\033[38;5;%dm%s\033[0m", color, s)
+}
+
+func (p painter) bold(s string) string {
+	if !p.enabled {
+		return s
+	}
+	return "\033[1m" + s + "\033[0m"
+}
+
+// renderTerminal renders current conditions and the forecast. data must have
+// passed validateWeatherData.
+func renderTerminal(data *WeatherData, opts textOptions) string {
+	p := painter{enabled: opts.Color}
+	current := data.CurrentCondition[0]
+	area := data.NearestArea[0]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Weather report: %s, %s\n\n", area.AreaName[0].Value, area.Country[0].Value)
+
+	description := ""
+	if len(current.WeatherDesc) > 0 {
+		description = current.WeatherDesc[0].Value
+	}
+	info := [5]string{
+		description,
+		fmt.Sprintf("%s(%s) °C", p.temperature(current.TempC), p.temperature(current.FeelsLikeC)),
+		p.wind(current.Winddir16Point, current.WindspeedKmph),
+		current.Visibility + " km",
+		current.Humidity + "%",
+	}
+	for i, line := range iconLines(p, getANSIIcon(description)) {
+		b.WriteString(strings.TrimRight(line+" "+info[i], " "))
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	days := data.Weather
+	if len(days) > MaxForecastDays {
+		days = days[:MaxForecastDays]
+	}
+	blocks := make([][]string, 0, len(days))
+	for i, day := range days {
+		blocks = append(blocks, forecastBlock(p, day, i == 0))
+	}
+
+	if opts.Narrow {
+		for i, block := range blocks {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			for _, line := range block {
+				b.WriteString(strings.TrimRight(line, " "))
+				b.WriteString("\n")
+			}
+		}
+		return b.String()
+	}
+
+	// Wide layout puts the days side by side
+	for row := 0; len(blocks) > 0 && row < len(blocks[0]); row++ {
+		cols := make([]string, len(blocks))
+		for i, block := range blocks {
+			cols[i] = block[row]
+		}
+		b.WriteString(strings.TrimRight(strings.Join(cols, " | "), " "))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// forecastBlock renders one forecast day as fixed-width lines
+func forecastBlock(p painter, day Weather, today bool) []string {
+	title := day.Date
+	if today {
+		title = "Today"
+	} else if date, err := time.Parse("2006-01-02", day.Date); err == nil {
+		title = date.Format("Mon 02 Jan")
+	}
+
+	condition := middayDescription(day)
+	maxRain, maxWind := 0, 0
+	for _, hour := range day.Hourly {
+		if rain := atoi(hour.ChanceOfRain); rain > maxRain {
+			maxRain = rain
+		}
+		if wind := atoi(hour.WindspeedKmph); wind > maxWind {
+			maxWind = wind
+		}
+	}
+	info := [5]string{
+		truncate(condition, TerminalInfoWidth),
+		fmt.Sprintf("%s..%s °C", p.temperature(day.MintempC), p.temperature(day.MaxtempC)),
+		fmt.Sprintf("rain %d%%", maxRain),
+		p.wind("", strconv.Itoa(maxWind)),
+		"",
+	}
+
+	width := TerminalIconWidth + 1 + TerminalInfoWidth
+	lines := []string{p.bold(padRight(title, width))}
+	for i, line := range iconLines(p, getANSIIcon(condition)) {
+		lines = append(lines, line+" "+padRight(info[i], TerminalInfoWidth))
+	}
+	return lines
+}
+
+// iconLines returns the coloured lines of an icon
+func iconLines(p painter, icon ansiIcon) []string {
+	lines := make([]string, len(icon.lines))
+	for i, line := range icon.lines {
+		lines[i] = p.paint(icon.colors[i], padRight(line, TerminalIconWidth))
+	}
+	return lines
+}
+
+// temperature colours a Celsius value from blue through to red
+func (p painter) temperature(value string) string {
+	t := atoi(value)
+	text := strconv.Itoa(t)
+	if t > 0 {
+		text = "+" + text
+	}
+	thresholds := []struct {
+		max   int
+		color int
+	}{
+		{-15, 21}, {-10, 27}, {-5, 33}, {0, 39}, {5, 51}, {10, 82},
+		{15, 154}, {20, 226}, {25, 220}, {30, 214}, {35, 202},
+	}
+	for _, th := range thresholds {
+		if t <= th.max {
+			return p.paint(th.color, text)
+		}
+	}
+	return p.paint(196, text)
+}
+
+// wind renders the direction arrow and a speed coloured by strength
+func (p painter) wind(direction, speed string) string {
+	kmph := atoi(speed)
+	color := 196
+	switch {
+	case kmph < 5:
+		color = 46
+	case kmph < 15:
+		color = 118
+	case kmph < 25:
+		color = 154
+	case kmph < 35:
+		color = 226
+	case kmph < 45:
+		color = 214
+	}
+	text := fmt.Sprintf("%d km/h", kmph)
+	if arrow, ok := windArrows[direction]; ok {
+		text = arrow + " " + text
+	}
+	return p.paint(color, text)
+}
+
+// padRight pads s with spaces to width visible columns
+func padRight(s string, width int) string {
+	if n := visibleWidth(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
+
+// visibleWidth counts the runes of s, skipping ANSI colour escapes
+func visibleWidth(s string) int {
+	n := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\033' {
+			if end := strings.IndexByte(s[i:], 'm'); end >= 0 {
+				i += end + 1
+				continue
+			}
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		n++
+	}
+	return n
+}
+
+// truncate shortens s to at most width runes
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
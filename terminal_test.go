@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// terminalWeatherData is a fixed three-day forecast with a different
+// condition and temperature range each day, so the golden output covers
+// several icons and colours
+func terminalWeatherData() *WeatherData {
+	data := testWeatherData("Oslo", 3)
+	days := []struct {
+		date, condition string
+		offset          int
+	}{
+		{"2024-05-01", "Light rain", 0},
+		{"2024-05-02", "Sunny", 18},
+		{"2024-05-03", "Heavy snow", -14},
+	}
+	for i, d := range days {
+		day := &data.Weather[i]
+		day.Date = d.date
+		day.MintempC = strconv.Itoa(atoi(day.MintempC) + d.offset)
+		day.MaxtempC = strconv.Itoa(atoi(day.MaxtempC) + d.offset)
+		for h := range day.Hourly {
+			day.Hourly[h].WeatherDesc = []WeatherDesc{{Value: d.condition}}
+			day.Hourly[h].WindspeedKmph = strconv.Itoa(4 + 12*i + h)
+		}
+	}
+	return data
+}
+
+func TestTerminalGolden(t *testing.T) {
+	app := newTestApp(t, DefaultConfig())
+	fakeUpstream(t, app, serveWeather(terminalWeatherData()))
+	handler := app.routes()
+
+	tests := []struct {
+		name, query string
+	}{
+		{"wide", ""},
+		{"wide_plain", "?T"},
+		{"narrow", "?n"},
+		{"narrow_plain", "?nT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/Oslo"+tt.query, nil)
+			req.Header.Set("User-Agent", "curl/8.5.0")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+				t.Errorf("content type = %q", ct)
+			}
+			got := rec.Body.String()
+			if strings.HasSuffix(tt.name, "_plain") && strings.Contains(got, "\033") {
+				t.Error("plain output contains escape codes")
+			}
+
+			golden := filepath.Join("testdata", "terminal_"+tt.name+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if got != string(want) {
+				t.Errorf("output differs from %s\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+func TestFileProbesNotFound(t *testing.T) {
+	var upstreamHits atomic.Int32
+	app := newTestApp(t, DefaultConfig())
+	fakeUpstream(t, app, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHits.Add(1)
+		serveWeather(testWeatherData("Oslo", 12))(w, r)
+	}))
+	handler := app.routes()
+
+	for _, path := range []string{"/.env", "/.git", "/wp-login.php", "/apple-touch-icon.png", "/favicon.ico", "/robots.txt", "/sitemap.xml"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s: status %d, want 404", path, rec.Code)
+		}
+	}
+	if n := upstreamHits.Load(); n != 0 {
+		t.Errorf("file probes made %d upstream requests", n)
+	}
+
+	// Place names with dots are still looked up
+	req := httptest.NewRequest(http.MethodGet, "/St.Paul", nil)
+	req.Header.Set("User-Agent", "curl/8.5.0")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || upstreamHits.Load() != 1 {
+		t.Errorf("GET /St.Paul: status %d after %d upstream requests", rec.Code, upstreamHits.Load())
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Forecast verification compares the daily forecasts kept by the history
+// recorder with the temperatures later observed on the same day. Forecasts
+// are stored per lead time, so a day forecast three days out is scored
+// separately from the same day forecast the morning of.
+//
+// Forecasts are kept for the history recorder's locations only, both from
+// the recorder's own fetches and from weather pages served for those
+// locations in between, so the issue time is the first time the app saw a
+// forecast. Lead N is the Nth day of that forecast, and wttr.in dates its
+// days in the location's local time, so lead 0 is the location's current
+// date when the forecast was fetched. Observations are grouped by the same
+// local dates.
+
+// recordForecasts stores the daily max/min forecast for each day that
+// processForecast shows. Lead 0 is the first forecast day. Only the first
+// forecast issued for a day at each lead is kept, which is one per
+// location, issue date and lead, so repeated polling and page views do not
+// favour forecasts issued later in the day.
+func (s *historyStore) recordForecasts(ctx context.Context, location string, at time.Time, data *WeatherData) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for lead, day := range forecastDays(data.Weather) {
+		if _, err := time.Parse("2006-01-02", day.Date); err != nil {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO forecasts
+			(provider, location, issued_at, target_date, lead_days, max_temp_c, min_temp_c)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (provider, location, target_date, lead_days) DO NOTHING`,
+			ForecastProvider, locationKey(location), at.Unix(), day.Date, lead,
+			atoi(day.MaxtempC), atoi(day.MintempC)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// recordServedForecast stores the forecast behind a weather page when the
+// history recorder tracks its location
+func (app *App) recordServedForecast(ctx context.Context, location string, data *WeatherData) {
+	if app.history == nil || !app.history.tracked[locationKey(location)] {
+		return
+	}
+	if err := app.history.recordForecasts(ctx, location, time.Now(), data); err != nil {
+		log.Printf("History: error recording forecasts for %q: %v", location, err)
+	}
+}
+
+// VerificationStats scores the forecasts of one provider, location and lead
+// time. Bias is forecast minus observed, so a positive bias means the
+// forecast ran warm.
+type VerificationStats struct {
+	Provider string  `json:"provider"`
+	Location string  `json:"location"`
+	LeadDays int     `json:"lead_days"`
+	Days     int     `json:"days"`
+	MaxBiasC float64 `json:"max_temp_bias_c"`
+	MaxMAEC  float64 `json:"max_temp_mae_c"`
+	MinBiasC float64 `json:"min_temp_bias_c"`
+	MinMAEC  float64 `json:"min_temp_mae_c"`
+}
+
+// verification scores forecasts for days that had ended at their location
+// by until. Observed days are the location's local dates with observations
+// in at least VerificationMinHours hours, so a gap in recording does not
+// count as a missed extreme. An empty location scores every location.
+func (s *historyStore) verification(ctx context.Context, location string, until time.Time) ([]VerificationStats, error) {
+	key := ""
+	if location != "" {
+		key = locationKey(location)
+	}
+	rows, err := s.db.QueryContext(ctx, `WITH observed AS (
+			SELECT location, date(observed_at + utc_offset, 'unixepoch') AS day,
+				MAX(temp_c) AS max_temp, MIN(temp_c) AS min_temp
+			FROM observations
+			WHERE observed_at < ?
+			GROUP BY location, day
+			HAVING COUNT(DISTINCT strftime('%H', observed_at + utc_offset, 'unixepoch')) >= ?
+				AND CAST(strftime('%s', day, '+1 day') AS INTEGER) - MAX(utc_offset) <= ?
+		)
+		SELECT f.provider, f.location, f.lead_days, COUNT(*),
+			AVG(f.max_temp_c - o.max_temp), AVG(ABS(f.max_temp_c - o.max_temp)),
+			AVG(f.min_temp_c - o.min_temp), AVG(ABS(f.min_temp_c - o.min_temp))
+		FROM forecasts f
+		JOIN observed o ON o.location = f.location AND o.day = f.target_date
+		WHERE ? = '' OR f.location = ?
+		GROUP BY f.provider, f.location, f.lead_days
+		ORDER BY f.location, f.provider, f.lead_days`,
+		until.Unix(), VerificationMinHours, until.Unix(), key, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []VerificationStats{}
+	for rows.Next() {
+		var v VerificationStats
+		if err := rows.Scan(&v.Provider, &v.Location, &v.LeadDays, &v.Days,
+			&v.MaxBiasC, &v.MaxMAEC, &v.MinBiasC, &v.MinMAEC); err != nil {
+			return nil, err
+		}
+		stats = append(stats, v)
+	}
+	return stats, rows.Err()
+}
+
+// APIVerification is the response body of GET /api/v1/verification
+type APIVerification struct {
+	Until time.Time           `json:"until"`
+	Stats []VerificationStats `json:"stats"`
+}
+
+// VerificationPageData is the data for the verification report
+type VerificationPageData struct {
+	Until time.Time
+	Stats []VerificationStats
+	Error string
+	Nonce string
+}
+
+// queryVerification scores the days completed so far, optionally for
+// ?location=
+func (app *App) queryVerification(r *http.Request) (time.Time, []VerificationStats, error) {
+	until := time.Now().UTC().Truncate(time.Second)
+	location := strings.TrimSpace(r.URL.Query().Get("location"))
+	stats, err := app.history.verification(r.Context(), location, until)
+	if err != nil {
+		log.Printf("Error computing forecast verification: %v", err)
+	}
+	return until, stats, err
+}
+
+// apiVerificationHandler serves GET /api/v1/verification
+func (app *App) apiVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if app.history == nil {
+		writeJSONError(w, http.StatusNotFound, ErrHistoryDisabled)
+		return
+	}
+	until, stats, err := app.queryVerification(r)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, ErrVerificationQuery)
+		return
+	}
+	writeJSON(w, http.StatusOK, APIVerification{Until: until, Stats: stats})
+}
+
+// verificationHandler serves the GET /verification report page
+func (app *App) verificationHandler(w http.ResponseWriter, r *http.Request) {
+	if app.history == nil {
+		writeText(w, http.StatusNotFound, ErrHistoryDisabled)
+		return
+	}
+	data := VerificationPageData{Nonce: cspNonce(r)}
+	var err error
+	if data.Until, data.Stats, err = app.queryVerification(r); err != nil {
+		data.Error = ErrVerificationQuery
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := app.tmpl.ExecuteTemplate(w, "verification", data); err != nil {
+		log.Printf("Error executing verification template: %v", err)
+		http.Error(w, ErrTemplateExecution, http.StatusInternalServerError)
+	}
+}
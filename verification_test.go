@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestVerificationUsesLocalDate(t *testing.T) {
+	s := openTestHistory(t)
+	ctx := context.Background()
+	tokyo := 9 * time.Hour
+
+	// Issued on the morning of 2 May in Tokyo, for that day and the next
+	issued := time.Date(2024, 5, 1, 22, 0, 0, 0, time.UTC)
+	data := testWeatherData("Tokyo", 15)
+	data.Weather[0].Date, data.Weather[0].MaxtempC, data.Weather[0].MintempC = "2024-05-02", "25", "8"
+	data.Weather[1].Date, data.Weather[1].MaxtempC, data.Weather[1].MintempC = "2024-05-03", "20", "5"
+	data.Weather = data.Weather[:2]
+	if err := s.recordForecasts(ctx, "Tokyo", issued, data); err != nil {
+		t.Fatal(err)
+	}
+	// A later forecast for the same days and leads is not kept
+	data.Weather[0].MaxtempC = "40"
+	if err := s.recordForecasts(ctx, "Tokyo", issued.Add(time.Hour), data); err != nil {
+		t.Fatal(err)
+	}
+
+	// All of 2 May in Tokyo, which spans two UTC days, from 10 to 21°C
+	start := time.Date(2024, 5, 1, 15, 0, 0, 0, time.UTC)
+	for h := 0; h < 24; h++ {
+		recordLocal(t, s, "Tokyo", start.Add(time.Duration(h)*time.Hour), tokyo, 10+h%12)
+	}
+
+	// 23:00 on 2 May in Tokyo: the day has not ended
+	stats, err := s.verification(ctx, "", time.Date(2024, 5, 2, 14, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 0 {
+		t.Errorf("scored an unfinished day: %+v", stats)
+	}
+
+	stats, err = s.verification(ctx, "Tokyo", time.Date(2024, 5, 2, 15, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := VerificationStats{
+		Provider: ForecastProvider,
+		Location: locationKey("Tokyo"),
+		LeadDays: 0,
+		Days:     1,
+		MaxBiasC: 4,
+		MaxMAEC:  4,
+		MinBiasC: -2,
+		MinMAEC:  2,
+	}
+	if len(stats) != 1 || stats[0] != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+}
+
+func TestServedForecastsRecorded(t *testing.T) {
+	var mu sync.Mutex
+	data := testWeatherData("Oslo", 12)
+	app := newTestApp(t, DefaultConfig())
+	fakeUpstream(t, app, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		serveWeather(data)(w, r)
+	}))
+	app.history = openTestHistory(t)
+	app.history.tracked = map[string]bool{locationKey("Oslo"): true}
+	handler := app.routes()
+
+	// forecasts lists the stored forecasts as location/lead/max
+	forecasts := func() []string {
+		rows, err := app.history.db.Query("SELECT location, lead_days, max_temp_c FROM forecasts ORDER BY location, lead_days")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var got []string
+		for rows.Next() {
+			var location string
+			var lead, maxC int
+			if err := rows.Scan(&location, &lead, &maxC); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, fmt.Sprintf("%s/%d/%d", location, lead, maxC))
+		}
+		return got
+	}
+
+	getRoute(handler, "/Bergen", browserAccept)
+	if got := forecasts(); len(got) != 0 {
+		t.Errorf("untracked location stored %v", got)
+	}
+
+	getRoute(handler, "/oslo", browserAccept)
+	want := []string{"oslo/0/14", "oslo/1/15", "oslo/2/16"}
+	if got := forecasts(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("after the first page: %v, want %v", got, want)
+	}
+
+	// A later view of an updated forecast the same day keeps the first
+	mu.Lock()
+	data.Weather[0].MaxtempC = "30"
+	mu.Unlock()
+	getRoute(handler, "/Oslo", browserAccept)
+	if got := forecasts(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("after a second page: %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// WebhookEndpoint is a receiver for alert events.
+//
+// Template, when set, is a text/template rendered with the AlertEvent to
+// produce the request body, so payloads can match what Slack, Teams or an
+// incident tool expects. The json function quotes a value as JSON.
+type WebhookEndpoint struct {
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	Secret   string   `json:"secret"`
+	Events   []string `json:"events"`
+	Template string   `json:"template"`
+}
+
+// webhookTarget is an endpoint with its parsed template and its own
+// delivery queue, so a slow or failing endpoint only delays itself
+type webhookTarget struct {
+	WebhookEndpoint
+	tmpl  *template.Template
+	queue chan webhookDelivery
+}
+
+// wants reports whether the endpoint subscribes to the event type
+func (t *webhookTarget) wants(eventType string) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, e := range t.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookDelivery is one event queued for one endpoint
+type webhookDelivery struct {
+	target *webhookTarget
+	event  AlertEvent
+	body   []byte
+}
+
+// deadLetter is a line in the dead-letter log
+type deadLetter struct {
+	Endpoint string          `json:"endpoint"`
+	URL      string          `json:"url"`
+	Event    string          `json:"event"`
+	AlertID  string          `json:"alert_id"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+	Body     json.RawMessage `json:"body"`
+	FailedAt time.Time       `json:"failed_at"`
+}
+
+// webhookNotifier posts alert events to the configured endpoints, retrying
+// with exponential backoff and logging undeliverable events
+type webhookNotifier struct {
+	cfg     WebhooksConfig
+	client  *http.Client
+	targets []*webhookTarget
+
+	deadMu sync.Mutex
+
+	delivered atomic.Uint64
+	failed    atomic.Uint64
+}
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func newWebhookNotifier(cfg WebhooksConfig) (*webhookNotifier, error) {
+	n := &webhookNotifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout.Duration},
+	}
+
+	for _, ep := range cfg.Endpoints {
+		if ep.URL == "" {
+			return nil, fmt.Errorf("webhook %q has no url", ep.Name)
+		}
+		target := &webhookTarget{
+			WebhookEndpoint: ep,
+			queue:           make(chan webhookDelivery, WebhookQueueSize),
+		}
+		if ep.Template != "" {
+			tmpl, err := template.New(ep.Name).Funcs(webhookFuncs).Parse(ep.Template)
+			if err != nil {
+				return nil, fmt.Errorf("webhook %q template: %w", ep.Name, err)
+			}
+			target.tmpl = tmpl
+		}
+		n.targets = append(n.targets, target)
+	}
+	return n, nil
+}
+
+// payload renders the request body for an event
+func (t *webhookTarget) payload(event AlertEvent) ([]byte, error) {
+	if t.tmpl == nil {
+		return json.Marshal(event)
+	}
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, event); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// notify queues an event for every endpoint that wants it. It never blocks;
+// if an endpoint's queue is full the delivery goes straight to the
+// dead-letter log.
+func (n *webhookNotifier) notify(event AlertEvent) {
+	for _, target := range n.targets {
+		if !target.wants(event.Type) {
+			continue
+		}
+
+		body, err := target.payload(event)
+		if err != nil {
+			log.Printf("Webhook %s: error rendering payload: %v", target.Name, err)
+			continue
+		}
+
+		d := webhookDelivery{target: target, event: event, body: body}
+		select {
+		case target.queue <- d:
+		default:
+			n.deadLetter(d, 0, fmt.Errorf("delivery queue full"))
+		}
+	}
+}
+
+// run delivers queued events until ctx is cancelled, with one worker per
+// endpoint so retries against one endpoint never hold up the others
+func (n *webhookNotifier) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, target := range n.targets {
+		wg.Add(1)
+		go func(target *webhookTarget) {
+			defer wg.Done()
+			n.work(ctx, target)
+		}(target)
+	}
+	wg.Wait()
+}
+
+// work delivers target's queue in order. Anything still queued at shutdown
+// is written to the dead-letter log rather than lost.
+func (n *webhookNotifier) work(ctx context.Context, target *webhookTarget) {
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case d := <-target.queue:
+					n.deadLetter(d, 0, fmt.Errorf("not delivered before shutdown"))
+				default:
+					return
+				}
+			}
+		case d := <-target.queue:
+			n.deliver(ctx, d)
+		}
+	}
+}
+
+// sign computes the signature header value for body sent at ts
+func sign(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", ts)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryable reports whether a response status is worth retrying
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// deliver posts d, retrying transient failures with exponential backoff
+func (n *webhookNotifier) deliver(ctx context.Context, d webhookDelivery) {
+	backoff := n.cfg.InitialBackoff.Duration
+	var lastErr error
+
+	for attempt := 1; attempt <= n.cfg.MaxAttempts; attempt++ {
+		retry, err := n.post(ctx, d)
+		if err == nil {
+			n.delivered.Add(1)
+			return
+		}
+		lastErr = err
+		if !retry || attempt == n.cfg.MaxAttempts {
+			n.deadLetter(d, attempt, lastErr)
+			return
+		}
+
+		log.Printf("Webhook %s: attempt %d failed, retrying in %s: %v", d.target.Name, attempt, backoff, err)
+		select {
+		case <-ctx.Done():
+			n.deadLetter(d, attempt, lastErr)
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, n.cfg.MaxBackoff.Duration)
+	}
+}
+
+// post sends a single attempt and reports whether a failure may be retried
+func (n *webhookNotifier) post(ctx context.Context, d webhookDelivery) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.target.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", WebhookUserAgent)
+	req.Header.Set("X-Webhook-Event", d.event.Type)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(ts, 10))
+	if d.target.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", sign(d.target.Secret, ts, d.body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return retryable(resp.StatusCode), fmt.Errorf("endpoint returned status %d", resp.StatusCode)
+	}
+	return false, nil
+}
+
+// deadLetter records a delivery that could not be made
+func (n *webhookNotifier) deadLetter(d webhookDelivery, attempts int, cause error) {
+	n.failed.Add(1)
+	log.Printf("Webhook %s: giving up on %s for %s: %v", d.target.Name, d.event.Type, d.event.Alert.ID, cause)
+
+	if n.cfg.DeadLetterFile == "" {
+		return
+	}
+
+	body := json.RawMessage(d.body)
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(d.body))
+	}
+	line, err := json.Marshal(deadLetter{
+		Endpoint: d.target.Name,
+		URL:      d.target.URL,
+		Event:    d.event.Type,
+		AlertID:  d.event.Alert.ID,
+		Attempts: attempts,
+		Error:    cause.Error(),
+		Body:     body,
+		FailedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("Webhook: error encoding dead letter: %v", err)
+		return
+	}
+
+	n.deadMu.Lock()
+	defer n.deadMu.Unlock()
+
+	f, err := os.OpenFile(n.cfg.DeadLetterFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		log.Printf("Webhook: error opening dead-letter log: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("Webhook: error writing dead-letter log: %v", err)
+	}
+}
+
+// setupWebhooks connects configured endpoints to the alert engine
+func (app *App) setupWebhooks() error {
+	cfg := app.config.Webhooks
+	if len(cfg.Endpoints) == 0 {
+		return nil
+	}
+
+	n, err := newWebhookNotifier(cfg)
+	if err != nil {
+		return err
+	}
+	app.alerts.subscribe(n.notify)
+	app.goBackground(n.run)
+
+	app.metrics.register("wttr_webhook_deliveries_total", "counter",
+		"Webhook deliveries by outcome.", func() []metricSample {
+			return []metricSample{
+				{Labels: map[string]string{"outcome": "delivered"}, Value: float64(n.delivered.Load())},
+				{Labels: map[string]string{"outcome": "failed"}, Value: float64(n.failed.Load())},
+			}
+		})
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testWebhooksConfig returns settings with short backoffs for endpoints
+func testWebhooksConfig(endpoints ...WebhookEndpoint) WebhooksConfig {
+	cfg := DefaultConfig().Webhooks
+	cfg.Endpoints = endpoints
+	cfg.Timeout = Duration{5 * time.Second}
+	cfg.InitialBackoff = Duration{time.Millisecond}
+	cfg.MaxBackoff = Duration{5 * time.Millisecond}
+	return cfg
+}
+
+// startWebhooks builds a notifier from cfg and runs it until the test ends
+func startWebhooks(t *testing.T, cfg WebhooksConfig) *webhookNotifier {
+	t.Helper()
+	n, err := newWebhookNotifier(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return n
+}
+
+func testAlertEvent() AlertEvent {
+	return AlertEvent{
+		Type:      AlertEventFired,
+		Alert:     Alert{ID: "cold-oslo", Rule: "Cold", Location: "Oslo"},
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan received, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- received{r.Header, body}
+	}))
+	defer srv.Close()
+
+	n := startWebhooks(t, testWebhooksConfig(WebhookEndpoint{Name: "ops", URL: srv.URL, Secret: "s3cret"}))
+	event := testAlertEvent()
+	n.notify(event)
+
+	var req received
+	select {
+	case req = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+
+	want, _ := json.Marshal(event)
+	if string(req.body) != string(want) {
+		t.Errorf("body = %s, want %s", req.body, want)
+	}
+	if e := req.header.Get("X-Webhook-Event"); e != AlertEventFired {
+		t.Errorf("event header = %q", e)
+	}
+	ts, err := strconv.ParseInt(req.header.Get("X-Webhook-Timestamp"), 10, 64)
+	if err != nil {
+		t.Fatalf("timestamp header: %v", err)
+	}
+	if sig := req.header.Get("X-Webhook-Signature"); sig != sign("s3cret", ts, req.body) {
+		t.Errorf("signature %q does not verify", sig)
+	}
+	if sign("other", ts, req.body) == sign("s3cret", ts, req.body) {
+		t.Error("signature does not depend on the secret")
+	}
+	waitFor(t, "the delivery to be counted", func() bool { return n.delivered.Load() == 1 })
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	got := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- string(body)
+	}))
+	defer srv.Close()
+
+	n := startWebhooks(t, testWebhooksConfig(WebhookEndpoint{
+		Name:     "slack",
+		URL:      srv.URL,
+		Template: `{"text":{{json .Alert.Location}}}`,
+	}))
+	n.notify(testAlertEvent())
+
+	select {
+	case body := <-got:
+		if body != `{"text":"Oslo"}` {
+			t.Errorf("body = %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not delivered")
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	n := startWebhooks(t, testWebhooksConfig(WebhookEndpoint{Name: "ops", URL: srv.URL}))
+	n.notify(testAlertEvent())
+
+	waitFor(t, "the delivery to succeed", func() bool { return n.delivered.Load() == 1 })
+	if h := hits.Load(); h != 3 {
+		t.Errorf("endpoint hit %d times, want 3", h)
+	}
+	if f := n.failed.Load(); f != 0 {
+		t.Errorf("%d deliveries failed", f)
+	}
+}
+
+func TestWebhookDeadLetters(t *testing.T) {
+	var retriedHits, rejectedHits atomic.Int32
+	retried := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		retriedHits.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer retried.Close()
+	rejected := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rejectedHits.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejected.Close()
+
+	cfg := testWebhooksConfig(
+		WebhookEndpoint{Name: "down", URL: retried.URL},
+		WebhookEndpoint{Name: "strict", URL: rejected.URL},
+	)
+	cfg.MaxAttempts = 3
+	cfg.DeadLetterFile = filepath.Join(t.TempDir(), "dead.jsonl")
+	n := startWebhooks(t, cfg)
+	n.notify(testAlertEvent())
+
+	waitFor(t, "both deliveries to fail", func() bool { return n.failed.Load() == 2 })
+	if h := retriedHits.Load(); h != 3 {
+		t.Errorf("5xx endpoint hit %d times, want 3", h)
+	}
+	if h := rejectedHits.Load(); h != 1 {
+		t.Errorf("4xx endpoint hit %d times, want 1", h)
+	}
+
+	b, err := os.ReadFile(cfg.DeadLetterFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts := make(map[string]int)
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var dl deadLetter
+		if err := json.Unmarshal([]byte(line), &dl); err != nil {
+			t.Fatalf("dead letter %q: %v", line, err)
+		}
+		if dl.Event != AlertEventFired || dl.AlertID != "cold-oslo" {
+			t.Errorf("unexpected dead letter %+v", dl)
+		}
+		attempts[dl.Endpoint] = dl.Attempts
+	}
+	if attempts["down"] != 3 || attempts["strict"] != 1 {
+		t.Errorf("dead-letter attempts = %v, want down:3 strict:1", attempts)
+	}
+}
+
+func TestWebhookFailingEndpointDoesNotBlockOthers(t *testing.T) {
+	stuck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer stuck.Close()
+	var healthyHits atomic.Int32
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		healthyHits.Add(1)
+	}))
+	defer healthy.Close()
+
+	cfg := testWebhooksConfig(
+		WebhookEndpoint{Name: "stuck", URL: stuck.URL},
+		WebhookEndpoint{Name: "healthy", URL: healthy.URL},
+	)
+	// The stuck endpoint sleeps far longer than the test between attempts
+	cfg.InitialBackoff = Duration{time.Hour}
+	cfg.MaxBackoff = Duration{time.Hour}
+	n := startWebhooks(t, cfg)
+
+	for i := 0; i < 3; i++ {
+		n.notify(testAlertEvent())
+	}
+	waitFor(t, "the healthy endpoint to receive every event", func() bool { return healthyHits.Load() == 3 })
+}
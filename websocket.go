@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsMessage is the JSON envelope used in both directions.
+//
+// Client to server:
+//
+//	{"type": "subscribe", "location": "Oslo"}
+//	{"type": "unsubscribe", "location": "Oslo"}
+//
+// Server to client: the subscribe and unsubscribe messages are echoed back
+// as acknowledgements, followed by
+//
+//	{"type": "update", "location": "Oslo", "data": {...}}
+//	{"type": "error", "location": "Oslo", "error": "..."}
+type wsMessage struct {
+	Type     string         `json:"type"`
+	Location string         `json:"location,omitempty"`
+	Data     *weatherUpdate `json:"data,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+const (
+	wsTypeSubscribe   = "subscribe"
+	wsTypeUnsubscribe = "unsubscribe"
+	wsTypeUpdate      = "update"
+	wsTypeError       = "error"
+)
+
+// wsClient is one WebSocket connection and its subscriptions
+type wsClient struct {
+	app  *App
+	conn *websocket.Conn
+	send chan wsMessage
+
+	mu     sync.Mutex
+	subs   map[string]*subscription
+	closed bool
+	done   chan struct{}
+}
+
+// newUpgrader returns an upgrader that accepts same-origin requests and the
+// configured extra origins
+func newUpgrader(cfg WebSocketConfig) *websocket.Upgrader {
+	allowed := make(map[string]bool, len(cfg.AllowedOrigins))
+	for _, origin := range cfg.AllowedOrigins {
+		allowed[strings.ToLower(origin)] = true
+	}
+
+	return &websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 4096,
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" || allowed[strings.ToLower(origin)] {
+				return true
+			}
+			host := strings.TrimPrefix(strings.TrimPrefix(origin, "https://"), "http://")
+			return strings.EqualFold(host, r.Host)
+		},
+	}
+}
+
+// websocketHandler upgrades the connection and serves the subscription
+// protocol until the client leaves, falls behind or the server shuts down
+func (app *App) websocketHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := app.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an error response
+		return
+	}
+
+	c := &wsClient{
+		app:  app,
+		conn: conn,
+		send: make(chan wsMessage, WSSendBuffer),
+		subs: make(map[string]*subscription),
+		done: make(chan struct{}),
+	}
+
+	go c.writeLoop()
+	c.readLoop()
+}
+
+// readLoop handles client messages; it owns closing the connection
+func (c *wsClient) readLoop() {
+	defer c.close()
+
+	c.conn.SetReadLimit(WSMaxMessageBytes)
+	c.conn.SetReadDeadline(time.Now().Add(WSPongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(WSPongWait))
+	})
+
+	for {
+		_, b, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("WebSocket read error: %v", err)
+			}
+			return
+		}
+		var msg wsMessage
+		if err := json.Unmarshal(b, &msg); err != nil {
+			c.enqueue(wsMessage{Type: wsTypeError, Error: ErrWSInvalidMessage})
+			continue
+		}
+
+		switch msg.Type {
+		case wsTypeSubscribe:
+			c.subscribe(msg.Location)
+		case wsTypeUnsubscribe:
+			c.unsubscribe(msg.Location)
+		default:
+			c.enqueue(wsMessage{Type: wsTypeError, Error: ErrWSUnknownMessage})
+		}
+	}
+}
+
+// subscribe follows a location and forwards its updates to the client.
+// Nothing is subscribed once the connection is closed, since closeLocked
+// has already released the poller subscriptions.
+func (c *wsClient) subscribe(location string) {
+	location = strings.TrimSpace(location)
+	if location == "" {
+		c.enqueue(wsMessage{Type: wsTypeError, Error: ErrEmptyLocation})
+		return
+	}
+	key := locationKey(location)
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	if _, ok := c.subs[key]; ok {
+		c.mu.Unlock()
+		c.enqueue(wsMessage{Type: wsTypeSubscribe, Location: location})
+		return
+	}
+	if len(c.subs) >= c.app.config.WebSocket.MaxSubscriptions {
+		c.mu.Unlock()
+		c.enqueue(wsMessage{Type: wsTypeError, Location: location, Error: ErrWSTooManySubscriptions})
+		return
+	}
+	sub := c.app.poller.subscribe(location)
+	c.subs[key] = sub
+	c.mu.Unlock()
+
+	c.enqueue(wsMessage{Type: wsTypeSubscribe, Location: location})
+	go c.forward(location, sub)
+}
+
+// unsubscribe stops following a location
+func (c *wsClient) unsubscribe(location string) {
+	key := locationKey(location)
+
+	c.mu.Lock()
+	sub, ok := c.subs[key]
+	delete(c.subs, key)
+	c.mu.Unlock()
+
+	if !ok {
+		c.enqueue(wsMessage{Type: wsTypeError, Location: location, Error: ErrWSNotSubscribed})
+		return
+	}
+	c.app.poller.unsubscribe(sub)
+	c.enqueue(wsMessage{Type: wsTypeUnsubscribe, Location: location})
+}
+
+// forward relays poller updates and fetch errors for one subscription. If
+// the poller drops the subscription for falling behind, the connection is
+// closed too, since the client would otherwise silently miss updates.
+func (c *wsClient) forward(location string, sub *subscription) {
+	for {
+		var msg wsMessage
+		select {
+		case update, ok := <-sub.ch:
+			if !ok {
+				// On shutdown writeLoop closes the connection itself, after
+				// sending the going-away close frame
+				if c.app.ctx.Err() == nil {
+					c.closeIfCurrent(sub)
+				}
+				return
+			}
+			msg = wsMessage{Type: wsTypeUpdate, Location: location, Data: &update}
+		case <-sub.errs:
+			msg = wsMessage{Type: wsTypeError, Location: location, Error: ErrFetchWeatherData}
+		}
+		if !c.enqueue(msg) {
+			return
+		}
+	}
+}
+
+// closeIfCurrent closes the connection when sub, which the poller has
+// closed, is still the client's subscription for its location
+func (c *wsClient) closeIfCurrent(sub *subscription) {
+	c.mu.Lock()
+	current, stillSubscribed := c.subs[sub.key]
+	c.mu.Unlock()
+	if stillSubscribed && current == sub {
+		c.close()
+	}
+}
+
+// enqueue queues msg for the writer. A full queue means the client cannot
+// keep up, so it is disconnected instead of buffering without bound.
+func (c *wsClient) enqueue(msg wsMessage) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	select {
+	case c.send <- msg:
+		return true
+	default:
+		log.Printf("WebSocket client too slow, disconnecting")
+		c.closeLocked()
+		return false
+	}
+}
+
+// writeLoop sends queued messages and keep-alive pings
+func (c *wsClient) writeLoop() {
+	ping := time.NewTicker(WSPingPeriod)
+	defer ping.Stop()
+
+	for {
+		select {
+		case msg := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(WSWriteWait))
+			if err := c.conn.WriteJSON(msg); err != nil {
+				c.close()
+				return
+			}
+		case <-ping.C:
+			c.conn.SetWriteDeadline(time.Now().Add(WSWriteWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.close()
+				return
+			}
+		case <-c.app.ctx.Done():
+			c.conn.SetWriteDeadline(time.Now().Add(WSWriteWait))
+			c.conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+			c.close()
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// close releases the subscriptions and the connection; safe to call twice
+func (c *wsClient) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeLocked()
+}
+
+// closeLocked is close with mu held
+func (c *wsClient) closeLocked() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.done)
+
+	subs := c.subs
+	c.subs = make(map[string]*subscription)
+	for _, sub := range subs {
+		c.app.poller.unsubscribe(sub)
+	}
+	c.conn.Close()
+}
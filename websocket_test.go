@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialWebSocket serves an app built from cfg, with a fake upstream, and
+// connects to its WebSocket endpoint
+func dialWebSocket(t *testing.T, cfg *Config, upstream http.Handler) (*App, *websocket.Conn) {
+	t.Helper()
+	app := newTestApp(t, cfg)
+	fakeUpstream(t, app, upstream)
+
+	srv := httptest.NewServer(app.routes())
+	t.Cleanup(srv.Close)
+
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/v1/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	t.Cleanup(func() { conn.Close() })
+	return app, conn
+}
+
+// sendWS writes msg as a text message
+func sendWS(t *testing.T, conn *websocket.Conn, msg string) {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectWS reads messages until one of type typ arrives, skipping updates
+// when waiting for something else
+func expectWS(t *testing.T, conn *websocket.Conn, typ string) wsMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg wsMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("waiting for %s: %v", typ, err)
+		}
+		if msg.Type == typ {
+			return msg
+		}
+		if msg.Type != wsTypeUpdate {
+			t.Fatalf("got %+v, want a %s message", msg, typ)
+		}
+	}
+}
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebSocketSubscribe(t *testing.T) {
+	_, conn := dialWebSocket(t, DefaultConfig(), serveWeather(testWeatherData("Oslo", 12)))
+
+	sendWS(t, conn, `{"type":"subscribe","location":" Oslo "}`)
+	if ack := expectWS(t, conn, wsTypeSubscribe); ack.Location != "Oslo" {
+		t.Errorf("ack location = %q, want Oslo", ack.Location)
+	}
+	update := expectWS(t, conn, wsTypeUpdate)
+	if update.Location != "Oslo" || update.Data == nil || update.Data.Location != "Oslo, Norway" {
+		t.Errorf("unexpected update %+v", update)
+	}
+	if update.Data.Temperature != "12°C (53°F)" {
+		t.Errorf("temperature = %q", update.Data.Temperature)
+	}
+
+	// Subscribing again is acknowledged without a second subscription
+	sendWS(t, conn, `{"type":"subscribe","location":"oslo"}`)
+	expectWS(t, conn, wsTypeSubscribe)
+}
+
+func TestWebSocketUnsubscribe(t *testing.T) {
+	app, conn := dialWebSocket(t, DefaultConfig(), serveWeather(testWeatherData("Oslo", 12)))
+
+	sendWS(t, conn, `{"type":"subscribe","location":"Oslo"}`)
+	expectWS(t, conn, wsTypeSubscribe)
+	waitFor(t, "the poller subscription", func() bool { return app.poller.subscriberCount() == 1 })
+
+	sendWS(t, conn, `{"type":"unsubscribe","location":"Oslo"}`)
+	if ack := expectWS(t, conn, wsTypeUnsubscribe); ack.Location != "Oslo" {
+		t.Errorf("ack location = %q, want Oslo", ack.Location)
+	}
+	waitFor(t, "the poller subscription to be released", func() bool { return app.poller.subscriberCount() == 0 })
+
+	sendWS(t, conn, `{"type":"unsubscribe","location":"Oslo"}`)
+	if msg := expectWS(t, conn, wsTypeError); msg.Error != ErrWSNotSubscribed {
+		t.Errorf("error = %q, want %q", msg.Error, ErrWSNotSubscribed)
+	}
+}
+
+func TestWebSocketPing(t *testing.T) {
+	_, conn := dialWebSocket(t, DefaultConfig(), serveWeather(testWeatherData("Oslo", 12)))
+
+	pong := make(chan string, 1)
+	conn.SetPongHandler(func(data string) error {
+		pong <- data
+		return nil
+	})
+	if err := conn.WriteControl(websocket.PingMessage, []byte("hello"), time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	// Control frames are handled while reading, so trigger a reply to read
+	sendWS(t, conn, `{"type":"subscribe","location":"Oslo"}`)
+	expectWS(t, conn, wsTypeSubscribe)
+
+	select {
+	case data := <-pong:
+		if data != "hello" {
+			t.Errorf("pong payload = %q, want hello", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no pong received")
+	}
+}
+
+func TestWebSocketBadMessages(t *testing.T) {
+	_, conn := dialWebSocket(t, DefaultConfig(), serveWeather(testWeatherData("Oslo", 12)))
+
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{`not json`, ErrWSInvalidMessage},
+		{`["subscribe"]`, ErrWSInvalidMessage},
+		{`{"type":"publish","location":"Oslo"}`, ErrWSUnknownMessage},
+		{`{"location":"Oslo"}`, ErrWSUnknownMessage},
+		{`{"type":"subscribe","location":"  "}`, ErrEmptyLocation},
+	}
+	for _, tt := range tests {
+		sendWS(t, conn, tt.msg)
+		if got := expectWS(t, conn, wsTypeError); got.Error != tt.want {
+			t.Errorf("%s: error = %q, want %q", tt.msg, got.Error, tt.want)
+		}
+	}
+
+	// The connection survives bad messages
+	sendWS(t, conn, `{"type":"subscribe","location":"Oslo"}`)
+	expectWS(t, conn, wsTypeSubscribe)
+
+	// but not oversized ones
+	sendWS(t, conn, `{"type":"subscribe","location":"`+strings.Repeat("x", WSMaxMessageBytes)+`"}`)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != websocket.CloseMessageTooBig {
+				t.Errorf("read error = %v, want close 1009", err)
+			}
+			break
+		}
+	}
+}
+
+func TestWebSocketMaxSubscriptions(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.WebSocket.MaxSubscriptions = 1
+	_, conn := dialWebSocket(t, cfg, serveWeather(testWeatherData("Oslo", 12)))
+
+	sendWS(t, conn, `{"type":"subscribe","location":"Oslo"}`)
+	expectWS(t, conn, wsTypeSubscribe)
+	sendWS(t, conn, `{"type":"subscribe","location":"Bergen"}`)
+	if msg := expectWS(t, conn, wsTypeError); msg.Error != ErrWSTooManySubscriptions || msg.Location != "Bergen" {
+		t.Errorf("unexpected %+v", msg)
+	}
+}
+
+func TestWebSocketFetchError(t *testing.T) {
+	_, conn := dialWebSocket(t, DefaultConfig(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unknown location", http.StatusNotFound)
+	}))
+
+	sendWS(t, conn, `{"type":"subscribe","location":"Nowhere"}`)
+	expectWS(t, conn, wsTypeSubscribe)
+	if msg := expectWS(t, conn, wsTypeError); msg.Location != "Nowhere" || msg.Error != ErrFetchWeatherData {
+		t.Errorf("unexpected %+v", msg)
+	}
+}
+
+func TestWebSocketClientClose(t *testing.T) {
+	app, conn := dialWebSocket(t, DefaultConfig(), serveWeather(testWeatherData("Oslo", 12)))
+
+	sendWS(t, conn, `{"type":"subscribe","location":"Oslo"}`)
+	sendWS(t, conn, `{"type":"subscribe","location":"Bergen"}`)
+	expectWS(t, conn, wsTypeSubscribe)
+	expectWS(t, conn, wsTypeSubscribe)
+	waitFor(t, "two subscriptions", func() bool { return app.poller.subscriberCount() == 2 })
+
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	conn.Close()
+	waitFor(t, "subscriptions to be released", func() bool { return app.poller.subscriberCount() == 0 })
+}
+
+func TestWebSocketServerShutdown(t *testing.T) {
+	app, conn := dialWebSocket(t, DefaultConfig(), serveWeather(testWeatherData("Oslo", 12)))
+
+	sendWS(t, conn, `{"type":"subscribe","location":"Oslo"}`)
+	expectWS(t, conn, wsTypeSubscribe)
+	app.cancel()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Errorf("read error = %v, want close 1001", err)
+			}
+			break
+		}
+	}
+}
+
+func TestWebSocketSubscribeAfterClose(t *testing.T) {
+	app := newTestApp(t, DefaultConfig())
+	c := &wsClient{
+		app:    app,
+		send:   make(chan wsMessage, WSSendBuffer),
+		subs:   make(map[string]*subscription),
+		closed: true,
+		done:   make(chan struct{}),
+	}
+	c.subscribe("Oslo")
+	if n := app.poller.subscriberCount(); n != 0 {
+		t.Fatalf("closed client left %d poller subscriptions", n)
+	}
+}
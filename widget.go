@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Self-contained SVG widgets for <img> embedding. They compose the
+// weatherIcons SVGs with text set in the viewer's system font; there are no
+// external fonts, stylesheets or scripts, so they render anywhere an image
+// does.
+
+// widgetTheme is the palette of a widget. An empty Background leaves the
+// widget transparent.
+type widgetTheme struct {
+	Background string
+	Border     string
+	Panel      string
+	Text       string
+	Muted      string
+}
+
+var widgetThemes = map[string]widgetTheme{
+	"light": {
+		Background: "#ffffff",
+		Border:     "#dfe6e9",
+		Panel:      "#f1f2f6",
+		Text:       "#2d3436",
+		Muted:      "#636e72",
+	},
+	"dark": {
+		Background: "#1e272e",
+		Border:     "#2f3640",
+		Panel:      "#2f3640",
+		Text:       "#f5f6fa",
+		Muted:      "#b2bec3",
+	},
+	"transparent": {
+		Text:  "#2d3436",
+		Muted: "#636e72",
+	},
+}
+
+const widgetFontFamily = "system-ui, -apple-system, 'Segoe UI', Roboto, Helvetica, Arial, sans-serif"
+
+// widgetHandler serves GET /widget/{location}.svg
+func (app *App) widgetHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	themeName := q.Get("theme")
+	if themeName == "" {
+		themeName = "light"
+	}
+	theme, ok := widgetThemes[themeName]
+	if !ok {
+		writeText(w, http.StatusBadRequest, "theme must be light, dark or transparent")
+		return
+	}
+	size := q.Get("size")
+	if size == "" {
+		size = "full"
+	}
+	if size != "full" && size != "compact" {
+		writeText(w, http.StatusBadRequest, "size must be full or compact")
+		return
+	}
+
+	location := strings.TrimSpace(mux.Vars(r)["location"])
+	weatherData, ok := app.fetchForText(w, r, location)
+	if !ok {
+		return
+	}
+	data := app.processWeatherData(weatherData)
+	current := weatherData.CurrentCondition[0]
+
+	var svg string
+	if size == "compact" {
+		svg = renderCompactWidget(data, current, theme)
+	} else {
+		svg = renderFullWidget(data, current, theme)
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(svg)))
+	app.setImageCacheHeaders(w)
+	w.Write([]byte(svg))
+}
+
+// svgBuilder writes widget elements
+type svgBuilder struct {
+	strings.Builder
+}
+
+func (b *svgBuilder) open(width, height int, theme widgetTheme, title string) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="%s" role="img" aria-label="%s">`,
+		width, height, width, height, template.HTMLEscapeString(widgetFontFamily), template.HTMLEscapeString(title))
+	fmt.Fprintf(b, `<title>%s</title>`, template.HTMLEscapeString(title))
+	if theme.Background != "" {
+		fmt.Fprintf(b, `<rect x="0.5" y="0.5" width="%d" height="%d" rx="12" fill="%s" stroke="%s"/>`,
+			width-1, height-1, theme.Background, theme.Border)
+	}
+}
+
+func (b *svgBuilder) close() string {
+	b.WriteString(`</svg>`)
+	return b.String()
+}
+
+// icon embeds a weatherIcons SVG as a nested viewport
+func (b *svgBuilder) icon(condition string, x, y, size float64) {
+	icon := getWeatherIcon(condition)
+	b.WriteString(strings.Replace(icon, "<svg ",
+		fmt.Sprintf(`<svg x="%g" y="%g" width="%g" height="%g" `, x, y, size, size), 1))
+}
+
+// text writes s with its baseline at y. anchor is start, middle or end.
+func (b *svgBuilder) text(s string, x, y float64, size int, bold bool, color, anchor string) {
+	weight := ""
+	if bold {
+		weight = ` font-weight="bold"`
+	}
+	fmt.Fprintf(b, `<text x="%g" y="%g" font-size="%d"%s fill="%s" text-anchor="%s">%s</text>`,
+		x, y, size, weight, color, anchor, template.HTMLEscapeString(s))
+}
+
+// renderFullWidget shows the current conditions above the forecast strip
+func renderFullWidget(data PageData, current CurrentCondition, theme widgetTheme) string {
+	var b svgBuilder
+	b.open(WidgetFullWidth, WidgetFullHeight, theme, data.Location+": "+current.TempC+"°C, "+data.Description)
+
+	b.icon(data.Description, 14, 12, 72)
+	b.text(truncate(data.Location, 30), 96, 34, 15, true, theme.Text, "start")
+	b.text(current.TempC+"°C", 96, 70, 32, true, theme.Text, "start")
+	b.text(truncate(data.Description, 34), 96, 92, 13, false, theme.Muted, "start")
+
+	if n := len(data.Forecast); n > 0 {
+		const pad, top, height, gap = 12.0, 108.0, 80.0, 8.0
+		width := (WidgetFullWidth - 2*pad - float64(n-1)*gap) / float64(n)
+		for i, day := range data.Forecast {
+			x := pad + float64(i)*(width+gap)
+			mid := x + width/2
+			if theme.Panel != "" {
+				fmt.Fprintf(&b, `<rect x="%g" y="%g" width="%g" height="%g" rx="8" fill="%s"/>`, x, top, width, height, theme.Panel)
+			}
+			b.text(day.Day, mid, top+17, 12, true, theme.Text, "middle")
+			b.icon(day.Description, mid-16, top+22, 32)
+			b.text(day.Temperature, mid, top+70, 12, false, theme.Text, "middle")
+		}
+	}
+	return b.close()
+}
+
+// renderCompactWidget shows only the current conditions
+func renderCompactWidget(data PageData, current CurrentCondition, theme widgetTheme) string {
+	var b svgBuilder
+	b.open(WidgetCompactWidth, WidgetCompactHeight, theme, data.Location+": "+current.TempC+"°C, "+data.Description)
+
+	b.icon(data.Description, 10, 14, 60)
+	b.text(current.TempC+"°C", 78, 46, 28, true, theme.Text, "start")
+	b.text(truncate(data.Location, 16), 78, 66, 11, false, theme.Muted, "start")
+	return b.close()
+}
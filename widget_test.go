@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWidgetHandler(t *testing.T) {
+	app := newTestApp(t, DefaultConfig())
+	fakeUpstream(t, app, serveWeather(testWeatherData("Tromsø & <Co>", 12)))
+	handler := app.routes()
+
+	for _, target := range []string{
+		"/widget/Tromsø.svg",
+		"/widget/Tromsø.svg?size=compact",
+		"/widget/Tromsø.svg?theme=dark",
+		"/widget/Tromsø.svg?theme=transparent&size=compact",
+	} {
+		rec := getRoute(handler, target, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET %s: status %d: %s", target, rec.Code, rec.Body)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "image/svg+xml; charset=utf-8" {
+			t.Errorf("GET %s: content type %q", target, ct)
+		}
+		// The location name is escaped, so the document still parses
+		parseSVG(t, rec.Body.String())
+		if !strings.Contains(rec.Body.String(), "Tromsø &amp; &lt;Co&gt;") {
+			t.Errorf("GET %s: location name missing", target)
+		}
+	}
+
+	for _, target := range []string{
+		"/widget/Tromsø.svg?theme=neon",
+		"/widget/Tromsø.svg?size=huge",
+	} {
+		if rec := getRoute(handler, target, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: status %d, want 400", target, rec.Code)
+		}
+	}
+}